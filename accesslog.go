@@ -0,0 +1,309 @@
+package webmod
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Formats of the lines written by an AccessLogWriter
+const (
+	AccessLogJSON     = "json"
+	AccessLogCommon   = "common"
+	AccessLogCombined = "combined"
+)
+
+// redacted replaces the values of sensitive query parameters and headers
+const redacted = "REDACTED"
+
+// AccessLogRecord describes a request and its response. URI and Headers
+// are redacted, and Headers holds only the request headers listed in the
+// AccessLogOptions.
+type AccessLogRecord struct {
+	Time       time.Time
+	RemoteAddr string
+	User       string
+	Method     string
+	URI        string
+	Proto      string
+	Status     int
+	Bytes      int64
+	Duration   time.Duration
+	RequestID  string
+	Referer    string
+	UserAgent  string
+	Headers    map[string]string
+}
+
+// AccessLogger receives the records of the requests logged by AccessLog
+type AccessLogger interface {
+	LogAccess(record AccessLogRecord)
+}
+
+// AccessLogOptions configures AccessLog. Logger defaults to an
+// AccessLogWriter writing JSON lines to the standard error.
+//
+// A SampleRate between 0 and 1 only logs that fraction of the requests,
+// but responses with an error status are always logged.
+//
+// The values of the query parameters in RedactQuery and the headers in
+// RedactHeaders are replaced by "REDACTED", with defaults covering the usual
+// tokens, passwords and signatures. Headers are the request headers added
+// to the records, e.g. "X-Forwarded-For".
+type AccessLogOptions struct {
+	Logger        AccessLogger
+	SampleRate    float64
+	RedactQuery   []string
+	RedactHeaders []string
+	Headers       []string
+}
+
+// withDefaults returns the options with the defaults set for zero values
+func (o AccessLogOptions) withDefaults() AccessLogOptions {
+	if o.Logger == nil {
+		o.Logger = &AccessLogWriter{Out: os.Stderr}
+	}
+	if o.RedactQuery == nil {
+		o.RedactQuery = []string{"access_token", "api_key", "code", "key", "password", "secret", signedURLSignature, "token"}
+	}
+	if o.RedactHeaders == nil {
+		o.RedactHeaders = []string{"Authorization", "Cookie", "Proxy-Authorization", "X-API-Key"}
+	}
+	return o
+}
+
+// AccessLog is middleware logging every request to next, with the status,
+// size and duration of its response, through opts.Logger. The request ID
+// set by RequestID is included, whichever middleware runs first.
+func (t *Tools) AccessLog(next http.Handler, opts AccessLogOptions) http.Handler {
+	opts = opts.withDefaults()
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		aw := &accessLogWriter{wrappedWriter: wrappedWriter{w}}
+		// the record is made after next, which may change the request
+		uri := r.RequestURI
+		if uri == "" {
+			uri = r.URL.RequestURI()
+		}
+		user, _, _ := r.BasicAuth()
+
+		defer func() {
+			// a panic is logged as the 500 answered by Recover
+			status := aw.status
+			if p := recover(); p != nil {
+				if status == 0 && p != http.ErrAbortHandler {
+					status = http.StatusInternalServerError
+				}
+				defer panic(p)
+			}
+			if status == 0 {
+				status = http.StatusOK
+			}
+			if status < 400 && opts.SampleRate > 0 && opts.SampleRate < 1 && rand.Float64() >= opts.SampleRate {
+				return
+			}
+
+			record := AccessLogRecord{
+				Time:       start,
+				RemoteAddr: clientIP(r),
+				User:       user,
+				Method:     r.Method,
+				URI:        redactQuery(uri, opts.RedactQuery),
+				Proto:      r.Proto,
+				Status:     status,
+				Bytes:      aw.bytes,
+				Duration:   time.Since(start),
+				RequestID:  w.Header().Get(t.requestIDHeader()),
+				Referer:    redactQuery(r.Referer(), opts.RedactQuery),
+				UserAgent:  r.UserAgent(),
+			}
+			if record.RequestID == "" {
+				record.RequestID, _ = t.RequestIDFromContext(r.Context())
+			}
+			for _, h := range opts.Headers {
+				v := r.Header.Get(h)
+				if v == "" {
+					continue
+				}
+				if containsFold(opts.RedactHeaders, h) {
+					v = redacted
+				}
+				if record.Headers == nil {
+					record.Headers = map[string]string{}
+				}
+				record.Headers[http.CanonicalHeaderKey(h)] = v
+			}
+			opts.Logger.LogAccess(record)
+		}()
+		next.ServeHTTP(exposeWriter(aw), r)
+	})
+}
+
+// redactQuery replaces the values of the query parameters of uri named in
+// names, keeping the order of the parameters.
+func redactQuery(uri string, names []string) string {
+	i := strings.Index(uri, "?")
+	if i < 0 || len(names) == 0 {
+		return uri
+	}
+
+	params := strings.Split(uri[i+1:], "&")
+	for j, p := range params {
+		k := p
+		if eq := strings.Index(p, "="); eq >= 0 {
+			k = p[:eq]
+		}
+		if name, err := url.QueryUnescape(k); err == nil && containsFold(names, name) {
+			params[j] = k + "=" + redacted
+		}
+	}
+	return uri[:i+1] + strings.Join(params, "&")
+}
+
+// AccessLogWriter is an AccessLogger writing a line per record to Out, in
+// Format: AccessLogJSON (the default), AccessLogCommon or AccessLogCombined.
+// It can be used by several goroutines.
+type AccessLogWriter struct {
+	Out    io.Writer
+	Format string
+	mu     sync.Mutex
+}
+
+// LogAccess writes a record
+func (l *AccessLogWriter) LogAccess(record AccessLogRecord) {
+	var line []byte
+	switch l.Format {
+	case AccessLogCommon, AccessLogCombined:
+		line = []byte(clfLine(record, l.Format == AccessLogCombined))
+	default:
+		var err error
+		line, err = json.Marshal(accessLogJSON{
+			Time:       record.Time.UTC().Format(time.RFC3339Nano),
+			RemoteAddr: record.RemoteAddr,
+			User:       record.User,
+			Method:     record.Method,
+			URI:        record.URI,
+			Proto:      record.Proto,
+			Status:     record.Status,
+			Bytes:      record.Bytes,
+			DurationMS: float64(record.Duration) / float64(time.Millisecond),
+			RequestID:  record.RequestID,
+			Referer:    record.Referer,
+			UserAgent:  record.UserAgent,
+			Headers:    record.Headers,
+		})
+		if err != nil {
+			return
+		}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.Out.Write(append(line, '\n'))
+}
+
+// accessLogJSON is the JSON line of a record
+type accessLogJSON struct {
+	Time       string            `json:"time"`
+	RemoteAddr string            `json:"remote_addr"`
+	User       string            `json:"user,omitempty"`
+	Method     string            `json:"method"`
+	URI        string            `json:"uri"`
+	Proto      string            `json:"proto"`
+	Status     int               `json:"status"`
+	Bytes      int64             `json:"bytes"`
+	DurationMS float64           `json:"duration_ms"`
+	RequestID  string            `json:"request_id,omitempty"`
+	Referer    string            `json:"referer,omitempty"`
+	UserAgent  string            `json:"user_agent,omitempty"`
+	Headers    map[string]string `json:"headers,omitempty"`
+}
+
+// clfLine returns a record in the Common Log Format, or the Combined Log
+// Format with the referer and user agent, followed by the request ID.
+func clfLine(record AccessLogRecord, combined bool) string {
+	field := func(s string) string {
+		if s == "" {
+			return "-"
+		}
+		return clfEscape(s)
+	}
+	size := "-"
+	if record.Bytes > 0 {
+		size = strconv.FormatInt(record.Bytes, 10)
+	}
+
+	line := fmt.Sprintf(`%s - %s [%s] "%s %s %s" %d %s`,
+		field(record.RemoteAddr), field(record.User), record.Time.Format("02/Jan/2006:15:04:05 -0700"),
+		clfEscape(record.Method), clfEscape(record.URI), clfEscape(record.Proto), record.Status, size)
+	if combined {
+		line += fmt.Sprintf(` "%s" "%s"`, field(record.Referer), field(record.UserAgent))
+	}
+	if record.RequestID != "" {
+		line += " " + clfEscape(record.RequestID)
+	}
+	return line
+}
+
+// clfEscape escapes quotes, backslashes and control characters, so that
+// values cannot break out of their field or forge log lines
+func clfEscape(s string) string {
+	q := strconv.Quote(s)
+	return q[1 : len(q)-1]
+}
+
+// accessLogWriter is a ResponseWriter recording the status and
+// size of the response
+type accessLogWriter struct {
+	wrappedWriter
+	status int
+	bytes  int64
+}
+
+func (aw *accessLogWriter) WriteHeader(status int) {
+	// informational headers are followed by the final one
+	if aw.status == 0 && status >= 200 {
+		aw.status = status
+	}
+	aw.ResponseWriter.WriteHeader(status)
+}
+
+func (aw *accessLogWriter) Write(p []byte) (int, error) {
+	if aw.status == 0 {
+		aw.status = http.StatusOK
+	}
+	n, err := aw.ResponseWriter.Write(p)
+	aw.bytes += int64(n)
+	return n, err
+}
+
+// Flush sends any buffered data to the client, if the original
+// ResponseWriter supports it
+func (aw *accessLogWriter) Flush() {
+	aw.flush(func() {
+		if aw.status == 0 {
+			aw.status = http.StatusOK
+		}
+	})
+}
+
+// Hijack lets the caller take over the connection,
+// if the original ResponseWriter supports it
+func (aw *accessLogWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := aw.hijack(nil)
+	if err == nil && aw.status == 0 {
+		aw.status = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
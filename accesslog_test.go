@@ -0,0 +1,219 @@
+package webmod
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingAccessLogger keeps the records it receives
+type recordingAccessLogger struct {
+	mu      sync.Mutex
+	records []AccessLogRecord
+}
+
+func (l *recordingAccessLogger) LogAccess(record AccessLogRecord) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.records = append(l.records, record)
+}
+
+func TestTools_AccessLog(t *testing.T) {
+	var testTool Tools
+
+	tests := []struct {
+		name     string
+		handler  http.HandlerFunc
+		uri      string
+		header   map[string]string
+		opts     AccessLogOptions
+		expected AccessLogRecord
+	}{
+		{name: "Implicit status", handler: func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("hello")) }, uri: "/hello", expected: AccessLogRecord{Method: "GET", URI: "/hello", Status: http.StatusOK, Bytes: 5}},
+		{name: "Explicit status", handler: func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte("down"))
+		}, uri: "/", expected: AccessLogRecord{Method: "GET", URI: "/", Status: http.StatusServiceUnavailable, Bytes: 4}},
+		{name: "Redacted query", handler: func(w http.ResponseWriter, r *http.Request) {}, uri: "/files?b=1&Token=abc&a=2&signature=xyz", expected: AccessLogRecord{Method: "GET", URI: "/files?b=1&Token=REDACTED&a=2&signature=REDACTED", Status: http.StatusOK}},
+		{name: "Custom redaction", handler: func(w http.ResponseWriter, r *http.Request) {}, uri: "/?token=abc&ssn=123", opts: AccessLogOptions{RedactQuery: []string{"ssn"}}, expected: AccessLogRecord{Method: "GET", URI: "/?token=abc&ssn=REDACTED", Status: http.StatusOK}},
+		{name: "Headers", handler: func(w http.ResponseWriter, r *http.Request) {}, uri: "/", header: map[string]string{"X-Forwarded-For": "10.0.0.1", "Authorization": "Bearer secret", "Referer": "https://example.com/?password=hunter2"}, opts: AccessLogOptions{Headers: []string{"x-forwarded-for", "Authorization", "X-Missing"}}, expected: AccessLogRecord{Method: "GET", URI: "/", Status: http.StatusOK, Referer: "https://example.com/?password=REDACTED", Headers: map[string]string{"X-Forwarded-For": "10.0.0.1", "Authorization": "REDACTED"}}},
+		{name: "Request ID", handler: func(w http.ResponseWriter, r *http.Request) {}, uri: "/", header: map[string]string{"X-Request-ID": "abc"}, expected: AccessLogRecord{Method: "GET", URI: "/", Status: http.StatusOK, RequestID: "abc"}},
+	}
+
+	for _, e := range tests {
+		logger := &recordingAccessLogger{}
+		e.opts.Logger = logger
+		handler := testTool.RequestID(testTool.AccessLog(e.handler, e.opts))
+
+		r := httptest.NewRequest("GET", e.uri, nil)
+		for k, v := range e.header {
+			r.Header.Set(k, v)
+		}
+		handler.ServeHTTP(httptest.NewRecorder(), r)
+
+		if len(logger.records) != 1 {
+			printErr(t, e.name, "Expected one record", fmt.Sprintf("Received: %d", len(logger.records)))
+			continue
+		}
+		rec := logger.records[0]
+		if rec.Method != e.expected.Method || rec.URI != e.expected.URI || rec.Status != e.expected.Status || rec.Bytes != e.expected.Bytes || rec.Referer != e.expected.Referer {
+			printErr(t, e.name, "Wrong record", fmt.Sprintf("Expected: %+v", e.expected), fmt.Sprintf("Received: %+v", rec))
+		}
+		if fmt.Sprint(rec.Headers) != fmt.Sprint(e.expected.Headers) {
+			printErr(t, e.name, "Wrong headers", fmt.Sprintf("Expected: %v", e.expected.Headers), fmt.Sprintf("Received: %v", rec.Headers))
+		}
+		if (e.expected.RequestID != "" && rec.RequestID != e.expected.RequestID) || !testTool.IsValidULID(rec.RequestID) && e.expected.RequestID == "" {
+			printErr(t, e.name, "Wrong request ID", fmt.Sprintf("Received: %q", rec.RequestID))
+		}
+		if rec.RemoteAddr != "192.0.2.1" || rec.Duration <= 0 || rec.Time.IsZero() {
+			printErr(t, e.name, "Incomplete record", fmt.Sprintf("Received: %+v", rec))
+		}
+	}
+}
+
+func TestTools_AccessLogSampling(t *testing.T) {
+	var testTool Tools
+	logger := &recordingAccessLogger{}
+	handler := testTool.AccessLog(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/error" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}), AccessLogOptions{Logger: logger, SampleRate: 0.000001})
+
+	for i := 0; i < 100; i++ {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/error", nil))
+	}
+	// errors are always logged
+	if len(logger.records) != 100 {
+		printErr(t, "Sampling", "Wrong number of records", "Expected: 100", fmt.Sprintf("Received: %d", len(logger.records)))
+	}
+}
+
+func TestTools_AccessLogPanic(t *testing.T) {
+	testTool := Tools{PanicLogger: func(r *http.Request, v interface{}, stack []byte) {}}
+	logger := &recordingAccessLogger{}
+	panicking := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { panic("nil map") })
+
+	// AccessLog inside and outside Recover both log the 500
+	handlers := map[string]http.Handler{
+		"Outside": testTool.AccessLog(testTool.Recover(panicking), AccessLogOptions{Logger: logger}),
+		"Inside":  testTool.Recover(testTool.AccessLog(panicking, AccessLogOptions{Logger: logger})),
+	}
+	for name, handler := range handlers {
+		logger.records = nil
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		if w.Code != http.StatusInternalServerError || len(logger.records) != 1 || logger.records[0].Status != http.StatusInternalServerError {
+			printErr(t, name, "Panic not logged as a 500", fmt.Sprintf("Received: %d %+v", w.Code, logger.records))
+		}
+	}
+}
+
+func TestTools_AccessLogWriterInterfaces(t *testing.T) {
+	var testTool Tools
+	logger := &recordingAccessLogger{}
+
+	server := httptest.NewServer(testTool.AccessLog(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/flush" {
+			w.Write([]byte("chunk"))
+			w.(http.Flusher).Flush()
+			return
+		}
+		conn, buf, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		buf.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: test\r\n\r\n")
+		buf.Flush()
+	}), AccessLogOptions{Logger: logger}))
+	defer server.Close()
+
+	res, err := http.Get(server.URL + "/flush")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	req, _ := http.NewRequest("GET", server.URL+"/upgrade", nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "test")
+	res, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	// the records are made once the handlers return, in any order
+	records := map[string]AccessLogRecord{}
+	for i := 0; i < 100 && len(records) < 2; i++ {
+		time.Sleep(10 * time.Millisecond)
+		logger.mu.Lock()
+		for _, rec := range logger.records {
+			records[rec.URI] = rec
+		}
+		logger.mu.Unlock()
+	}
+	if records["/flush"].Status != http.StatusOK || records["/flush"].Bytes != 5 || records["/upgrade"].Status != http.StatusSwitchingProtocols {
+		printErr(t, "Flusher and Hijacker", "Wrong records", fmt.Sprintf("Received: %+v", records))
+	}
+}
+
+func TestAccessLogWriter_LogAccess(t *testing.T) {
+	record := AccessLogRecord{
+		Time:       time.Date(2024, 3, 1, 10, 30, 0, 0, time.FixedZone("", -7*3600)),
+		RemoteAddr: "192.0.2.1",
+		Method:     "GET",
+		URI:        `/search?q="x"`,
+		Proto:      "HTTP/1.1",
+		Status:     200,
+		Bytes:      512,
+		Duration:   1500 * time.Microsecond,
+		RequestID:  "abc",
+		UserAgent:  "curl/8.0\nforged line",
+	}
+
+	tests := []struct {
+		name     string
+		format   string
+		expected string
+	}{
+		{name: "Common", format: AccessLogCommon, expected: `192.0.2.1 - - [01/Mar/2024:10:30:00 -0700] "GET /search?q=\"x\" HTTP/1.1" 200 512 abc`},
+		{name: "Combined", format: AccessLogCombined, expected: `192.0.2.1 - - [01/Mar/2024:10:30:00 -0700] "GET /search?q=\"x\" HTTP/1.1" 200 512 "-" "curl/8.0\nforged line" abc`},
+		{name: "JSON", format: AccessLogJSON, expected: `{"time":"2024-03-01T17:30:00Z","remote_addr":"192.0.2.1","method":"GET","uri":"/search?q=\"x\"","proto":"HTTP/1.1","status":200,"bytes":512,"duration_ms":1.5,"request_id":"abc","user_agent":"curl/8.0\nforged line"}`},
+	}
+
+	for _, e := range tests {
+		var out bytes.Buffer
+		l := &AccessLogWriter{Out: &out, Format: e.format}
+		l.LogAccess(record)
+
+		lines, _ := bufio.NewReader(&out).ReadString('\n')
+		if strings.TrimSuffix(lines, "\n") != e.expected || out.Len() != 0 {
+			printErr(t, e.name, "Wrong line", fmt.Sprintf("Expected: %s", e.expected), fmt.Sprintf("Received: %s", lines))
+		}
+		if e.format == AccessLogJSON && !json.Valid([]byte(lines)) {
+			printErr(t, e.name, "Invalid JSON")
+		}
+	}
+}
+
+func TestTools_AccessLogPlainWriter(t *testing.T) {
+	var testTool Tools
+	logger := &recordingAccessLogger{}
+
+	// handlers only see the interfaces of the original ResponseWriter
+	testTool.AccessLog(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if flusher, hijacker := writerInterfaces(w); flusher || hijacker {
+			printErr(t, "Plain", "Wrong interfaces", fmt.Sprintf("Received: %v %v", flusher, hijacker))
+		}
+	}), AccessLogOptions{Logger: logger}).ServeHTTP(plainWriter{httptest.NewRecorder()}, httptest.NewRequest("GET", "/", nil))
+}
@@ -0,0 +1,100 @@
+package webmod
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"hash/crc32"
+	"strings"
+)
+
+// ErrInvalidAPIKeyPrefix is returned for API key prefixes that contain
+// anything but lower case letters, digits and underscores.
+var ErrInvalidAPIKeyPrefix = errors.New("API key prefix may only contain a-z, 0-9 and _")
+
+const (
+	base62 = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	// the length of the random part of an API key, 32 base62
+	// characters hold more than 190 bits
+	apiKeySecretLen = 32
+	// the length of the checksum of an API key, 6 base62
+	// characters hold a 32 bit CRC
+	apiKeyChecksumLen = 6
+)
+
+// APIKey is a newly generated API key. Key is to be given to the user
+// and never stored, Hash is to be stored to verify the key later.
+type APIKey struct {
+	Key  string
+	Hash string
+}
+
+// GenerateAPIKey returns a new API key, made of prefix (e.g. "wm_live_"),
+// a random secret and a checksum, e.g. "wm_live_3bV...Qd02hX1". The prefix
+// makes keys easy to identify, e.g. by secret scanners, and the checksum
+// lets typos be rejected without a database lookup, see APIKeyChecksumValid.
+// An underscore is added to the prefix if it does not end with one.
+func (t *Tools) GenerateAPIKey(prefix string) (APIKey, error) {
+	for _, c := range prefix {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '_') {
+			return APIKey{}, ErrInvalidAPIKeyPrefix
+		}
+	}
+	if prefix != "" && !strings.HasSuffix(prefix, "_") {
+		prefix += "_"
+	}
+
+	secret, err := randomFrom(base62, apiKeySecretLen)
+	if err != nil {
+		return APIKey{}, err
+	}
+	key := prefix + secret
+	key += apiKeyChecksum(key)
+
+	return APIKey{Key: key, Hash: t.HashAPIKey(key)}, nil
+}
+
+// APIKeyChecksumValid reports whether key is well formed and its checksum
+// matches, without looking it up. It does not mean that the key is valid.
+func (t *Tools) APIKeyChecksumValid(key string) bool {
+	body := key[strings.LastIndex(key, "_")+1:]
+	if len(body) != apiKeySecretLen+apiKeyChecksumLen {
+		return false
+	}
+	for i := 0; i < len(body); i++ {
+		if strings.IndexByte(base62, body[i]) < 0 {
+			return false
+		}
+	}
+
+	n := len(key) - apiKeyChecksumLen
+	return subtle.ConstantTimeCompare([]byte(key[n:]), []byte(apiKeyChecksum(key[:n]))) == 1
+}
+
+// HashAPIKey returns the hash of an API key to store. API keys are long
+// random strings, so a fast hash is enough to protect them.
+func (t *Tools) HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// VerifyAPIKey reports whether key matches the stored hash, in constant time
+func (t *Tools) VerifyAPIKey(key, hash string) bool {
+	if !t.APIKeyChecksumValid(key) {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(t.HashAPIKey(key)), []byte(strings.ToLower(hash))) == 1
+}
+
+// apiKeyChecksum returns the CRC32 of s as a fixed length base62 string
+func apiKeyChecksum(s string) string {
+	crc := crc32.ChecksumIEEE([]byte(s))
+
+	buf := make([]byte, apiKeyChecksumLen)
+	for i := len(buf) - 1; i >= 0; i-- {
+		buf[i] = base62[crc%62]
+		crc /= 62
+	}
+	return string(buf)
+}
@@ -0,0 +1,56 @@
+package webmod
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestTools_GenerateAPIKey(t *testing.T) {
+	var testTool Tools
+
+	apiKey, err := testTool.GenerateAPIKey("wm_live")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(apiKey.Key, "wm_live_") || len(apiKey.Key) != len("wm_live_")+38 {
+		printErr(t, "Generate API key", "Malformed API key", fmt.Sprintf("Received: %s", apiKey.Key))
+	}
+	if apiKey.Hash == "" || strings.Contains(apiKey.Hash, apiKey.Key) {
+		printErr(t, "Generate API key", "Invalid hash", fmt.Sprintf("Received: %s", apiKey.Hash))
+	}
+
+	_, err = testTool.GenerateAPIKey("WM-live")
+	if !errors.Is(err, ErrInvalidAPIKeyPrefix) {
+		printErr(t, "Invalid prefix", "Expected invalid prefix error", fmt.Sprintf("Received: %v", err))
+	}
+
+	// a typo in the secret or the checksum is caught offline
+	typo := []byte(apiKey.Key)
+	typo[10] ^= 1
+	other, _ := testTool.GenerateAPIKey("wm_live_")
+
+	tests := []struct {
+		name     string
+		key      string
+		checksum bool
+		verified bool
+	}{
+		{name: "Valid key", key: apiKey.Key, checksum: true, verified: true},
+		{name: "Typo", key: string(typo), checksum: false, verified: false},
+		{name: "Truncated", key: apiKey.Key[:len(apiKey.Key)-1], checksum: false, verified: false},
+		{name: "Other key", key: other.Key, checksum: true, verified: false},
+		{name: "Empty key", key: "", checksum: false, verified: false},
+	}
+
+	for _, e := range tests {
+		if testTool.APIKeyChecksumValid(e.key) != e.checksum {
+			printErr(t, e.name, fmt.Sprintf("Expected checksum valid to be %t", e.checksum))
+		}
+		if testTool.VerifyAPIKey(e.key, apiKey.Hash) != e.verified {
+			printErr(t, e.name, fmt.Sprintf("Expected verified to be %t", e.verified))
+		}
+	}
+}
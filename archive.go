@@ -0,0 +1,370 @@
+package webmod
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Errors returned by ExtractArchive for archives that are unsafe to extract,
+// wrapped in an *UploadError naming the offending entry.
+var (
+	ErrArchiveFormat           = errors.New("Unsupported archive format")
+	ErrArchiveUnsafePath       = errors.New("Archive entry path is outside of the destination")
+	ErrArchiveLink             = errors.New("Archive entry is a link")
+	ErrArchiveSpecialFile      = errors.New("Archive entry is not a regular file or directory")
+	ErrArchiveTooManyEntries   = errors.New("Archive has too many entries")
+	ErrArchiveTooLarge         = errors.New("Archive is too big when uncompressed")
+	ErrArchiveCompressionRatio = errors.New("Archive compression ratio is too high")
+	ErrArchiveFileExists       = errors.New("Archive entry would overwrite an existing file")
+)
+
+// default limits for ExtractArchive
+const (
+	defaultMaxArchiveEntries    = 10000
+	defaultMaxArchiveSize       = 1024 * 1024 * 1024
+	defaultMaxCompressionRatio  = 100
+	archiveCompressionRatioSlop = 1024 * 1024
+)
+
+// archiveEntry is a file or directory read from an archive
+type archiveEntry struct {
+	name string
+	mode fs.FileMode
+	size int64
+	// compressed size, -1 if not known
+	compressed int64
+	open       func() (io.ReadCloser, error)
+}
+
+// ExtractArchive unpacks a zip, tar or tar.gz archive into destDir, which is
+// created if it does not exist. The format is detected from the content of
+// the archive. It returns the extracted files, with FileName being the path
+// relative to destDir.
+//
+// Entries with paths outside of destDir, links and special files are rejected,
+// as are archives with more than MaxArchiveEntries entries, more than
+// MaxArchiveSize bytes uncompressed or a compression ratio above
+// MaxCompressionRatio. Every file must be one of AllowedFileTypes and no bigger
+// than MaxFileSize, if they are set. Existing files in destDir are never
+// overwritten, entries for paths that already exist are rejected. If
+// extraction fails, the files extracted so far are removed.
+func (t *Tools) ExtractArchive(archivePath, destDir string) ([]*UploadedFile, error) {
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 512)
+	n, err := io.ReadFull(f, header)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return nil, &UploadError{FileName: archivePath, Err: ErrArchiveFormat}
+	}
+	header = header[:n]
+	_, err = f.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+
+	err = t.CreateDirIfNotExists(destDir)
+	if err != nil {
+		return nil, err
+	}
+
+	// entries are checked against the absolute destDir, as relative
+	// ones such as "." are not a prefix of the paths joined to them
+	absDestDir, err := filepath.Abs(destDir)
+	if err != nil {
+		return nil, err
+	}
+
+	x := &archiveExtractor{t: t, destDir: absDestDir, archiveSize: info.Size()}
+	switch {
+	case bytes.HasPrefix(header, []byte("PK\x03\x04")) || bytes.HasPrefix(header, []byte("PK\x05\x06")):
+		err = x.extractZip(f, info.Size())
+	case bytes.HasPrefix(header, []byte("\x1f\x8b")):
+		var gz *gzip.Reader
+		gz, err = gzip.NewReader(bufio.NewReader(f))
+		if err != nil {
+			return nil, &UploadError{FileName: archivePath, Err: ErrArchiveFormat}
+		}
+		err = x.extractTar(gz)
+	case len(header) > 262 && string(header[257:262]) == "ustar":
+		err = x.extractTar(f)
+	default:
+		return nil, &UploadError{FileName: archivePath, Err: ErrArchiveFormat}
+	}
+
+	if err != nil {
+		x.cleanup()
+		return nil, err
+	}
+	return x.files, nil
+}
+
+// archiveExtractor keeps track of the limits and of what has been
+// extracted, so that it can be removed on failure.
+type archiveExtractor struct {
+	t           *Tools
+	destDir     string
+	archiveSize int64
+	entries     int
+	total       int64
+	files       []*UploadedFile
+	dirs        []string
+}
+
+func (x *archiveExtractor) extractZip(f *os.File, size int64) error {
+	zr, err := zip.NewReader(f, size)
+	if err != nil {
+		return &UploadError{FileName: f.Name(), Err: ErrArchiveFormat}
+	}
+
+	for _, zf := range zr.File {
+		zf := zf
+		err = x.extract(archiveEntry{
+			name:       zf.Name,
+			mode:       zf.Mode(),
+			size:       int64(zf.UncompressedSize64),
+			compressed: int64(zf.CompressedSize64),
+			open:       zf.Open,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (x *archiveExtractor) extractTar(r io.Reader) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		mode := hdr.FileInfo().Mode()
+		if hdr.Typeflag == tar.TypeLink {
+			mode |= fs.ModeSymlink
+		}
+		err = x.extract(archiveEntry{
+			name:       hdr.Name,
+			mode:       mode,
+			size:       hdr.Size,
+			compressed: -1,
+			open: func() (io.ReadCloser, error) {
+				return io.NopCloser(tr), nil
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// extract checks an entry against the limits and writes it to destDir
+func (x *archiveExtractor) extract(e archiveEntry) error {
+	t := x.t
+
+	x.entries++
+	maxEntries := t.MaxArchiveEntries
+	if maxEntries == 0 {
+		maxEntries = defaultMaxArchiveEntries
+	}
+	if x.entries > maxEntries {
+		return &UploadError{FileName: e.name, Err: ErrArchiveTooManyEntries}
+	}
+
+	target, name, err := x.target(e.name)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case e.mode&fs.ModeSymlink != 0:
+		return &UploadError{FileName: e.name, Err: ErrArchiveLink}
+	case e.mode.IsDir():
+		return x.mkdir(target)
+	case !e.mode.IsRegular():
+		return &UploadError{FileName: e.name, Err: ErrArchiveSpecialFile}
+	}
+
+	if t.MaxFileSize > 0 && e.size > int64(t.MaxFileSize) {
+		return &UploadError{FileName: e.name, Err: ErrFileTooLarge}
+	}
+	if err := x.checkSize(e.name, e.size, e.compressed); err != nil {
+		return err
+	}
+
+	err = x.mkdir(filepath.Dir(target))
+	if err != nil {
+		return err
+	}
+
+	rc, err := e.open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	// check the file type from the first bytes of the entry
+	buf := make([]byte, sniffLen)
+	n, err := io.ReadFull(rc, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return err
+	}
+	filetype := t.DetectContentType(buf[:n])
+	if !fileTypeAllowed(filetype, t.AllowedFileTypes) {
+		return &UploadError{FileName: e.name, Err: ErrFileTypeNotPermitted}
+	}
+	if t.RejectExtensionMismatch && !t.ExtensionMatchesType(name, filetype) {
+		return &UploadError{FileName: e.name, Err: ErrFileExtensionMismatch}
+	}
+
+	outfile, err := createAtomicFile(target, t.SyncUploads)
+	if err != nil {
+		return err
+	}
+	defer outfile.Abort()
+
+	// never write more than the entry claims to hold
+	src := io.MultiReader(bytes.NewReader(buf[:n]), rc)
+	written, err := io.Copy(outfile, io.LimitReader(src, e.size+1))
+	if err != nil {
+		return err
+	}
+	if written > e.size {
+		return &UploadError{FileName: e.name, Err: ErrArchiveTooLarge}
+	}
+
+	// the file is only committed if it does not replace an existing one,
+	// which cleanup would then remove
+	if _, err := os.Lstat(target); err == nil {
+		return &UploadError{FileName: e.name, Err: ErrArchiveFileExists}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	err = outfile.Commit()
+	if err != nil {
+		return err
+	}
+
+	x.files = append(x.files, &UploadedFile{
+		FileName:         name,
+		OriginalFileName: e.name,
+		FileSize:         written,
+		ContentType:      filetype,
+	})
+	return nil
+}
+
+// checkSize adds size to the uncompressed total, checking it against
+// the size and compression ratio limits.
+func (x *archiveExtractor) checkSize(name string, size, compressed int64) error {
+	t := x.t
+
+	maxSize := int64(t.MaxArchiveSize)
+	if maxSize == 0 {
+		maxSize = defaultMaxArchiveSize
+	}
+	ratio := int64(t.MaxCompressionRatio)
+	if ratio == 0 {
+		ratio = defaultMaxCompressionRatio
+	}
+
+	if size < 0 {
+		return &UploadError{FileName: name, Err: ErrArchiveTooLarge}
+	}
+	x.total += size
+	if x.total > maxSize {
+		return &UploadError{FileName: name, Err: ErrArchiveTooLarge}
+	}
+
+	// the ratio of the entry, if known, and of the whole archive. Small
+	// archives are allowed some slack, as tiny files compress very well.
+	if compressed > 0 && size > archiveCompressionRatioSlop && size/compressed > ratio {
+		return &UploadError{FileName: name, Err: ErrArchiveCompressionRatio}
+	}
+	if x.archiveSize > 0 && x.total > archiveCompressionRatioSlop && x.total/x.archiveSize > ratio {
+		return &UploadError{FileName: name, Err: ErrArchiveCompressionRatio}
+	}
+	return nil
+}
+
+// target returns the path an entry is extracted to, and its path
+// relative to destDir, rejecting paths that would end up outside of it.
+func (x *archiveExtractor) target(name string) (string, string, error) {
+	unsafe := &UploadError{FileName: name, Err: ErrArchiveUnsafePath}
+
+	if name == "" || strings.Contains(name, "\\") || strings.ContainsRune(name, 0) {
+		return "", "", unsafe
+	}
+	clean := path.Clean(name)
+	if path.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") || filepath.VolumeName(clean) != "" {
+		return "", "", unsafe
+	}
+
+	target := filepath.Join(x.destDir, filepath.FromSlash(clean))
+	prefix := x.destDir
+	if !strings.HasSuffix(prefix, string(filepath.Separator)) {
+		prefix += string(filepath.Separator)
+	}
+	if target != x.destDir && !strings.HasPrefix(target, prefix) {
+		return "", "", unsafe
+	}
+	return target, clean, nil
+}
+
+// mkdir creates a directory inside destDir, remembering the
+// directories it creates.
+func (x *archiveExtractor) mkdir(dir string) error {
+	var created []string
+	for d := dir; d != x.destDir && strings.HasPrefix(d, x.destDir); d = filepath.Dir(d) {
+		if _, err := os.Lstat(d); err == nil {
+			break
+		}
+		created = append(created, d)
+	}
+
+	err := x.t.CreateDirIfNotExists(dir)
+	if err != nil {
+		return err
+	}
+	x.dirs = append(x.dirs, created...)
+	return nil
+}
+
+// cleanup removes the extracted files and the directories
+// created for them, deepest first.
+func (x *archiveExtractor) cleanup() {
+	for _, f := range x.files {
+		os.Remove(filepath.Join(x.destDir, filepath.FromSlash(f.FileName)))
+	}
+
+	sort.Slice(x.dirs, func(i, j int) bool {
+		return len(x.dirs[i]) > len(x.dirs[j])
+	})
+	for _, d := range x.dirs {
+		os.Remove(d)
+	}
+}
@@ -0,0 +1,260 @@
+package webmod
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testEntry is an entry of a test archive
+type testEntry struct {
+	name     string
+	data     []byte
+	typeflag byte
+}
+
+// writeZip writes a zip archive with the given entries to dir
+func writeZip(t *testing.T, dir string, entries ...testEntry) string {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, e := range entries {
+		w, err := zw.Create(e.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(e.data)
+	}
+	zw.Close()
+
+	p := filepath.Join(dir, "test.zip")
+	if err := os.WriteFile(p, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// writeTarGz writes a tar.gz archive with the given entries to dir
+func writeTarGz(t *testing.T, dir string, entries ...testEntry) string {
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Mode: 0644, Size: int64(len(e.data)), Typeflag: e.typeflag}
+		if e.typeflag == 0 {
+			hdr.Typeflag = tar.TypeReg
+		}
+		if e.typeflag == tar.TypeSymlink {
+			hdr.Linkname = "/etc/passwd"
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write(e.data)
+	}
+	tw.Close()
+	gw.Close()
+
+	p := filepath.Join(dir, "test.tar.gz")
+	if err := os.WriteFile(p, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestTools_ExtractArchive(t *testing.T) {
+	hello := []byte("hello world")
+
+	tests := []struct {
+		name        string
+		tools       Tools
+		archive     func(dir string) string
+		files       int
+		expectedErr error
+	}{
+		{
+			name: "Zip",
+			archive: func(dir string) string {
+				return writeZip(t, dir, testEntry{name: "a.txt", data: hello}, testEntry{name: "sub/b.txt", data: hello})
+			},
+			files: 2,
+		},
+		{
+			name: "Tar gz",
+			archive: func(dir string) string {
+				return writeTarGz(t, dir, testEntry{name: "sub/", typeflag: tar.TypeDir}, testEntry{name: "sub/a.txt", data: hello})
+			},
+			files: 1,
+		},
+		{
+			name: "Zip slip",
+			archive: func(dir string) string {
+				return writeZip(t, dir, testEntry{name: "a.txt", data: hello}, testEntry{name: "../evil.txt", data: hello})
+			},
+			expectedErr: ErrArchiveUnsafePath,
+		},
+		{
+			name: "Absolute path",
+			archive: func(dir string) string {
+				return writeTarGz(t, dir, testEntry{name: "/tmp/evil.txt", data: hello})
+			},
+			expectedErr: ErrArchiveUnsafePath,
+		},
+		{
+			name: "Symlink",
+			archive: func(dir string) string {
+				return writeTarGz(t, dir, testEntry{name: "passwd", typeflag: tar.TypeSymlink})
+			},
+			expectedErr: ErrArchiveLink,
+		},
+		{
+			name: "Device file",
+			archive: func(dir string) string {
+				return writeTarGz(t, dir, testEntry{name: "null", typeflag: tar.TypeChar})
+			},
+			expectedErr: ErrArchiveSpecialFile,
+		},
+		{
+			name:  "Too many entries",
+			tools: Tools{MaxArchiveEntries: 1},
+			archive: func(dir string) string {
+				return writeZip(t, dir, testEntry{name: "a.txt", data: hello}, testEntry{name: "b.txt", data: hello})
+			},
+			expectedErr: ErrArchiveTooManyEntries,
+		},
+		{
+			name:  "Too large",
+			tools: Tools{MaxArchiveSize: 16},
+			archive: func(dir string) string {
+				return writeZip(t, dir, testEntry{name: "a.txt", data: hello}, testEntry{name: "b.txt", data: hello})
+			},
+			expectedErr: ErrArchiveTooLarge,
+		},
+		{
+			name: "Compression ratio",
+			archive: func(dir string) string {
+				return writeZip(t, dir, testEntry{name: "a.txt", data: bytes.Repeat([]byte("a"), 4*1024*1024)})
+			},
+			expectedErr: ErrArchiveCompressionRatio,
+		},
+		{
+			name:  "File type not permitted",
+			tools: Tools{AllowedFileTypes: []string{"image/png"}},
+			archive: func(dir string) string {
+				return writeZip(t, dir, testEntry{name: "a.txt", data: hello})
+			},
+			expectedErr: ErrFileTypeNotPermitted,
+		},
+		{
+			name: "Not an archive",
+			archive: func(dir string) string {
+				p := filepath.Join(dir, "a.txt")
+				os.WriteFile(p, hello, 0644)
+				return p
+			},
+			expectedErr: ErrArchiveFormat,
+		},
+	}
+
+	for _, e := range tests {
+		archive := e.archive(t.TempDir())
+		destDir := filepath.Join(t.TempDir(), "extracted")
+
+		files, err := e.tools.ExtractArchive(archive, destDir)
+		if e.expectedErr == nil {
+			if err != nil {
+				printErr(t, e.name, "Error not expected, but one received", err.Error())
+				continue
+			}
+			if len(files) != e.files {
+				printErr(t, e.name, "Wrong number of files extracted", fmt.Sprintf("Expected: %d", e.files), fmt.Sprintf("Received: %d", len(files)))
+			}
+			for _, f := range files {
+				if _, err := os.Stat(filepath.Join(destDir, f.FileName)); err != nil {
+					printErr(t, e.name, "Extracted file does not exist", err.Error())
+				}
+			}
+			continue
+		}
+
+		if !errors.Is(err, e.expectedErr) {
+			expected := fmt.Sprintf("Expected: %v", e.expectedErr)
+			received := fmt.Sprintf("Received: %v", err)
+			printErr(t, e.name, "Wrong error returned", expected, received)
+		}
+		// nothing is left behind by a failed extraction
+		if entries, _ := os.ReadDir(destDir); len(entries) != 0 {
+			printErr(t, e.name, "Files left behind after failed extraction")
+		}
+	}
+}
+
+func TestTools_ExtractArchiveRelativeDestDir(t *testing.T) {
+	var testTool Tools
+	archive := writeZip(t, t.TempDir(), testEntry{name: "docs/a.txt", data: []byte("hello")})
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	for _, destDir := range []string{".", "./", "extracted", "./extracted/"} {
+		tname := fmt.Sprintf("Destination %q", destDir)
+		dir := t.TempDir()
+		if err := os.Chdir(dir); err != nil {
+			t.Fatal(err)
+		}
+
+		files, err := testTool.ExtractArchive(archive, destDir)
+		if err != nil {
+			printErr(t, tname, "Error not expected, but one received", err.Error())
+			continue
+		}
+		if len(files) != 1 || files[0].FileName != "docs/a.txt" {
+			printErr(t, tname, "Wrong files extracted", fmt.Sprintf("Received: %+v", files))
+		}
+		if _, err := os.Stat(filepath.Join(dir, destDir, "docs", "a.txt")); err != nil {
+			printErr(t, tname, "Extracted file does not exist", err.Error())
+		}
+	}
+}
+
+func TestTools_ExtractArchiveExistingFiles(t *testing.T) {
+	var testTool Tools
+	hello := []byte("hello")
+
+	tests := []struct {
+		name    string
+		entries []testEntry
+	}{
+		{name: "Existing file", entries: []testEntry{{name: "notes.txt", data: hello}}},
+		{name: "New file then existing file", entries: []testEntry{{name: "new.txt", data: hello}, {name: "notes.txt", data: hello}}},
+		{name: "Duplicate entry", entries: []testEntry{{name: "a.txt", data: hello}, {name: "a.txt", data: []byte("again")}}},
+	}
+
+	for _, e := range tests {
+		destDir := t.TempDir()
+		existing := filepath.Join(destDir, "notes.txt")
+		if err := os.WriteFile(existing, []byte("user data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		_, err := testTool.ExtractArchive(writeZip(t, t.TempDir(), e.entries...), destDir)
+		if !errors.Is(err, ErrArchiveFileExists) {
+			printErr(t, e.name, "Wrong error returned", fmt.Sprintf("Expected: %v", ErrArchiveFileExists), fmt.Sprintf("Received: %v", err))
+		}
+		// the existing file is kept, and nothing else is left behind
+		if data, err := os.ReadFile(existing); err != nil || string(data) != "user data" {
+			printErr(t, e.name, "Existing file lost", fmt.Sprintf("Received: %q %v", data, err))
+		}
+		if entries, _ := os.ReadDir(destDir); len(entries) != 1 {
+			printErr(t, e.name, "Files left behind after failed extraction", fmt.Sprintf("Received: %d", len(entries)))
+		}
+	}
+}
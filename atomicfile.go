@@ -0,0 +1,83 @@
+package webmod
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// atomicFile is a file that is written under a temporary name in the
+// directory of its final path, and only renamed to the final path on Commit,
+// so that readers never see a partially written file.
+type atomicFile struct {
+	*os.File
+	path string
+	sync bool
+	done bool
+}
+
+// createAtomicFile creates the temporary file for path. If sync is true,
+// the file and its directory are flushed to disk on Commit.
+func createAtomicFile(path string, sync bool) (*atomicFile, error) {
+	f, err := os.CreateTemp(filepath.Dir(path), ".tmp-"+filepath.Base(path)+"-*")
+	if err != nil {
+		return nil, err
+	}
+	return &atomicFile{File: f, path: path, sync: sync}, nil
+}
+
+// Commit closes the temporary file and renames it to its final path.
+// The temporary file is removed if any of this fails.
+func (f *atomicFile) Commit() error {
+	if f.done {
+		return nil
+	}
+	f.done = true
+
+	if f.sync {
+		if err := f.File.Sync(); err != nil {
+			f.File.Close()
+			os.Remove(f.File.Name())
+			return err
+		}
+	}
+	if err := f.File.Close(); err != nil {
+		os.Remove(f.File.Name())
+		return err
+	}
+	if err := os.Rename(f.File.Name(), f.path); err != nil {
+		os.Remove(f.File.Name())
+		return err
+	}
+	if f.sync {
+		return syncDir(filepath.Dir(f.path))
+	}
+	return nil
+}
+
+// Abort closes and removes the temporary file, it does nothing after Commit.
+func (f *atomicFile) Abort() {
+	if f.done {
+		return
+	}
+	f.done = true
+
+	f.File.Close()
+	os.Remove(f.File.Name())
+}
+
+// syncDir flushes a directory to disk, so that a rename within it is durable.
+// Directories can't be synced on windows, so this is a no-op there.
+func syncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
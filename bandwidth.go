@@ -0,0 +1,177 @@
+package webmod
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// tokenBucket is a token bucket refilled at rate tokens per second,
+// holding at most burst tokens. It is not safe for concurrent use.
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// newTokenBucket returns a full bucket
+func newTokenBucket(rate, burst float64, now time.Time) *tokenBucket {
+	return &tokenBucket{rate: rate, burst: burst, tokens: burst, last: now}
+}
+
+// refill adds the tokens accumulated since the last refill
+func (b *tokenBucket) refill(now time.Time) {
+	if now.After(b.last) {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+		b.last = now
+	}
+}
+
+// reserve takes n tokens from the bucket, going into debt if there are not
+// enough, and returns how long to wait until the tokens are available.
+func (b *tokenBucket) reserve(n float64, now time.Time) time.Duration {
+	b.refill(now)
+	b.tokens -= n
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// refund returns n reserved tokens that were not used
+func (b *tokenBucket) refund(n float64) {
+	b.tokens += n
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+}
+
+// take takes n tokens from the bucket if there are enough, and otherwise
+// returns how long to wait until there are.
+func (b *tokenBucket) take(n float64, now time.Time) (bool, time.Duration) {
+	b.refill(now)
+	if b.tokens >= n {
+		b.tokens -= n
+		return true, 0
+	}
+	return false, time.Duration((n - b.tokens) / b.rate * float64(time.Second))
+}
+
+// Bandwidth limits the number of bytes per second sent by downloads, with
+// bursts of up to Burst bytes (defaulting to one second worth of bytes).
+// A Bandwidth set as DownloadBandwidth is shared by all downloads. Its
+// limits are fixed once it has been used, set a new Bandwidth to change them.
+type Bandwidth struct {
+	BytesPerSecond int
+	Burst          int
+
+	mu     sync.Mutex
+	bucket *tokenBucket
+}
+
+// chunk returns the largest number of bytes that can be sent at once
+func (b *Bandwidth) chunk() int {
+	if b.Burst > 0 {
+		return b.Burst
+	}
+	return b.BytesPerSecond
+}
+
+// reserve reserves n bytes, returning how long to wait before sending them
+func (b *Bandwidth) reserve(n int) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	if b.bucket == nil {
+		b.bucket = newTokenBucket(float64(b.BytesPerSecond), float64(b.chunk()), now)
+	}
+	return b.bucket.reserve(float64(n), now)
+}
+
+// refund gives back n reserved bytes that were not sent, so that
+// they can be used by other downloads
+func (b *Bandwidth) refund(n int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.bucket.refund(float64(n))
+}
+
+// throttledWriter is a ResponseWriter limiting the bandwidth of a response,
+// by its own limit and the limit shared by all downloads.
+type throttledWriter struct {
+	http.ResponseWriter
+	ctx    context.Context
+	limits []*Bandwidth
+}
+
+// throttle limits the bandwidth of w by DownloadResponseRate and DownloadBandwidth,
+// if they are set. The writes are stopped once ctx is done.
+func (t *Tools) throttle(w http.ResponseWriter, r *http.Request) http.ResponseWriter {
+	var limits []*Bandwidth
+	if t.DownloadResponseRate > 0 {
+		limits = append(limits, &Bandwidth{BytesPerSecond: t.DownloadResponseRate, Burst: t.DownloadResponseBurst})
+	}
+	if t.DownloadBandwidth != nil && t.DownloadBandwidth.BytesPerSecond > 0 {
+		limits = append(limits, t.DownloadBandwidth)
+	}
+	if len(limits) == 0 {
+		return w
+	}
+	return &throttledWriter{ResponseWriter: w, ctx: r.Context(), limits: limits}
+}
+
+func (tw *throttledWriter) Write(p []byte) (int, error) {
+	var written int
+	for len(p) > 0 {
+		n := len(p)
+		for _, l := range tw.limits {
+			if c := l.chunk(); c < n {
+				n = c
+			}
+		}
+
+		var wait time.Duration
+		for _, l := range tw.limits {
+			if d := l.reserve(n); d > wait {
+				wait = d
+			}
+		}
+		if wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case <-tw.ctx.Done():
+				timer.Stop()
+				tw.refund(n)
+				return written, tw.ctx.Err()
+			}
+		}
+
+		m, err := tw.ResponseWriter.Write(p[:n])
+		written += m
+		if err != nil {
+			tw.refund(n - m)
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// refund gives back n bytes reserved from every limit
+func (tw *throttledWriter) refund(n int) {
+	for _, l := range tw.limits {
+		l.refund(n)
+	}
+}
+
+// Unwrap returns the original ResponseWriter
+func (tw *throttledWriter) Unwrap() http.ResponseWriter {
+	return tw.ResponseWriter
+}
@@ -0,0 +1,107 @@
+package webmod
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket_Reserve(t *testing.T) {
+	now := time.Now()
+	b := newTokenBucket(100, 50, now)
+
+	tests := []struct {
+		name     string
+		n        float64
+		after    time.Duration
+		expected time.Duration
+	}{
+		{name: "Burst available", n: 50, expected: 0},
+		{name: "Empty bucket", n: 50, expected: 500 * time.Millisecond},
+		{name: "Debt paid back", n: 10, after: time.Second, expected: 0},
+		{name: "Refill capped at burst", n: 50, after: 10 * time.Second, expected: 0},
+	}
+
+	for _, e := range tests {
+		now = now.Add(e.after)
+		wait := b.reserve(e.n, now)
+		if wait != e.expected {
+			printErr(t, e.name, "Wrong wait time", fmt.Sprintf("Expected: %s", e.expected), fmt.Sprintf("Received: %s", wait))
+		}
+	}
+}
+
+func TestTools_DownloadThrottled(t *testing.T) {
+	content := bytes.Repeat([]byte("a"), 1500)
+	testTool := Tools{DownloadResponseRate: 1000, DownloadResponseBurst: 500}
+
+	// the first 500 bytes are sent at once, the rest at 1000 bytes per second
+	start := time.Now()
+	w := httptest.NewRecorder()
+	testTool.DownloadContent(w, httptest.NewRequest("GET", "/", nil), bytes.NewReader(content), time.Time{}, "a.txt")
+	elapsed := time.Since(start)
+
+	if w.Body.Len() != len(content) {
+		printErr(t, "Throttled download", "Wrong content length", fmt.Sprintf("Received: %d", w.Body.Len()))
+	}
+	if elapsed < 900*time.Millisecond {
+		printErr(t, "Throttled download", "Download not throttled", fmt.Sprintf("Took: %s", elapsed))
+	}
+
+	// range requests still work
+	w = httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Range", "bytes=0-99")
+	testTool.DownloadContent(w, r, bytes.NewReader(content), time.Time{}, "a.txt")
+	if w.Code != http.StatusPartialContent || w.Body.Len() != 100 {
+		printErr(t, "Throttled range", "Range not served", fmt.Sprintf("Received: %d, %d bytes", w.Code, w.Body.Len()))
+	}
+}
+
+func TestTools_DownloadBandwidthShared(t *testing.T) {
+	content := bytes.Repeat([]byte("a"), 1000)
+	testTool := Tools{DownloadBandwidth: &Bandwidth{BytesPerSecond: 2000, Burst: 500}}
+
+	// two downloads of 1000 bytes share 2000 bytes per second,
+	// after the initial burst of 500 bytes
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := httptest.NewRecorder()
+			testTool.DownloadContent(w, httptest.NewRequest("GET", "/", nil), bytes.NewReader(content), time.Time{}, "a.txt")
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	if elapsed < 650*time.Millisecond {
+		printErr(t, "Shared bandwidth", "Downloads not throttled together", fmt.Sprintf("Took: %s", elapsed))
+	}
+}
+
+func TestBandwidth_Refund(t *testing.T) {
+	shared := &Bandwidth{BytesPerSecond: 100, Burst: 100}
+	shared.reserve(100)
+
+	// a download whose client goes away while waiting gives back its bytes
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	tw := &throttledWriter{ResponseWriter: httptest.NewRecorder(), ctx: ctx, limits: []*Bandwidth{shared}}
+	n, err := tw.Write(bytes.Repeat([]byte("a"), 100))
+	if n != 0 || err != context.DeadlineExceeded {
+		printErr(t, "Cancelled write", "Wrong result", fmt.Sprintf("Received: %d %v", n, err))
+	}
+
+	// so other downloads do not wait for them
+	if wait := shared.reserve(50); wait > 600*time.Millisecond {
+		printErr(t, "Refund", "Bytes not given back", fmt.Sprintf("Wait: %s", wait))
+	}
+}
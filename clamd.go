@@ -0,0 +1,148 @@
+package webmod
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// ClamdScanner is a Scanner that sends files to a ClamAV clamd daemon
+// using the INSTREAM command. Network is "tcp" or "unix" and Address is
+// the address of the daemon, e.g. "localhost:3310" or "/run/clamav/clamd.ctl".
+type ClamdScanner struct {
+	Network string
+	Address string
+	// Timeout for a whole scan, defaults to one minute
+	Timeout time.Duration
+	// ChunkSize is the size of the chunks sent to clamd, defaults to 64KB.
+	// It must be smaller than the StreamMaxLength setting of clamd.
+	ChunkSize int
+}
+
+// Ping checks that clamd is reachable
+func (c *ClamdScanner) Ping(ctx context.Context) error {
+	reply, err := c.command(ctx, "zPING\x00", nil)
+	if err != nil {
+		return err
+	}
+	if reply != "PONG" {
+		return fmt.Errorf("clamd: unexpected reply: %q", reply)
+	}
+	return nil
+}
+
+// Scan streams r to clamd and reports if a virus was found
+func (c *ClamdScanner) Scan(ctx context.Context, r io.Reader) (ScanResult, error) {
+	reply, err := c.command(ctx, "zINSTREAM\x00", r)
+	if err != nil {
+		return ScanResult{}, err
+	}
+
+	// replies are "stream: OK", "stream: <signature> FOUND"
+	// or "<message> ERROR"
+	reply = strings.TrimPrefix(reply, "stream: ")
+	switch {
+	case reply == "OK":
+		return ScanResult{}, nil
+	case strings.HasSuffix(reply, " FOUND"):
+		return ScanResult{Infected: true, Signature: strings.TrimSuffix(reply, " FOUND")}, nil
+	case strings.HasSuffix(reply, " ERROR"):
+		return ScanResult{}, fmt.Errorf("clamd: %s", strings.TrimSuffix(reply, " ERROR"))
+	default:
+		return ScanResult{}, fmt.Errorf("clamd: unexpected reply: %q", reply)
+	}
+}
+
+// command sends a command to clamd, followed by the content of r in
+// chunks if r is not nil, and returns the reply.
+func (c *ClamdScanner) command(ctx context.Context, cmd string, r io.Reader) (string, error) {
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = time.Minute
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, c.Network, c.Address)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	deadline, _ := ctx.Deadline()
+	err = conn.SetDeadline(deadline)
+	if err != nil {
+		return "", err
+	}
+	// close the connection if the context is cancelled during the scan
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-stop:
+		}
+	}()
+
+	w := bufio.NewWriter(conn)
+	_, err = w.WriteString(cmd)
+	if err != nil {
+		return "", err
+	}
+	if r != nil {
+		err = c.writeChunks(w, r)
+		if err != nil {
+			return "", err
+		}
+	}
+	err = w.Flush()
+	if err != nil {
+		return "", err
+	}
+
+	reply, err := bufio.NewReader(conn).ReadBytes(0)
+	if err != nil && !(errors.Is(err, io.EOF) && len(reply) > 0) {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		return "", err
+	}
+	return string(bytes.TrimRight(reply, "\x00\n")), nil
+}
+
+// writeChunks writes r as length prefixed chunks, terminated by an empty chunk
+func (c *ClamdScanner) writeChunks(w io.Writer, r io.Reader) error {
+	chunkSize := c.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = 64 * 1024
+	}
+
+	buf := make([]byte, 4+chunkSize)
+	for {
+		n, err := io.ReadFull(r, buf[4:])
+		if n > 0 {
+			binary.BigEndian.PutUint32(buf[:4], uint32(n))
+			if _, werr := w.Write(buf[:4+n]); werr != nil {
+				return werr
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := w.Write([]byte{0, 0, 0, 0})
+	return err
+}
@@ -0,0 +1,154 @@
+package webmod
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const eicar = `X5O!P%@AP[4\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*`
+
+// fakeClamd serves the PING and INSTREAM commands of clamd on l,
+// flagging any stream that contains the EICAR test string.
+func fakeClamd(l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+
+		go func(conn net.Conn) {
+			defer conn.Close()
+
+			r := bufio.NewReader(conn)
+			cmd, err := r.ReadString(0)
+			if err != nil {
+				return
+			}
+
+			switch cmd {
+			case "zPING\x00":
+				conn.Write([]byte("PONG\x00"))
+			case "zINSTREAM\x00":
+				var data bytes.Buffer
+				for {
+					var size uint32
+					if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+						return
+					}
+					if size == 0 {
+						break
+					}
+					if _, err := io.CopyN(&data, r, int64(size)); err != nil {
+						return
+					}
+				}
+				if strings.Contains(data.String(), eicar) {
+					conn.Write([]byte("stream: Eicar-Test-Signature FOUND\x00"))
+				} else {
+					conn.Write([]byte("stream: OK\x00"))
+				}
+			default:
+				conn.Write([]byte("UNKNOWN COMMAND\x00"))
+			}
+		}(conn)
+	}
+}
+
+// startFakeClamd starts a fake clamd on the given network
+func startFakeClamd(t *testing.T, network string) *ClamdScanner {
+	address := "127.0.0.1:0"
+	if network == "unix" {
+		address = filepath.Join(t.TempDir(), "clamd.sock")
+	}
+
+	l, err := net.Listen(network, address)
+	if err != nil {
+		t.Skipf("Unable to listen on %s: %s", network, err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go fakeClamd(l)
+
+	return &ClamdScanner{Network: network, Address: l.Addr().String(), ChunkSize: 16}
+}
+
+func TestClamdScanner_Scan(t *testing.T) {
+	for _, network := range []string{"tcp", "unix"} {
+		scanner := startFakeClamd(t, network)
+
+		tname := fmt.Sprintf("Ping (%s)", network)
+		if err := scanner.Ping(context.Background()); err != nil {
+			printErr(t, tname, err.Error())
+		}
+
+		tname = fmt.Sprintf("Clean file (%s)", network)
+		result, err := scanner.Scan(context.Background(), strings.NewReader("nothing to see here"))
+		if err != nil {
+			printErr(t, tname, err.Error())
+		}
+		if result.Infected {
+			printErr(t, tname, "Clean file reported as infected")
+		}
+
+		tname = fmt.Sprintf("Infected file (%s)", network)
+		result, err = scanner.Scan(context.Background(), strings.NewReader(eicar))
+		if err != nil {
+			printErr(t, tname, err.Error())
+		}
+		if !result.Infected || result.Signature != "Eicar-Test-Signature" {
+			printErr(t, tname, "Infected file not detected", fmt.Sprintf("Received: %+v", result))
+		}
+	}
+}
+
+func TestTools_UploadFilesScan(t *testing.T) {
+	scanner := startFakeClamd(t, "tcp")
+
+	// an infected file is rejected and quarantined
+	uploadDir := t.TempDir()
+	quarantineDir := t.TempDir()
+	testTools := Tools{Scanner: scanner, QuarantineDir: quarantineDir}
+
+	r := newUploadRequest(t, testUpload{field: "file", filename: "eicar.txt", data: []byte(eicar)})
+	_, err := testTools.UploadOneFile(r, uploadDir)
+	if !errors.Is(err, ErrFileInfected) {
+		printErr(t, "Infected upload", "Expected infected file error", fmt.Sprintf("Received: %v", err))
+	}
+	if entries, _ := os.ReadDir(uploadDir); len(entries) != 0 {
+		printErr(t, "Infected upload", "Infected file left in the upload dir")
+	}
+	if entries, _ := os.ReadDir(quarantineDir); len(entries) != 1 {
+		printErr(t, "Infected upload", "Infected file not quarantined")
+	}
+
+	// a clean file is accepted
+	r = newUploadRequest(t, testUpload{field: "file", filename: "clean.txt", data: []byte("hello")})
+	_, err = testTools.UploadOneFile(r, uploadDir)
+	if err != nil {
+		printErr(t, "Clean upload", err.Error())
+	}
+
+	// a failing scanner rejects files, unless failing open
+	testTools.Scanner = &ClamdScanner{Network: "unix", Address: filepath.Join(t.TempDir(), "missing.sock")}
+	r = newUploadRequest(t, testUpload{field: "file", filename: "clean.txt", data: []byte("hello")})
+	_, err = testTools.UploadOneFile(r, uploadDir)
+	if !errors.Is(err, ErrScanFailed) {
+		printErr(t, "Fail closed", "Expected scan failed error", fmt.Sprintf("Received: %v", err))
+	}
+
+	testTools.ScanFailOpen = true
+	r = newUploadRequest(t, testUpload{field: "file", filename: "clean.txt", data: []byte("hello")})
+	_, err = testTools.UploadOneFile(r, uploadDir)
+	if err != nil {
+		printErr(t, "Fail open", err.Error())
+	}
+}
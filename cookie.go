@@ -0,0 +1,278 @@
+package webmod
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Errors returned by the secure cookie helpers
+var (
+	ErrNoCookieKey    = errors.New("No cookie key")
+	ErrCookieNotFound = errors.New("Cookie not found")
+	ErrCookieInvalid  = errors.New("Cookie is invalid or has been tampered with")
+	ErrCookieExpired  = errors.New("Cookie has expired")
+	ErrCookieTooLarge = errors.New("Cookie is too large")
+)
+
+// browsers keep cookies of up to 4096 bytes, name and attributes included
+const maxCookieSize = 4096
+
+// CookieOptions configures a cookie set by SetSecureCookie. Path defaults
+// to "/", MaxAge to 24 hours and SameSite to Lax. The cookie is always
+// HttpOnly, and Secure unless Insecure is set, e.g. for development.
+// Encrypt hides the value from the client, which can only read the JSON
+// of signed cookies.
+type CookieOptions struct {
+	Path     string
+	Domain   string
+	MaxAge   time.Duration
+	SameSite http.SameSite
+	Insecure bool
+	Encrypt  bool
+}
+
+// withDefaults returns the options with the defaults set for zero values
+func (o CookieOptions) withDefaults() CookieOptions {
+	if o.Path == "" {
+		o.Path = "/"
+	}
+	if o.MaxAge == 0 {
+		o.MaxAge = 24 * time.Hour
+	}
+	if o.SameSite == 0 {
+		o.SameSite = http.SameSiteLaxMode
+	}
+	return o
+}
+
+// SetSecureCookie sets the cookie name to value encoded as JSON, signed or
+// encrypted with the first of CookieKeys. The expiry of the cookie is part
+// of the signed value, so that it can not be extended by the client.
+func (t *Tools) SetSecureCookie(w http.ResponseWriter, name string, value interface{}, opts ...CookieOptions) error {
+	if len(t.CookieKeys) == 0 {
+		return ErrNoCookieKey
+	}
+	key := t.CookieKeys[0]
+	if err := key.check(ErrNoCookieKey); err != nil {
+		return err
+	}
+
+	var opt CookieOptions
+	if len(opts) > 0 {
+		opt = opts[0]
+	}
+	opt = opt.withDefaults()
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	expires := time.Now().Add(opt.MaxAge)
+	payload := make([]byte, 8, 8+len(data))
+	binary.BigEndian.PutUint64(payload, uint64(expires.Unix()))
+	payload = append(payload, data...)
+
+	var encoded string
+	if opt.Encrypt {
+		encoded, err = encryptCookie(key, name, payload)
+		if err != nil {
+			return err
+		}
+	} else {
+		encoded = signCookie(key, name, payload)
+	}
+
+	cookie := &http.Cookie{
+		Name:     name,
+		Value:    encoded,
+		Path:     opt.Path,
+		Domain:   opt.Domain,
+		Expires:  expires,
+		MaxAge:   int(opt.MaxAge / time.Second),
+		Secure:   !opt.Insecure,
+		HttpOnly: true,
+		SameSite: opt.SameSite,
+	}
+	if len(cookie.String()) > maxCookieSize {
+		return ErrCookieTooLarge
+	}
+	http.SetCookie(w, cookie)
+	return nil
+}
+
+// GetSecureCookie decodes the cookie name set by SetSecureCookie into
+// value. Cookies signed or encrypted with any of CookieKeys are accepted.
+// ErrCookieNotFound is returned if there is no such cookie, ErrCookieInvalid
+// if it has been tampered with and ErrCookieExpired if it has expired.
+// ErrNoCookieKey is returned if the secret of its key is too short.
+func (t *Tools) GetSecureCookie(r *http.Request, name string, value interface{}) error {
+	c, err := r.Cookie(name)
+	if err != nil {
+		return ErrCookieNotFound
+	}
+
+	// "s.<key ID>.<payload>.<mac>" or "e.<key ID>.<nonce and ciphertext>"
+	parts := strings.Split(c.Value, ".")
+	if len(parts) < 3 {
+		return ErrCookieInvalid
+	}
+	kid, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return ErrCookieInvalid
+	}
+	key, ok := t.cookieKey(string(kid))
+	if !ok {
+		return ErrCookieInvalid
+	}
+	if err := key.check(ErrNoCookieKey); err != nil {
+		return err
+	}
+
+	var payload []byte
+	switch {
+	case parts[0] == "s" && len(parts) == 4:
+		payload, err = verifyCookie(key, name, parts)
+	case parts[0] == "e" && len(parts) == 3:
+		payload, err = decryptCookie(key, name, parts)
+	default:
+		err = ErrCookieInvalid
+	}
+	if err != nil {
+		return err
+	}
+
+	if len(payload) < 8 {
+		return ErrCookieInvalid
+	}
+	expires := time.Unix(int64(binary.BigEndian.Uint64(payload)), 0)
+	if !time.Now().Before(expires) {
+		return ErrCookieExpired
+	}
+	err = json.Unmarshal(payload[8:], value)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrCookieInvalid, err)
+	}
+	return nil
+}
+
+// DeleteCookie tells the client to remove the cookie name. The path and
+// domain of opts must be those the cookie was set with.
+func (t *Tools) DeleteCookie(w http.ResponseWriter, name string, opts ...CookieOptions) {
+	var opt CookieOptions
+	if len(opts) > 0 {
+		opt = opts[0]
+	}
+	opt = opt.withDefaults()
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Path:     opt.Path,
+		Domain:   opt.Domain,
+		Expires:  time.Unix(0, 0),
+		MaxAge:   -1,
+		Secure:   !opt.Insecure,
+		HttpOnly: true,
+		SameSite: opt.SameSite,
+	})
+}
+
+// cookieKey returns the key of CookieKeys with the ID kid
+func (t *Tools) cookieKey(kid string) (SigningKey, bool) {
+	for _, k := range t.CookieKeys {
+		if k.ID == kid {
+			return k, true
+		}
+	}
+	return SigningKey{}, false
+}
+
+// deriveCookieKey derives a key for purpose from the secret of key,
+// so that the same secret is never used to both sign and encrypt.
+func deriveCookieKey(key SigningKey, purpose string) []byte {
+	mac := hmac.New(sha256.New, key.Secret)
+	mac.Write([]byte("webmod cookie " + purpose))
+	return mac.Sum(nil)
+}
+
+// cookieMAC returns the MAC of a signed cookie, covering its name
+// so that a cookie can not be passed off as another one.
+func cookieMAC(key SigningKey, name, signed string) []byte {
+	mac := hmac.New(sha256.New, deriveCookieKey(key, "signing"))
+	mac.Write([]byte(name + "\x00" + signed))
+	return mac.Sum(nil)
+}
+
+// signCookie returns payload encoded and signed with key
+func signCookie(key SigningKey, name string, payload []byte) string {
+	signed := "s." + base64.RawURLEncoding.EncodeToString([]byte(key.ID)) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(cookieMAC(key, name, signed))
+}
+
+// verifyCookie returns the payload of a signed cookie split into parts
+func verifyCookie(key SigningKey, name string, parts []string) ([]byte, error) {
+	mac, err := base64.RawURLEncoding.DecodeString(parts[3])
+	if err != nil || !hmac.Equal(mac, cookieMAC(key, name, strings.Join(parts[:3], "."))) {
+		return nil, ErrCookieInvalid
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrCookieInvalid
+	}
+	return payload, nil
+}
+
+// cookieCipher returns the AES-GCM cipher of key
+func cookieCipher(key SigningKey) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(deriveCookieKey(key, "encryption"))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encryptCookie returns payload encrypted with key
+func encryptCookie(key SigningKey, name string, payload []byte) (string, error) {
+	aead, err := cookieCipher(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(payload)+aead.Overhead())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return "", err
+	}
+
+	kid := base64.RawURLEncoding.EncodeToString([]byte(key.ID))
+	sealed := aead.Seal(nonce, nonce, payload, []byte(name+"\x00"+kid))
+	return "e." + kid + "." + base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// decryptCookie returns the payload of an encrypted cookie split into parts
+func decryptCookie(key SigningKey, name string, parts []string) ([]byte, error) {
+	aead, err := cookieCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return nil, ErrCookieInvalid
+	}
+
+	n := aead.NonceSize()
+	payload, err := aead.Open(nil, sealed[:n], sealed[n:], []byte(name+"\x00"+parts[1]))
+	if err != nil {
+		return nil, ErrCookieInvalid
+	}
+	return payload, nil
+}
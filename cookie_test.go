@@ -0,0 +1,141 @@
+package webmod
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTools_SecureCookie(t *testing.T) {
+	type state struct {
+		User  string `json:"user"`
+		Theme string `json:"theme"`
+	}
+	value := state{User: "alice", Theme: "dark"}
+
+	testTool := Tools{CookieKeys: []SigningKey{{ID: "k2", Secret: []byte("new signing secret")}, {ID: "k1", Secret: []byte("old signing secret")}}}
+	oldTool := Tools{CookieKeys: []SigningKey{{ID: "k1", Secret: []byte("old signing secret")}}}
+	otherTool := Tools{CookieKeys: []SigningKey{{ID: "k2", Secret: []byte("other signing secret")}}}
+
+	set := func(tool Tools, name string, opts ...CookieOptions) *http.Cookie {
+		w := httptest.NewRecorder()
+		err := tool.SetSecureCookie(w, name, value, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return w.Result().Cookies()[0]
+	}
+	renamed := func(c *http.Cookie, name string) *http.Cookie {
+		copied := *c
+		copied.Name = name
+		return &copied
+	}
+	tampered := func(c *http.Cookie) *http.Cookie {
+		copied := *c
+		b := []byte(copied.Value)
+		b[len(b)/2] ^= 1
+		copied.Value = string(b)
+		return &copied
+	}
+
+	tests := []struct {
+		name     string
+		cookie   *http.Cookie
+		errorExp error
+	}{
+		{name: "Signed", cookie: set(testTool, "state")},
+		{name: "Encrypted", cookie: set(testTool, "state", CookieOptions{Encrypt: true})},
+		{name: "Rotated key", cookie: set(oldTool, "state")},
+		{name: "Rotated key encrypted", cookie: set(oldTool, "state", CookieOptions{Encrypt: true})},
+		{name: "No cookie", errorExp: ErrCookieNotFound},
+		{name: "Wrong secret", cookie: set(otherTool, "state"), errorExp: ErrCookieInvalid},
+		{name: "Wrong secret encrypted", cookie: set(otherTool, "state", CookieOptions{Encrypt: true}), errorExp: ErrCookieInvalid},
+		{name: "Tampered", cookie: tampered(set(testTool, "state")), errorExp: ErrCookieInvalid},
+		{name: "Tampered encrypted", cookie: tampered(set(testTool, "state", CookieOptions{Encrypt: true})), errorExp: ErrCookieInvalid},
+		{name: "Other cookie", cookie: renamed(set(testTool, "other"), "state"), errorExp: ErrCookieInvalid},
+		{name: "Other cookie encrypted", cookie: renamed(set(testTool, "other", CookieOptions{Encrypt: true}), "state"), errorExp: ErrCookieInvalid},
+		{name: "Expired", cookie: set(testTool, "state", CookieOptions{MaxAge: -time.Minute}), errorExp: ErrCookieExpired},
+		{name: "Garbage", cookie: &http.Cookie{Name: "state", Value: "abc"}, errorExp: ErrCookieInvalid},
+	}
+
+	for _, e := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		if e.cookie != nil {
+			r.AddCookie(e.cookie)
+		}
+
+		var received state
+		err := testTool.GetSecureCookie(r, "state", &received)
+		if !errors.Is(err, e.errorExp) {
+			printErr(t, e.name, "Unexpected error", fmt.Sprintf("Expected: %v", e.errorExp), fmt.Sprintf("Received: %v", err))
+			continue
+		}
+		if err == nil && received != value {
+			printErr(t, e.name, "Wrong value", fmt.Sprintf("Received: %+v", received))
+		}
+	}
+}
+
+func TestTools_SetSecureCookie(t *testing.T) {
+	testTool := Tools{CookieKeys: []SigningKey{{ID: "k1", Secret: []byte("test signing secret")}}}
+
+	w := httptest.NewRecorder()
+	err := testTool.SetSecureCookie(w, "state", "secret value", CookieOptions{Encrypt: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := w.Result().Cookies()[0]
+	if !c.HttpOnly || !c.Secure || c.SameSite != http.SameSiteLaxMode || c.Path != "/" || c.MaxAge != 24*60*60 {
+		printErr(t, "Defaults", "Wrong cookie attributes", fmt.Sprintf("Received: %s", c))
+	}
+	if strings.Contains(w.Header().Get("Set-Cookie"), "secret") {
+		printErr(t, "Encrypted", "Value readable by the client")
+	}
+
+	err = testTool.SetSecureCookie(httptest.NewRecorder(), "state", strings.Repeat("a", 4096))
+	if !errors.Is(err, ErrCookieTooLarge) {
+		printErr(t, "Too large", "Expected too large error", fmt.Sprintf("Received: %v", err))
+	}
+
+	err = (&Tools{}).SetSecureCookie(httptest.NewRecorder(), "state", "value")
+	if !errors.Is(err, ErrNoCookieKey) {
+		printErr(t, "No key", "Expected no key error", fmt.Sprintf("Received: %v", err))
+	}
+
+	w = httptest.NewRecorder()
+	testTool.DeleteCookie(w, "state")
+	if c := w.Result().Cookies()[0]; c.MaxAge != -1 || c.Value != "" {
+		printErr(t, "Delete", "Cookie not removed", fmt.Sprintf("Received: %s", c))
+	}
+}
+
+func TestTools_SecureCookieWeakKeys(t *testing.T) {
+	for _, secret := range []string{"", "short secret"} {
+		tname := fmt.Sprintf("Secret %q", secret)
+		key := SigningKey{ID: "k1", Secret: []byte(secret)}
+		testTool := Tools{CookieKeys: []SigningKey{key}}
+
+		err := testTool.SetSecureCookie(httptest.NewRecorder(), "state", "value")
+		if !errors.Is(err, ErrNoCookieKey) {
+			printErr(t, tname, "Weak key used to sign", fmt.Sprintf("Received: %v", err))
+		}
+
+		// a cookie forged with the weak secret is refused
+		payload := make([]byte, 8)
+		binary.BigEndian.PutUint64(payload, uint64(time.Now().Add(time.Hour).Unix()))
+		payload = append(payload, `"admin"`...)
+		r := httptest.NewRequest("GET", "/", nil)
+		r.AddCookie(&http.Cookie{Name: "state", Value: signCookie(key, "state", payload)})
+
+		var value string
+		err = testTool.GetSecureCookie(r, "state", &value)
+		if !errors.Is(err, ErrNoCookieKey) || value != "" {
+			printErr(t, tname, "Weak key used to verify", fmt.Sprintf("Received: %q %v", value, err))
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package webmod
+
+import (
+	"errors"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Errors answered to preflight requests that are not allowed
+var (
+	ErrCORSOrigin  = errors.New("Origin not allowed")
+	ErrCORSMethod  = errors.New("Method not allowed")
+	ErrCORSHeaders = errors.New("Headers not allowed")
+)
+
+// CORSOptions configures CORS.
+//
+// AllowedOrigins are origins such as "https://example.com", or with a
+// wildcard subdomain such as "https://*.example.com" (which does not match
+// "https://example.com" itself), compared without regard to case. "*" allows
+// any origin, but only for requests without credentials.
+// AllowedOriginPatterns are matched against the whole origin as sent, e.g.
+// `^https://pr-\d+\.preview\.example\.com$`.
+//
+// AllowedMethods default to GET, HEAD and POST, and AllowedHeaders to
+// Accept, Accept-Language, Content-Language, Content-Type and Authorization;
+// "*" allows any header. ExposedHeaders are the response headers scripts can
+// read. MaxAge is how long browsers may cache preflight answers.
+// AllowPrivateNetwork lets public sites reach a server on a private network.
+type CORSOptions struct {
+	AllowedOrigins        []string
+	AllowedOriginPatterns []*regexp.Regexp
+	AllowedMethods        []string
+	AllowedHeaders        []string
+	ExposedHeaders        []string
+	AllowCredentials      bool
+	MaxAge                time.Duration
+	AllowPrivateNetwork   bool
+}
+
+// withDefaults returns the options with the defaults set for zero values
+func (o CORSOptions) withDefaults() CORSOptions {
+	if len(o.AllowedMethods) == 0 {
+		o.AllowedMethods = []string{http.MethodGet, http.MethodHead, http.MethodPost}
+	}
+	if len(o.AllowedHeaders) == 0 {
+		o.AllowedHeaders = []string{"Accept", "Accept-Language", "Content-Language", "Content-Type", "Authorization"}
+	}
+	return o
+}
+
+// CORS is middleware answering cross-origin requests as described by opts.
+// Preflight requests are answered here and never reach next, with a 403
+// error if they are not allowed. Other requests from origins that are not
+// allowed are passed on without CORS headers, so that browsers do not let
+// scripts read the response.
+func (t *Tools) CORS(next http.Handler, opts CORSOptions) http.Handler {
+	opts = opts.withDefaults()
+
+	anyOrigin := false
+	for _, o := range opts.AllowedOrigins {
+		if o == "*" {
+			anyOrigin = true
+		}
+	}
+	anyHeader := false
+	for _, h := range opts.AllowedHeaders {
+		if h == "*" {
+			anyHeader = true
+		}
+	}
+
+	// whether an origin is allowed, and whether by "*" only
+	allowOrigin := func(origin string) (allowed, wildcard bool) {
+		if origin == "" {
+			return false, false
+		}
+		if corsOriginAllowed(opts, origin) {
+			return true, false
+		}
+		return anyOrigin, anyOrigin
+	}
+
+	// the CORS headers of responses to an allowed origin
+	setOriginHeaders := func(h http.Header, origin string, wildcard bool) {
+		if wildcard && !opts.AllowCredentials && len(opts.AllowedOrigins) == 1 && len(opts.AllowedOriginPatterns) == 0 {
+			h.Set("Access-Control-Allow-Origin", "*")
+			return
+		}
+		h.Set("Access-Control-Allow-Origin", origin)
+		if opts.AllowCredentials && !wildcard {
+			h.Set("Access-Control-Allow-Credentials", "true")
+		}
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		origin := r.Header.Get("Origin")
+		// responses depend on the origin, unless any origin gets "*"
+		if !(anyOrigin && len(opts.AllowedOrigins) == 1 && len(opts.AllowedOriginPatterns) == 0 && !opts.AllowCredentials) {
+			h.Add("Vary", "Origin")
+		}
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			h.Add("Vary", "Access-Control-Request-Method")
+			h.Add("Vary", "Access-Control-Request-Headers")
+			if opts.AllowPrivateNetwork {
+				h.Add("Vary", "Access-Control-Request-Private-Network")
+			}
+
+			allowed, wildcard := allowOrigin(origin)
+			if !allowed {
+				t.ErrorJSON(w, ErrCORSOrigin, http.StatusForbidden)
+				return
+			}
+			method := r.Header.Get("Access-Control-Request-Method")
+			if !containsString(opts.AllowedMethods, method) {
+				t.ErrorJSON(w, ErrCORSMethod, http.StatusForbidden)
+				return
+			}
+			headers, ok := corsRequestHeaders(r, opts.AllowedHeaders, anyHeader)
+			if !ok {
+				t.ErrorJSON(w, ErrCORSHeaders, http.StatusForbidden)
+				return
+			}
+
+			setOriginHeaders(h, origin, wildcard)
+			h.Set("Access-Control-Allow-Methods", method)
+			if headers != "" {
+				h.Set("Access-Control-Allow-Headers", headers)
+			}
+			if opts.MaxAge > 0 {
+				h.Set("Access-Control-Max-Age", strconv.Itoa(int(opts.MaxAge/time.Second)))
+			}
+			if opts.AllowPrivateNetwork && r.Header.Get("Access-Control-Request-Private-Network") == "true" {
+				h.Set("Access-Control-Allow-Private-Network", "true")
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		if allowed, wildcard := allowOrigin(origin); allowed {
+			setOriginHeaders(h, origin, wildcard)
+			if len(opts.ExposedHeaders) > 0 {
+				h.Set("Access-Control-Expose-Headers", strings.Join(opts.ExposedHeaders, ", "))
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// corsOriginAllowed reports whether origin is allowed by name or pattern
+func corsOriginAllowed(opts CORSOptions, origin string) bool {
+	lower := strings.ToLower(origin)
+	for _, allowed := range opts.AllowedOrigins {
+		allowed = strings.ToLower(allowed)
+		if allowed == "*" {
+			continue
+		}
+		if allowed == lower {
+			return true
+		}
+
+		// a wildcard subdomain, e.g. "https://*.example.com"
+		i := strings.Index(allowed, "*")
+		if i < 0 {
+			continue
+		}
+		prefix, suffix := allowed[:i], allowed[i+1:]
+		if len(lower) > len(prefix)+len(suffix) && strings.HasPrefix(lower, prefix) && strings.HasSuffix(lower, suffix) {
+			sub := lower[len(prefix) : len(lower)-len(suffix)]
+			if !strings.ContainsAny(sub, "/:@") && !strings.HasPrefix(sub, ".") {
+				return true
+			}
+		}
+	}
+
+	// patterns decide themselves about case, e.g. with (?i)
+	for _, p := range opts.AllowedOriginPatterns {
+		if p.MatchString(origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// corsRequestHeaders returns the headers requested by a preflight request,
+// and whether they are all allowed.
+func corsRequestHeaders(r *http.Request, allowed []string, anyHeader bool) (string, bool) {
+	var headers []string
+	for _, v := range r.Header.Values("Access-Control-Request-Headers") {
+		for _, h := range strings.Split(v, ",") {
+			h = strings.ToLower(strings.TrimSpace(h))
+			if h == "" {
+				continue
+			}
+			if !anyHeader && !containsFold(allowed, h) {
+				return "", false
+			}
+			headers = append(headers, h)
+		}
+	}
+	return strings.Join(headers, ", "), true
+}
+
+// containsString reports whether list holds s
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// containsFold reports whether list holds s, ignoring case
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,84 @@
+package webmod
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTools_CORS(t *testing.T) {
+	var testTool Tools
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("handled")) })
+
+	opts := CORSOptions{
+		AllowedOrigins:        []string{"https://example.com", "https://*.example.org"},
+		AllowedOriginPatterns: []*regexp.Regexp{regexp.MustCompile(`^https://pr-\d+\.preview\.example\.net$`), regexp.MustCompile(`^https://Staging\.example\.net$`)},
+		AllowedMethods:        []string{"GET", "PUT"},
+		AllowedHeaders:        []string{"Content-Type", "X-Request-ID"},
+		ExposedHeaders:        []string{"X-Total-Count"},
+		AllowCredentials:      true,
+		MaxAge:                10 * time.Minute,
+		AllowPrivateNetwork:   true,
+	}
+	anyOrigin := CORSOptions{AllowedOrigins: []string{"*"}}
+
+	tests := []struct {
+		name           string
+		opts           CORSOptions
+		method         string
+		headers        map[string]string
+		expectedCode   int
+		expectedHeader map[string]string
+		expectedVary   string
+		handled        bool
+	}{
+		{name: "Exact origin", opts: opts, method: "GET", headers: map[string]string{"Origin": "https://example.com"}, expectedCode: http.StatusOK, expectedHeader: map[string]string{"Access-Control-Allow-Origin": "https://example.com", "Access-Control-Allow-Credentials": "true", "Access-Control-Expose-Headers": "X-Total-Count"}, expectedVary: "Origin", handled: true},
+		{name: "Wildcard subdomain", opts: opts, method: "GET", headers: map[string]string{"Origin": "https://a.b.example.org"}, expectedCode: http.StatusOK, expectedHeader: map[string]string{"Access-Control-Allow-Origin": "https://a.b.example.org"}, expectedVary: "Origin", handled: true},
+		{name: "Wildcard parent domain", opts: opts, method: "GET", headers: map[string]string{"Origin": "https://example.org"}, expectedCode: http.StatusOK, expectedHeader: map[string]string{"Access-Control-Allow-Origin": ""}, expectedVary: "Origin", handled: true},
+		{name: "Wildcard lookalike", opts: opts, method: "GET", headers: map[string]string{"Origin": "https://evilexample.org"}, expectedCode: http.StatusOK, expectedHeader: map[string]string{"Access-Control-Allow-Origin": ""}, expectedVary: "Origin", handled: true},
+		{name: "Pattern", opts: opts, method: "GET", headers: map[string]string{"Origin": "https://pr-42.preview.example.net"}, expectedCode: http.StatusOK, expectedHeader: map[string]string{"Access-Control-Allow-Origin": "https://pr-42.preview.example.net"}, expectedVary: "Origin", handled: true},
+		{name: "Pattern case", opts: opts, method: "GET", headers: map[string]string{"Origin": "https://Staging.example.net"}, expectedCode: http.StatusOK, expectedHeader: map[string]string{"Access-Control-Allow-Origin": "https://Staging.example.net"}, expectedVary: "Origin", handled: true},
+		{name: "Pattern other case", opts: opts, method: "GET", headers: map[string]string{"Origin": "https://staging.example.net"}, expectedCode: http.StatusOK, expectedHeader: map[string]string{"Access-Control-Allow-Origin": ""}, expectedVary: "Origin", handled: true},
+		{name: "Exact origin case", opts: opts, method: "GET", headers: map[string]string{"Origin": "https://Example.com"}, expectedCode: http.StatusOK, expectedHeader: map[string]string{"Access-Control-Allow-Origin": "https://Example.com"}, expectedVary: "Origin", handled: true},
+		{name: "Other origin", opts: opts, method: "GET", headers: map[string]string{"Origin": "https://example.com.evil.com"}, expectedCode: http.StatusOK, expectedHeader: map[string]string{"Access-Control-Allow-Origin": "", "Access-Control-Allow-Credentials": ""}, expectedVary: "Origin", handled: true},
+		{name: "No origin", opts: opts, method: "GET", expectedCode: http.StatusOK, expectedHeader: map[string]string{"Access-Control-Allow-Origin": ""}, expectedVary: "Origin", handled: true},
+		{name: "Any origin", opts: anyOrigin, method: "GET", headers: map[string]string{"Origin": "https://example.com"}, expectedCode: http.StatusOK, expectedHeader: map[string]string{"Access-Control-Allow-Origin": "*"}, handled: true},
+		{name: "Any origin with credentials", opts: CORSOptions{AllowedOrigins: []string{"*"}, AllowCredentials: true}, method: "GET", headers: map[string]string{"Origin": "https://example.com"}, expectedCode: http.StatusOK, expectedHeader: map[string]string{"Access-Control-Allow-Origin": "https://example.com", "Access-Control-Allow-Credentials": ""}, expectedVary: "Origin", handled: true},
+		{name: "Preflight", opts: opts, method: "OPTIONS", headers: map[string]string{"Origin": "https://example.com", "Access-Control-Request-Method": "PUT", "Access-Control-Request-Headers": "content-type, X-Request-ID", "Access-Control-Request-Private-Network": "true"}, expectedCode: http.StatusNoContent, expectedHeader: map[string]string{"Access-Control-Allow-Origin": "https://example.com", "Access-Control-Allow-Credentials": "true", "Access-Control-Allow-Methods": "PUT", "Access-Control-Allow-Headers": "content-type, x-request-id", "Access-Control-Max-Age": "600", "Access-Control-Allow-Private-Network": "true"}, expectedVary: "Origin, Access-Control-Request-Method, Access-Control-Request-Headers, Access-Control-Request-Private-Network"},
+		{name: "Preflight any header", opts: CORSOptions{AllowedOrigins: []string{"*"}, AllowedHeaders: []string{"*"}}, method: "OPTIONS", headers: map[string]string{"Origin": "https://example.com", "Access-Control-Request-Method": "POST", "Access-Control-Request-Headers": "X-Anything"}, expectedCode: http.StatusNoContent, expectedHeader: map[string]string{"Access-Control-Allow-Origin": "*", "Access-Control-Allow-Headers": "x-anything", "Access-Control-Max-Age": "", "Access-Control-Allow-Private-Network": ""}, expectedVary: "Access-Control-Request-Method, Access-Control-Request-Headers"},
+		{name: "Preflight other origin", opts: opts, method: "OPTIONS", headers: map[string]string{"Origin": "https://evil.com", "Access-Control-Request-Method": "GET"}, expectedCode: http.StatusForbidden, expectedHeader: map[string]string{"Access-Control-Allow-Origin": ""}},
+		{name: "Preflight method", opts: opts, method: "OPTIONS", headers: map[string]string{"Origin": "https://example.com", "Access-Control-Request-Method": "DELETE"}, expectedCode: http.StatusForbidden, expectedHeader: map[string]string{"Access-Control-Allow-Origin": ""}},
+		{name: "Preflight headers", opts: opts, method: "OPTIONS", headers: map[string]string{"Origin": "https://example.com", "Access-Control-Request-Method": "GET", "Access-Control-Request-Headers": "Content-Type, X-Secret"}, expectedCode: http.StatusForbidden, expectedHeader: map[string]string{"Access-Control-Allow-Origin": ""}},
+		{name: "Plain OPTIONS", opts: opts, method: "OPTIONS", headers: map[string]string{"Origin": "https://example.com"}, expectedCode: http.StatusOK, expectedHeader: map[string]string{"Access-Control-Allow-Methods": ""}, expectedVary: "Origin", handled: true},
+	}
+
+	for _, e := range tests {
+		r := httptest.NewRequest(e.method, "/", nil)
+		for k, v := range e.headers {
+			r.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		testTool.CORS(ok, e.opts).ServeHTTP(w, r)
+
+		if w.Code != e.expectedCode {
+			printErr(t, e.name, "Wrong status", fmt.Sprintf("Expected: %d", e.expectedCode), fmt.Sprintf("Received: %d", w.Code))
+		}
+		for k, v := range e.expectedHeader {
+			if w.Header().Get(k) != v {
+				printErr(t, e.name, "Wrong "+k, fmt.Sprintf("Expected: %q", v), fmt.Sprintf("Received: %q", w.Header().Get(k)))
+			}
+		}
+		if e.expectedVary != "" || e.expectedCode != http.StatusForbidden {
+			if vary := strings.Join(w.Header().Values("Vary"), ", "); vary != e.expectedVary {
+				printErr(t, e.name, "Wrong Vary", fmt.Sprintf("Expected: %q", e.expectedVary), fmt.Sprintf("Received: %q", vary))
+			}
+		}
+		if handled := strings.Contains(w.Body.String(), "handled"); handled != e.handled {
+			printErr(t, e.name, "Wrong handling", fmt.Sprintf("Expected: %v", e.handled), fmt.Sprintf("Received: %v", handled))
+		}
+	}
+}
@@ -0,0 +1,250 @@
+package webmod
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Errors reported by CSRFProtect
+var (
+	ErrCSRFOrigin       = errors.New("Cross-origin request denied")
+	ErrCSRFTokenMissing = errors.New("CSRF token missing")
+	ErrCSRFTokenInvalid = errors.New("CSRF token invalid")
+)
+
+// the length of CSRF tokens, 32 base62 characters hold more than 190 bits
+const csrfTokenLen = 32
+
+// CSRFOptions configures CSRFProtect. The token is kept in the CookieName
+// cookie (default "csrf_token"), and sent back in the HeaderName header
+// (default "X-CSRF-Token") or the FormField form field (default "csrf_token").
+// The cookie is Secure unless InsecureCookie is set, e.g. for development.
+//
+// TrustedOrigins are other origins allowed to post, e.g.
+// "https://admin.example.com". Requests to ExemptPaths are not checked,
+// paths ending in "/" exempt all paths below them.
+type CSRFOptions struct {
+	CookieName     string
+	CookieDomain   string
+	InsecureCookie bool
+	HeaderName     string
+	FormField      string
+	TrustedOrigins []string
+	ExemptPaths    []string
+}
+
+// withDefaults returns the options with the defaults set for zero values
+func (o CSRFOptions) withDefaults() CSRFOptions {
+	if o.CookieName == "" {
+		o.CookieName = "csrf_token"
+	}
+	if o.HeaderName == "" {
+		o.HeaderName = "X-CSRF-Token"
+	}
+	if o.FormField == "" {
+		o.FormField = "csrf_token"
+	}
+	return o
+}
+
+// csrfTokenKey is the context key of the CSRF token of a request
+type csrfTokenKey struct{}
+
+// CSRFProtect is middleware protecting against cross-site request forgery,
+// with the CSRF options. Every request gets a token, kept in a cookie, that
+// pages get with CSRFToken. Requests with unsafe methods (e.g. POST) must
+// come from the same origin, or a trusted one, and send the token back in a
+// header or form field. They are answered with a 403 error otherwise.
+//
+// In multipart forms the token field must come first, so that it can be read
+// without parsing the whole form, and leave the files to UploadFiles.
+func (t *Tools) CSRFProtect(next http.Handler) http.Handler {
+	opts := t.CSRF.withDefaults()
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := ""
+		if c, err := r.Cookie(opts.CookieName); err == nil && validCSRFToken(c.Value) {
+			token = c.Value
+		}
+		if token == "" {
+			var err error
+			token, err = randomFrom(base62, csrfTokenLen)
+			if err != nil {
+				t.ErrorJSON(w, err, http.StatusInternalServerError)
+				return
+			}
+			http.SetCookie(w, &http.Cookie{
+				Name:     opts.CookieName,
+				Value:    token,
+				Path:     "/",
+				Domain:   opts.CookieDomain,
+				Secure:   !opts.InsecureCookie,
+				HttpOnly: true,
+				SameSite: http.SameSiteLaxMode,
+			})
+		}
+		w.Header().Add("Vary", "Cookie")
+		r = r.WithContext(context.WithValue(r.Context(), csrfTokenKey{}, token))
+
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+			next.ServeHTTP(w, r)
+			return
+		}
+		if csrfExempt(opts, r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if !csrfSameOrigin(opts, r) {
+			t.ErrorJSON(w, ErrCSRFOrigin, http.StatusForbidden)
+			return
+		}
+
+		sent := r.Header.Get(opts.HeaderName)
+		if sent == "" {
+			sent = csrfFormToken(r, opts.FormField)
+		}
+		if sent == "" {
+			t.ErrorJSON(w, ErrCSRFTokenMissing, http.StatusForbidden)
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(unmaskCSRFToken(sent)), []byte(token)) != 1 {
+			t.ErrorJSON(w, ErrCSRFTokenInvalid, http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// CSRFToken returns the CSRF token of a request passed through CSRFProtect,
+// to be put in forms or sent back in a header. The token is masked with new
+// random bytes on every call, so that it never shows up twice in a page,
+// which would let it be guessed from compressed responses (BREACH).
+func (t *Tools) CSRFToken(r *http.Request) string {
+	token, ok := r.Context().Value(csrfTokenKey{}).(string)
+	if !ok {
+		return ""
+	}
+
+	masked := make([]byte, 2*len(token))
+	_, err := rand.Read(masked[:len(token)])
+	if err != nil {
+		return ""
+	}
+	for i := 0; i < len(token); i++ {
+		masked[len(token)+i] = masked[i] ^ token[i]
+	}
+	return base64.RawURLEncoding.EncodeToString(masked)
+}
+
+// unmaskCSRFToken returns the token masked by CSRFToken
+func unmaskCSRFToken(masked string) string {
+	data, err := base64.RawURLEncoding.DecodeString(masked)
+	if err != nil || len(data) != 2*csrfTokenLen {
+		return ""
+	}
+	token := make([]byte, csrfTokenLen)
+	for i := range token {
+		token[i] = data[i] ^ data[csrfTokenLen+i]
+	}
+	return string(token)
+}
+
+// validCSRFToken reports whether token could have been made by CSRFProtect
+func validCSRFToken(token string) bool {
+	if len(token) != csrfTokenLen {
+		return false
+	}
+	for i := 0; i < len(token); i++ {
+		if strings.IndexByte(base62, token[i]) < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// csrfExempt reports whether requests to urlPath are not checked
+func csrfExempt(opts CSRFOptions, urlPath string) bool {
+	for _, p := range opts.ExemptPaths {
+		if urlPath == p || strings.HasSuffix(p, "/") && strings.HasPrefix(urlPath, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// csrfSameOrigin reports whether r comes from the same origin or a trusted
+// one, going by the Origin header or, for HTTPS requests without it, the
+// Referer header. Plain HTTP requests without an Origin are let through,
+// as proxies often strip their Referer.
+func csrfSameOrigin(opts CSRFOptions, r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		if r.TLS == nil {
+			return true
+		}
+		referer, err := url.Parse(r.Referer())
+		if err != nil || referer.Host == "" {
+			return false
+		}
+		origin = referer.Scheme + "://" + referer.Host
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	if strings.EqualFold(u.Host, r.Host) && (r.TLS == nil || u.Scheme == "https") {
+		return true
+	}
+	for _, trusted := range opts.TrustedOrigins {
+		if strings.EqualFold(strings.TrimSuffix(trusted, "/"), u.Scheme+"://"+u.Host) {
+			return true
+		}
+	}
+	return false
+}
+
+// csrfFormToken returns the token sent in the field form field. Multipart
+// forms are only read up to their first part, and their body is restored.
+func csrfFormToken(r *http.Request, field string) string {
+	mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return ""
+	}
+
+	switch mediaType {
+	case "application/x-www-form-urlencoded":
+		return r.PostFormValue(field)
+
+	case "multipart/form-data":
+		var buf bytes.Buffer
+		body := r.Body
+		defer func() {
+			r.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.MultiReader(&buf, body), body}
+		}()
+
+		mr := multipart.NewReader(io.TeeReader(io.LimitReader(body, 8192), &buf), params["boundary"])
+		part, err := mr.NextPart()
+		if err != nil || part.FormName() != field || part.FileName() != "" {
+			return ""
+		}
+		token, _ := io.ReadAll(io.LimitReader(part, 256))
+		return string(token)
+	}
+	return ""
+}
@@ -0,0 +1,124 @@
+package webmod
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestTools_CSRFProtect(t *testing.T) {
+	testTool := Tools{CSRF: CSRFOptions{
+		TrustedOrigins: []string{"https://admin.example.com"},
+		ExemptPaths:    []string{"/webhook", "/api/public/"},
+	}}
+
+	var pageToken string
+	handler := testTool.CSRFProtect(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			pageToken = testTool.CSRFToken(r)
+		}
+		// the body is left intact for the handler
+		body, _ := io.ReadAll(r.Body)
+		w.Write(body)
+	}))
+
+	// a first GET sets the cookie and gives the page a token
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "https://example.com/form", nil))
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != "csrf_token" || !cookies[0].Secure || !cookies[0].HttpOnly {
+		t.Fatalf("Expected a secure csrf_token cookie, received: %v", cookies)
+	}
+	cookie := cookies[0]
+	if pageToken == "" || pageToken == cookie.Value {
+		t.Fatalf("Expected a masked token, received: %q", pageToken)
+	}
+	if other := testTool.CSRFToken(httptest.NewRequest("GET", "/", nil)); other != "" {
+		printErr(t, "CSRF token", "Expected no token outside of the middleware", fmt.Sprintf("Received: %s", other))
+	}
+
+	multipartBody := func(first, value string) string {
+		return "--b\r\nContent-Disposition: form-data; name=\"" + first + "\"\r\n\r\n" + value + "\r\n--b--\r\n"
+	}
+
+	tests := []struct {
+		name         string
+		method       string
+		path         string
+		origin       string
+		referer      string
+		header       string
+		contentType  string
+		body         string
+		noCookie     bool
+		expectedCode int
+		expectedBody string
+	}{
+		{name: "Header token", method: "POST", origin: "https://example.com", header: pageToken, expectedCode: http.StatusOK},
+		{name: "Form token", method: "POST", origin: "https://example.com", contentType: "application/x-www-form-urlencoded", body: url.Values{"csrf_token": {pageToken}}.Encode(), expectedCode: http.StatusOK},
+		{name: "Multipart token", method: "POST", origin: "https://example.com", contentType: "multipart/form-data; boundary=b", body: multipartBody("csrf_token", pageToken), expectedCode: http.StatusOK, expectedBody: pageToken},
+		{name: "Multipart token not first", method: "POST", origin: "https://example.com", contentType: "multipart/form-data; boundary=b", body: multipartBody("name", "x"), expectedCode: http.StatusForbidden, expectedBody: ErrCSRFTokenMissing.Error()},
+		{name: "Referer", method: "POST", referer: "https://example.com/form", header: pageToken, expectedCode: http.StatusOK},
+		{name: "Trusted origin", method: "POST", origin: "https://admin.example.com", header: pageToken, expectedCode: http.StatusOK},
+		{name: "Cross origin", method: "POST", origin: "https://evil.example", header: pageToken, expectedCode: http.StatusForbidden, expectedBody: ErrCSRFOrigin.Error()},
+		{name: "Downgraded origin", method: "POST", origin: "http://example.com", header: pageToken, expectedCode: http.StatusForbidden, expectedBody: ErrCSRFOrigin.Error()},
+		{name: "No origin or referer", method: "POST", header: pageToken, expectedCode: http.StatusForbidden, expectedBody: ErrCSRFOrigin.Error()},
+		{name: "Missing token", method: "POST", origin: "https://example.com", expectedCode: http.StatusForbidden, expectedBody: ErrCSRFTokenMissing.Error()},
+		{name: "Unmasked token", method: "POST", origin: "https://example.com", header: cookie.Value, expectedCode: http.StatusForbidden, expectedBody: ErrCSRFTokenInvalid.Error()},
+		{name: "Missing cookie", method: "POST", origin: "https://example.com", header: pageToken, noCookie: true, expectedCode: http.StatusForbidden, expectedBody: ErrCSRFTokenInvalid.Error()},
+		{name: "Safe method", method: "GET", origin: "https://evil.example", expectedCode: http.StatusOK},
+		{name: "Exempt path", method: "POST", path: "/webhook", origin: "https://evil.example", expectedCode: http.StatusOK},
+		{name: "Exempt prefix", method: "POST", path: "/api/public/x", origin: "https://evil.example", expectedCode: http.StatusOK},
+		{name: "Not exempt", method: "POST", path: "/webhook/x", origin: "https://evil.example", expectedCode: http.StatusForbidden},
+	}
+
+	for _, e := range tests {
+		path := e.path
+		if path == "" {
+			path = "/form"
+		}
+		r := httptest.NewRequest(e.method, "https://example.com"+path, strings.NewReader(e.body))
+		r.TLS = &tls.ConnectionState{}
+		if !e.noCookie {
+			r.AddCookie(cookie)
+		}
+		if e.origin != "" {
+			r.Header.Set("Origin", e.origin)
+		}
+		if e.referer != "" {
+			r.Header.Set("Referer", e.referer)
+		}
+		if e.header != "" {
+			r.Header.Set("X-CSRF-Token", e.header)
+		}
+		if e.contentType != "" {
+			r.Header.Set("Content-Type", e.contentType)
+		}
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		if w.Code != e.expectedCode || !strings.Contains(w.Body.String(), e.expectedBody) {
+			printErr(t, e.name, "Wrong response", fmt.Sprintf("Expected: %d %s", e.expectedCode, e.expectedBody), fmt.Sprintf("Received: %d %s", w.Code, w.Body.String()))
+		}
+	}
+}
+
+func TestTools_CSRFToken(t *testing.T) {
+	var testTool Tools
+	var tokens []string
+	handler := testTool.CSRFProtect(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokens = append(tokens, testTool.CSRFToken(r), testTool.CSRFToken(r))
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	// every call masks the same token differently
+	if tokens[0] == tokens[1] || unmaskCSRFToken(tokens[0]) != unmaskCSRFToken(tokens[1]) || unmaskCSRFToken(tokens[0]) == "" {
+		printErr(t, "Masked tokens", "Expected different masks of the same token", fmt.Sprintf("Received: %v", tokens))
+	}
+}
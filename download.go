@@ -1,17 +1,193 @@
 package webmod
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
+	"mime"
 	"net/http"
+	"os"
 	"path"
+	"strings"
+	"time"
 )
 
+// DownloadOptions controls how a file is sent to the client
+// by DownloadStaticFile, DownloadContent and DownloadFS.
+type DownloadOptions struct {
+	// Inline lets the browser display the file instead of downloading it,
+	// but only if its content type is one of InlineTypes.
+	Inline bool
+	// ContentType overrides the content type detected for the file
+	ContentType string
+	// InlineTypes are the content types that may be displayed inline,
+	// defaulting to common image, audio, video, PDF and plain text types.
+	// Types that can run scripts, such as HTML and SVG, are always downloaded.
+	InlineTypes []string
+}
+
+// defaultInlineTypes are the content types that are safe to display inline
+var defaultInlineTypes = []string{
+	"image/png", "image/jpeg", "image/gif", "image/webp", "image/bmp", "image/avif",
+	"audio/mpeg", "audio/mp4", "audio/wave", "audio/ogg",
+	"video/mp4", "video/webm", "video/ogg",
+	"application/pdf", "text/plain",
+}
+
+// activeContentTypes are content types that can run scripts in the browser,
+// they are never displayed inline.
+var activeContentTypes = []string{
+	"text/html", "application/xhtml+xml", "image/svg+xml", "text/xml", "application/xml",
+	"text/javascript", "application/javascript", "application/x-shockwave-flash",
+}
+
 // DownloadStaticFile downloads a file for the client,
 // avoids displaying the file in the browser, forces it to
 // directly downloads it instead by setting the content disposition.
-// It also allows the specification of the display name
-func (t *Tools) DownloadStaticFile(w http.ResponseWriter, r *http.Request, dir, file, displayName string) {
+// It also allows the specification of the display name.
+// The optional DownloadOptions allow safe files to be displayed inline instead.
+// The download is throttled by DownloadResponseRate and DownloadBandwidth, if set.
+func (t *Tools) DownloadStaticFile(w http.ResponseWriter, r *http.Request, dir, file, displayName string, opts ...DownloadOptions) {
 	fp := path.Join(dir, file)
-	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", displayName))
-	http.ServeFile(w, r, fp)
+	t.setDownloadHeaders(w, fp, displayName, func() []byte {
+		f, err := os.Open(fp)
+		if err != nil {
+			return nil
+		}
+		defer f.Close()
+		return sniffReader(f)
+	}, opts)
+	http.ServeFile(t.throttle(w, r), r, fp)
+}
+
+// setDownloadHeaders sets the content type, content disposition and nosniff
+// headers of a download. The content type is taken from the options, the
+// extension of name or the content returned by sniff, in that order.
+func (t *Tools) setDownloadHeaders(w http.ResponseWriter, name, displayName string, sniff func() []byte, opts []DownloadOptions) {
+	var opt DownloadOptions
+	if len(opts) > 0 {
+		opt = opts[0]
+	}
+
+	ctype := opt.ContentType
+	if ctype == "" {
+		ctype = mime.TypeByExtension(path.Ext(name))
+	}
+	if ctype == "" {
+		ctype = extensionFileTypes[strings.ToLower(path.Ext(name))]
+	}
+	if ctype == "" {
+		ctype = t.DetectContentType(sniff())
+	}
+
+	disposition := "attachment"
+	inlineTypes := opt.InlineTypes
+	if inlineTypes == nil {
+		inlineTypes = defaultInlineTypes
+	}
+	if opt.Inline && fileTypeAllowed(ctype, inlineTypes) && !fileTypeAllowed(ctype, activeContentTypes) {
+		disposition = "inline"
+	}
+
+	w.Header().Set("Content-Type", ctype)
+	w.Header().Set("Content-Disposition", contentDisposition(disposition, displayName))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+}
+
+// sniffReader returns the first bytes of r, used to detect its content type
+func sniffReader(r io.Reader) []byte {
+	buf := make([]byte, sniffLen)
+	n, _ := io.ReadFull(r, buf)
+	return buf[:n]
+}
+
+// contentDisposition returns the value of a Content-Disposition header.
+// Quotes and control characters are removed from the display name, and
+// names that are not plain ASCII are also given in the RFC 5987 form.
+func contentDisposition(disposition, displayName string) string {
+	ascii := true
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r == '"' || r == '\\' || r < 0x20 || r == 0x7f:
+			return -1
+		case r > 0x7f:
+			ascii = false
+			return '_'
+		}
+		return r
+	}, displayName)
+
+	if ascii {
+		return fmt.Sprintf("%s; filename=\"%s\"", disposition, name)
+	}
+	return fmt.Sprintf("%s; filename=\"%s\"; filename*=UTF-8''%s", disposition, name, rfc5987Escape(displayName))
+}
+
+// rfc5987Escape percent-encodes a string for the extended
+// parameter form, keeping only the characters RFC 5987 allows.
+func rfc5987Escape(s string) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		if ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9') || strings.IndexByte("!#$&+-.^_`|~", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// DownloadContent downloads content for the client, like DownloadStaticFile,
+// but from any io.ReadSeeker, e.g. a report generated in memory. Range
+// requests are supported, modtime is used for conditional requests (and
+// ignored if zero) and the content type is detected from the display name,
+// or from the content itself if the name has no known extension.
+// It accepts the same DownloadOptions as DownloadStaticFile.
+func (t *Tools) DownloadContent(w http.ResponseWriter, r *http.Request, content io.ReadSeeker, modtime time.Time, displayName string, opts ...DownloadOptions) {
+	t.setDownloadHeaders(w, displayName, displayName, func() []byte {
+		defer content.Seek(0, io.SeekStart)
+		return sniffReader(content)
+	}, opts)
+	http.ServeContent(t.throttle(w, r), r, displayName, modtime, content)
+}
+
+// DownloadFS downloads a file from fsys for the client, like
+// DownloadStaticFile, e.g. from an embed.FS, and accepts the same
+// DownloadOptions. Files that can't seek are read into memory to
+// support range requests.
+func (t *Tools) DownloadFS(w http.ResponseWriter, r *http.Request, fsys fs.FS, file, displayName string, opts ...DownloadOptions) {
+	f, err := fsys.Open(path.Clean(strings.TrimPrefix(file, "/")))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) || errors.Is(err, fs.ErrInvalid) {
+			t.ErrorJSON(w, errors.New("File not found"), http.StatusNotFound)
+		} else {
+			t.ErrorJSON(w, errors.New("Unable to read file"), http.StatusInternalServerError)
+		}
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil || info.IsDir() {
+		t.ErrorJSON(w, errors.New("File not found"), http.StatusNotFound)
+		return
+	}
+
+	content, ok := f.(io.ReadSeeker)
+	if !ok {
+		data, err := io.ReadAll(f)
+		if err != nil {
+			t.ErrorJSON(w, errors.New("Unable to read file"), http.StatusInternalServerError)
+			return
+		}
+		content = bytes.NewReader(data)
+	}
+
+	t.setDownloadHeaders(w, file, displayName, func() []byte {
+		defer content.Seek(0, io.SeekStart)
+		return sniffReader(content)
+	}, opts)
+	http.ServeContent(t.throttle(w, r), r, displayName, info.ModTime(), content)
 }
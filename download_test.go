@@ -2,9 +2,15 @@ package webmod
 
 import (
 	"fmt"
+	"io/fs"
 	"io/ioutil"
+	"net/http"
 	"net/http/httptest"
+	"os"
+	"strings"
 	"testing"
+	"testing/fstest"
+	"time"
 )
 
 var downloadTest = struct {
@@ -59,3 +65,132 @@ func TestTools_DownloadStaticFile(t *testing.T) {
 		printErr(t, tname, err.Error())
 	}
 }
+
+func TestContentDisposition(t *testing.T) {
+	tests := []struct {
+		name        string
+		displayName string
+		expected    string
+	}{
+		{name: "Plain name", displayName: "wall.jpg", expected: `attachment; filename="wall.jpg"`},
+		{name: "Quotes removed", displayName: `wa"ll.jpg`, expected: `attachment; filename="wall.jpg"`},
+		{name: "Non-ASCII name", displayName: "straße.jpg", expected: `attachment; filename="stra_e.jpg"; filename*=UTF-8''stra%C3%9Fe.jpg`},
+	}
+
+	for _, e := range tests {
+		cd := contentDisposition("attachment", e.displayName)
+		if cd != e.expected {
+			printErr(t, e.name, "Wrong content disposition", fmt.Sprintf("Expected: %s", e.expected), fmt.Sprintf("Received: %s", cd))
+		}
+	}
+}
+
+func TestTools_DownloadContent(t *testing.T) {
+	tname := "Download content"
+	var testTool Tools
+
+	content := strings.NewReader("hello world")
+	modtime := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Range", "bytes=0-4")
+	testTool.DownloadContent(w, r, content, modtime, "report.txt")
+	res := w.Result()
+	defer res.Body.Close()
+
+	body, _ := ioutil.ReadAll(res.Body)
+	if res.StatusCode != http.StatusPartialContent || string(body) != "hello" {
+		printErr(t, tname, "Range not served", fmt.Sprintf("Received: %d %q", res.StatusCode, body))
+	}
+	if ct := res.Header.Get("Content-Type"); ct != "text/plain; charset=utf-8" {
+		printErr(t, tname, "Wrong content type", fmt.Sprintf("Received: %s", ct))
+	}
+	if cd := res.Header.Get("Content-Disposition"); cd != `attachment; filename="report.txt"` {
+		printErr(t, tname, "Wrong content disposition", fmt.Sprintf("Received: %s", cd))
+	}
+	if lm := res.Header.Get("Last-Modified"); lm != modtime.Format(http.TimeFormat) {
+		printErr(t, tname, "Wrong last modified", fmt.Sprintf("Received: %s", lm))
+	}
+}
+
+func TestTools_DownloadFS(t *testing.T) {
+	var testTool Tools
+
+	fsys := fstest.MapFS{
+		"docs/readme.txt": &fstest.MapFile{Data: []byte("hello world")},
+	}
+
+	tests := []struct {
+		name          string
+		fsys          fs.FS
+		file          string
+		status        int
+		contentLength string
+	}{
+		{name: "Map FS", fsys: fsys, file: "docs/readme.txt", status: http.StatusOK, contentLength: "11"},
+		{name: "Dir FS", fsys: os.DirFS("./testdata"), file: "red.jpg", status: http.StatusOK, contentLength: "1107051"},
+		{name: "Missing file", fsys: fsys, file: "missing.txt", status: http.StatusNotFound},
+		{name: "Directory", fsys: fsys, file: "docs", status: http.StatusNotFound},
+		{name: "Invalid path", fsys: fsys, file: "../secret", status: http.StatusNotFound},
+	}
+
+	for _, e := range tests {
+		w := httptest.NewRecorder()
+		testTool.DownloadFS(w, httptest.NewRequest("GET", "/", nil), e.fsys, e.file, "download")
+
+		if w.Code != e.status {
+			printErr(t, e.name, "Wrong status code", fmt.Sprintf("Expected: %d", e.status), fmt.Sprintf("Received: %d", w.Code))
+		}
+		if e.contentLength != "" && w.Header().Get("Content-Length") != e.contentLength {
+			printErr(t, e.name, "Wrong content length", fmt.Sprintf("Expected: %s", e.contentLength), fmt.Sprintf("Received: %s", w.Header().Get("Content-Length")))
+		}
+	}
+}
+
+func TestTools_DownloadOptions(t *testing.T) {
+	var testTool Tools
+
+	tests := []struct {
+		name        string
+		file        string
+		opts        DownloadOptions
+		contentType string
+		disposition string
+	}{
+		{name: "Attachment by default", file: "red.jpg", contentType: "image/jpeg", disposition: `attachment; filename="download"`},
+		{name: "Inline image", file: "red.jpg", opts: DownloadOptions{Inline: true}, contentType: "image/jpeg", disposition: `inline; filename="download"`},
+		{name: "Inline type not allowed", file: "red.jpg", opts: DownloadOptions{Inline: true, InlineTypes: []string{"application/pdf"}}, contentType: "image/jpeg", disposition: `attachment; filename="download"`},
+		{name: "HTML forced download", file: "page.html", opts: DownloadOptions{Inline: true, InlineTypes: []string{"text/html"}}, contentType: "text/html; charset=utf-8", disposition: `attachment; filename="download"`},
+		{name: "SVG detected and forced download", file: "logo", opts: DownloadOptions{Inline: true}, contentType: "image/svg+xml", disposition: `attachment; filename="download"`},
+		{name: "Content type override", file: "red.jpg", opts: DownloadOptions{Inline: true, ContentType: "application/octet-stream"}, contentType: "application/octet-stream", disposition: `attachment; filename="download"`},
+	}
+
+	fsys := fstest.MapFS{
+		"red.jpg":   &fstest.MapFile{Data: []byte("\xff\xd8\xff")},
+		"page.html": &fstest.MapFile{Data: []byte("<html></html>")},
+		"logo":      &fstest.MapFile{Data: []byte("<svg><script>alert(1)</script></svg>")},
+	}
+
+	for _, e := range tests {
+		w := httptest.NewRecorder()
+		testTool.DownloadFS(w, httptest.NewRequest("GET", "/", nil), fsys, e.file, "download", e.opts)
+
+		if ct := w.Header().Get("Content-Type"); ct != e.contentType {
+			printErr(t, e.name, "Wrong content type", fmt.Sprintf("Expected: %s", e.contentType), fmt.Sprintf("Received: %s", ct))
+		}
+		if cd := w.Header().Get("Content-Disposition"); cd != e.disposition {
+			printErr(t, e.name, "Wrong content disposition", fmt.Sprintf("Expected: %s", e.disposition), fmt.Sprintf("Received: %s", cd))
+		}
+		if w.Header().Get("X-Content-Type-Options") != "nosniff" {
+			printErr(t, e.name, "Missing nosniff header")
+		}
+	}
+
+	// the options also apply to static files
+	w := httptest.NewRecorder()
+	testTool.DownloadStaticFile(w, httptest.NewRequest("GET", "/", nil), "./testdata", "img.png", "img.png", DownloadOptions{Inline: true})
+	if cd := w.Header().Get("Content-Disposition"); cd != `inline; filename="img.png"` {
+		printErr(t, "Inline static file", "Wrong content disposition", fmt.Sprintf("Received: %s", cd))
+	}
+}
@@ -0,0 +1,173 @@
+package webmod
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// ZipEntry is a file added to a zip archive by DownloadZip. The file is
+// read from Dir and File on the OS filesystem, like DownloadStaticFile, or
+// from Open if it is set, which allows any storage backend to be used.
+// File is always within Dir, "../" cannot be used to leave it.
+// Name is the name of the file in the archive and defaults to File.
+type ZipEntry struct {
+	Dir     string
+	File    string
+	Name    string
+	ModTime time.Time
+	Open    func() (io.ReadCloser, error)
+}
+
+// DownloadZip streams a zip archive containing entries to the client, as a
+// download named displayName. The archive is built while it is sent, no
+// temporary files are used, and building it stops if the client goes away.
+// Duplicate names in the archive are numbered, e.g. "report (1).pdf".
+//
+// If the first entry can't be opened an error response is sent and the error
+// is returned, as it is when the client goes away. Any later error panics
+// with http.ErrAbortHandler, which aborts the response, so that the client
+// sees a failed download instead of a truncated archive.
+func (t *Tools) DownloadZip(w http.ResponseWriter, r *http.Request, entries []ZipEntry, displayName string) error {
+	ctx := r.Context()
+	names := make(map[string]bool)
+
+	// only start the response once there is something to send
+	var zw *zip.Writer
+	start := func() {
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", contentDisposition("attachment", displayName))
+		w.WriteHeader(http.StatusOK)
+		zw = zip.NewWriter(t.throttle(w, r))
+	}
+	// a started response can no longer report errors
+	fail := func(err error) error {
+		if err != nil && zw != nil && ctx.Err() == nil {
+			panic(http.ErrAbortHandler)
+		}
+		return err
+	}
+
+	for _, e := range entries {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		rc, modTime, err := e.open()
+		if err != nil {
+			if zw == nil {
+				if errors.Is(err, os.ErrNotExist) {
+					t.ErrorJSON(w, errors.New("File not found"), http.StatusNotFound)
+				} else {
+					t.ErrorJSON(w, errors.New("Unable to read file"), http.StatusInternalServerError)
+				}
+			}
+			return fail(err)
+		}
+
+		if zw == nil {
+			start()
+		}
+
+		err = addZipEntry(ctx, zw, rc, zipEntryName(e.name(), names), modTime)
+		rc.Close()
+		if err != nil {
+			return fail(err)
+		}
+	}
+
+	if zw == nil {
+		start()
+	}
+	return fail(zw.Close())
+}
+
+// name returns the name of the entry in the archive
+func (e ZipEntry) name() string {
+	if e.Name != "" {
+		return e.Name
+	}
+	return path.Base(e.File)
+}
+
+// open opens the file of the entry and returns its modification time
+func (e ZipEntry) open() (io.ReadCloser, time.Time, error) {
+	if e.Open != nil {
+		rc, err := e.Open()
+		return rc, e.ModTime, err
+	}
+
+	// File may come from the user, so keep it inside Dir
+	f, err := os.Open(path.Join(e.Dir, path.Clean("/"+e.File)))
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, time.Time{}, err
+	}
+	if info.IsDir() {
+		f.Close()
+		return nil, time.Time{}, fmt.Errorf("%s is a directory", e.File)
+	}
+
+	modTime := e.ModTime
+	if modTime.IsZero() {
+		modTime = info.ModTime()
+	}
+	return f, modTime, nil
+}
+
+// zipEntryName makes a name safe to use in an archive, and unique
+// among the names already used.
+func zipEntryName(name string, used map[string]bool) string {
+	name = strings.TrimLeft(path.Clean("/"+strings.ReplaceAll(name, "\\", "/")), "/")
+	if name == "" {
+		name = "file"
+	}
+
+	unique := name
+	ext := path.Ext(name)
+	for i := 1; used[unique]; i++ {
+		unique = fmt.Sprintf("%s (%d)%s", strings.TrimSuffix(name, ext), i, ext)
+	}
+	used[unique] = true
+	return unique
+}
+
+// addZipEntry copies rc into a new file of the archive, stopping if ctx is done
+func addZipEntry(ctx context.Context, zw *zip.Writer, rc io.Reader, name string, modTime time.Time) error {
+	fh := &zip.FileHeader{Name: name, Method: zip.Deflate}
+	if !modTime.IsZero() {
+		fh.Modified = modTime
+	}
+
+	fw, err := zw.CreateHeader(fh)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(fw, &contextReader{ctx: ctx, r: rc})
+	return err
+}
+
+// contextReader is a reader that fails once its context is done
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (cr *contextReader) Read(p []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return cr.r.Read(p)
+}
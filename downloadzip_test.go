@@ -0,0 +1,117 @@
+package webmod
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTools_DownloadZip(t *testing.T) {
+	tname := "Download zip"
+	var testTool Tools
+
+	entries := []ZipEntry{
+		{Dir: "./testdata", File: "red.jpg", Name: "wall.jpg"},
+		{Dir: "./testdata", File: "img.png", Name: "wall.jpg"},
+		{Name: "docs/readme.txt", Open: func() (io.ReadCloser, error) {
+			return io.NopCloser(strings.NewReader("hello")), nil
+		}},
+	}
+
+	rec := httptest.NewRecorder()
+	err := testTool.DownloadZip(rec, httptest.NewRequest(http.MethodGet, "/", nil), entries, "files.zip")
+	if err != nil {
+		printErr(t, tname, err.Error())
+	}
+
+	if cd := rec.Header().Get("Content-Disposition"); cd != `attachment; filename="files.zip"` {
+		printErr(t, tname, "Wrong content disposition", fmt.Sprintf("Received: %s", cd))
+	}
+
+	body := rec.Body.Bytes()
+	zr, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		printErr(t, tname, "Invalid zip archive", err.Error())
+		return
+	}
+
+	expected := []string{"wall.jpg", "wall (1).jpg", "docs/readme.txt"}
+	if len(zr.File) != len(expected) {
+		printErr(t, tname, "Wrong number of entries", fmt.Sprintf("Received: %d", len(zr.File)))
+		return
+	}
+	for i, f := range zr.File {
+		if f.Name != expected[i] {
+			printErr(t, tname, "Wrong entry name", fmt.Sprintf("Expected: %s", expected[i]), fmt.Sprintf("Received: %s", f.Name))
+		}
+	}
+	if zr.File[0].UncompressedSize64 != 1107051 {
+		printErr(t, tname, "Wrong entry size", fmt.Sprintf("Received: %d", zr.File[0].UncompressedSize64))
+	}
+}
+
+func TestTools_DownloadZipErrors(t *testing.T) {
+	var testTool Tools
+
+	// a missing first file is reported as not found
+	rec := httptest.NewRecorder()
+	err := testTool.DownloadZip(rec, httptest.NewRequest(http.MethodGet, "/", nil), []ZipEntry{{Dir: "./testdata", File: "missing.jpg"}}, "files.zip")
+	if err == nil || rec.Code != http.StatusNotFound {
+		printErr(t, "Missing file", "Expected not found", fmt.Sprintf("Received: %d %v", rec.Code, err))
+	}
+
+	// files cannot be read from outside of Dir
+	for _, file := range []string{"../downloadzip.go", "img.png/../../downloadzip.go", "/../downloadzip.go"} {
+		rec = httptest.NewRecorder()
+		err = testTool.DownloadZip(rec, httptest.NewRequest(http.MethodGet, "/", nil), []ZipEntry{{Dir: "./testdata", File: file}}, "files.zip")
+		if err == nil || rec.Code != http.StatusNotFound {
+			printErr(t, "Path traversal "+file, "Expected not found", fmt.Sprintf("Received: %d %v", rec.Code, err))
+		}
+	}
+
+	// a gone client stops the download
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	rec = httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx)
+	err = testTool.DownloadZip(rec, r, []ZipEntry{{Dir: "./testdata", File: "red.jpg"}}, "files.zip")
+	if err != context.Canceled {
+		printErr(t, "Client gone", "Expected cancellation", fmt.Sprintf("Received: %v", err))
+	}
+}
+
+func TestTools_DownloadZipLaterError(t *testing.T) {
+	var testTool Tools
+	entries := []ZipEntry{{Dir: "./testdata", File: "img.png"}, {Dir: "./testdata", File: "missing.jpg"}}
+
+	// the response is aborted once started
+	var panicked interface{}
+	func() {
+		defer func() { panicked = recover() }()
+		testTool.DownloadZip(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil), entries, "files.zip")
+	}()
+	if panicked != http.ErrAbortHandler {
+		printErr(t, "Aborted", "Wrong panic", fmt.Sprintf("Received: %v", panicked))
+	}
+
+	// so that clients see a failed download
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		testTool.DownloadZip(w, r, entries, "files.zip")
+	}))
+	defer server.Close()
+	res, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	_, err = io.ReadAll(res.Body)
+	if err == nil {
+		printErr(t, "Client", "Expected a failed download")
+	}
+}
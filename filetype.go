@@ -0,0 +1,325 @@
+package webmod
+
+import (
+	"bytes"
+	"encoding/binary"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+)
+
+// sniffLen is the number of bytes at the start of a file
+// used to detect its content type.
+const sniffLen = 3072
+
+// fileTypeExtensions maps content types to their file extensions,
+// the first extension of each type is the one given to renamed files.
+var fileTypeExtensions = map[string][]string{
+	"image/png":                     {".png"},
+	"image/jpeg":                    {".jpg", ".jpeg", ".jpe"},
+	"image/gif":                     {".gif"},
+	"image/webp":                    {".webp"},
+	"image/bmp":                     {".bmp"},
+	"image/x-icon":                  {".ico"},
+	"image/heic":                    {".heic"},
+	"image/heif":                    {".heif"},
+	"image/avif":                    {".avif"},
+	"image/svg+xml":                 {".svg"},
+	"video/mp4":                     {".mp4", ".m4v"},
+	"video/quicktime":               {".mov", ".qt"},
+	"video/3gpp":                    {".3gp"},
+	"video/3gpp2":                   {".3g2"},
+	"video/webm":                    {".webm"},
+	"video/avi":                     {".avi"},
+	"audio/mp4":                     {".m4a", ".m4b"},
+	"audio/mpeg":                    {".mp3"},
+	"audio/wave":                    {".wav"},
+	"audio/aiff":                    {".aiff", ".aif"},
+	"audio/basic":                   {".au", ".snd"},
+	"audio/midi":                    {".mid", ".midi"},
+	"application/ogg":               {".ogg", ".oga", ".ogv"},
+	"application/pdf":               {".pdf"},
+	"application/postscript":        {".ps", ".eps"},
+	"application/zip":               {".zip"},
+	"application/x-gzip":            {".gz", ".tgz"},
+	"application/x-rar-compressed":  {".rar"},
+	"application/wasm":              {".wasm"},
+	"application/epub+zip":          {".epub"},
+	"application/vnd.ms-fontobject": {".eot"},
+	"font/ttf":                      {".ttf"},
+	"font/otf":                      {".otf"},
+	"font/collection":               {".ttc"},
+	"font/woff":                     {".woff"},
+	"font/woff2":                    {".woff2"},
+	"text/html":                     {".html", ".htm"},
+	"text/xml":                      {".xml"},
+	"text/plain":                    {".txt", ".text", ".csv", ".tsv", ".md", ".log", ".json", ".yaml", ".yml", ".ini"},
+	"application/vnd.microsoft.portable-executable":                             {".exe", ".dll"},
+	"application/vnd.openxmlformats-officedocument.wordprocessingml.document":   {".docx"},
+	"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet":         {".xlsx"},
+	"application/vnd.openxmlformats-officedocument.presentationml.presentation": {".pptx"},
+	"application/vnd.oasis.opendocument.text":                                   {".odt"},
+	"application/vnd.oasis.opendocument.spreadsheet":                            {".ods"},
+	"application/vnd.oasis.opendocument.presentation":                           {".odp"},
+}
+
+// extensionFileTypes is the reverse of fileTypeExtensions
+var extensionFileTypes = func() map[string]string {
+	m := make(map[string]string)
+	for ctype, exts := range fileTypeExtensions {
+		for _, ext := range exts {
+			m[ext] = ctype
+		}
+	}
+	return m
+}()
+
+// fileSniffers are tried in order before falling back to http.DetectContentType,
+// each returns an empty string if it does not recognise the data.
+var fileSniffers = []func(data []byte) string{
+	sniffFtyp,
+	sniffZipContainer,
+	sniffWebP,
+	sniffExecutable,
+	sniffSVG,
+}
+
+// DetectContentType detects the content type of a file from its first bytes.
+// It knows more types than http.DetectContentType, such as office documents,
+// HEIC/AVIF images, MP4 variants and SVG, and falls back to it otherwise.
+// At most the first 3072 bytes of data are considered.
+func (t *Tools) DetectContentType(data []byte) string {
+	if len(data) > sniffLen {
+		data = data[:sniffLen]
+	}
+
+	for _, sniff := range fileSniffers {
+		if ctype := sniff(data); ctype != "" {
+			return ctype
+		}
+	}
+
+	return http.DetectContentType(data)
+}
+
+// ExtensionForType returns the file extension, including the leading dot,
+// for a content type such as one returned by DetectContentType.
+// It returns an empty string if the type is unknown.
+func (t *Tools) ExtensionForType(ctype string) string {
+	if exts := fileTypeExtensions[baseMediaType(ctype)]; len(exts) > 0 {
+		return exts[0]
+	}
+	return ""
+}
+
+// ExtensionMatchesType reports whether the extension of filename is
+// consistent with the content type ctype. A file of a known type must
+// use one of its extensions, a file of an unknown type must not use an
+// extension belonging to a known type.
+func (t *Tools) ExtensionMatchesType(filename, ctype string) bool {
+	ext := strings.ToLower(filepath.Ext(filename))
+	base := baseMediaType(ctype)
+
+	if exts, ok := fileTypeExtensions[base]; ok {
+		for _, e := range exts {
+			if e == ext {
+				return true
+			}
+		}
+		return false
+	}
+
+	_, known := extensionFileTypes[ext]
+	return !known
+}
+
+// baseMediaType strips the parameters from a content type,
+// e.g. "text/plain; charset=utf-8" becomes "text/plain".
+func baseMediaType(ctype string) string {
+	if mediatype, _, err := mime.ParseMediaType(ctype); err == nil {
+		return mediatype
+	}
+	return strings.ToLower(strings.TrimSpace(strings.Split(ctype, ";")[0]))
+}
+
+// fileTypeAllowed reports whether ctype matches one of the allowed
+// types, either exactly or by its media type without parameters.
+// An empty list allows every type.
+func fileTypeAllowed(ctype string, allowedTypes []string) bool {
+	if len(allowedTypes) == 0 {
+		return true
+	}
+
+	base := baseMediaType(ctype)
+	for _, aType := range allowedTypes {
+		if strings.EqualFold(ctype, aType) || strings.EqualFold(base, aType) {
+			return true
+		}
+	}
+	return false
+}
+
+// sniffFtyp detects the ISO base media formats (mp4, mov, 3gp, heic, avif)
+// from the brands in the leading "ftyp" box.
+func sniffFtyp(data []byte) string {
+	if len(data) < 12 || string(data[4:8]) != "ftyp" {
+		return ""
+	}
+
+	size := int(binary.BigEndian.Uint32(data[:4]))
+	if size < 12 || size > len(data) {
+		size = len(data)
+	}
+	brands := []string{string(data[8:12])}
+	for i := 16; i+4 <= size; i += 4 {
+		brands = append(brands, string(data[i:i+4]))
+	}
+
+	// the major brand decides, then the compatible brands
+	for _, brand := range brands {
+		switch {
+		case brand == "heic" || brand == "heix" || brand == "hevc" || brand == "hevx" || brand == "heim" || brand == "heis":
+			return "image/heic"
+		case brand == "avif" || brand == "avis":
+			return "image/avif"
+		case brand == "mif1" || brand == "msf1" || brand == "heif":
+			// generic HEIF, may also list a more specific brand
+			for _, b := range brands {
+				switch b {
+				case "heic", "heix":
+					return "image/heic"
+				case "avif":
+					return "image/avif"
+				}
+			}
+			return "image/heif"
+		case brand == "qt  ":
+			return "video/quicktime"
+		case brand == "M4A " || brand == "M4B ":
+			return "audio/mp4"
+		case strings.HasPrefix(brand, "3gp"):
+			return "video/3gpp"
+		case strings.HasPrefix(brand, "3g2"):
+			return "video/3gpp2"
+		case strings.HasPrefix(brand, "iso") || strings.HasPrefix(brand, "mp4") ||
+			strings.HasPrefix(brand, "M4V") || brand == "avc1" || brand == "dash" ||
+			brand == "mmp4" || brand == "MSNV" || brand == "F4V ":
+			return "video/mp4"
+		}
+	}
+
+	return ""
+}
+
+// sniffZipContainer detects zip based document formats, office open xml
+// documents by the names of their entries, and open document and epub
+// files by their leading "mimetype" entry.
+func sniffZipContainer(data []byte) string {
+	sig := []byte("PK\x03\x04")
+	if !bytes.HasPrefix(data, sig) {
+		return ""
+	}
+
+	for i := 0; i+30 <= len(data); {
+		j := bytes.Index(data[i:], sig)
+		if j < 0 {
+			break
+		}
+		i += j
+
+		if i+30 > len(data) {
+			break
+		}
+		nameLen := int(binary.LittleEndian.Uint16(data[i+26:]))
+		extraLen := int(binary.LittleEndian.Uint16(data[i+28:]))
+		if i+30+nameLen > len(data) {
+			break
+		}
+		name := string(data[i+30 : i+30+nameLen])
+
+		switch {
+		case strings.HasPrefix(name, "word/"):
+			return "application/vnd.openxmlformats-officedocument.wordprocessingml.document"
+		case strings.HasPrefix(name, "xl/"):
+			return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+		case strings.HasPrefix(name, "ppt/"):
+			return "application/vnd.openxmlformats-officedocument.presentationml.presentation"
+		case name == "mimetype" && i == 0:
+			// the content of the "mimetype" entry is stored uncompressed
+			size := int(binary.LittleEndian.Uint32(data[18:]))
+			start := 30 + nameLen + extraLen
+			if size > 0 && start+size <= len(data) {
+				ctype := string(data[start : start+size])
+				if _, ok := fileTypeExtensions[ctype]; ok {
+					return ctype
+				}
+			}
+		}
+
+		i += 4
+	}
+
+	return ""
+}
+
+// sniffWebP detects WebP images
+func sniffWebP(data []byte) string {
+	if len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WEBP" {
+		return "image/webp"
+	}
+	return ""
+}
+
+// sniffExecutable detects windows executables
+func sniffExecutable(data []byte) string {
+	if len(data) < 64 || string(data[:2]) != "MZ" {
+		return ""
+	}
+	// the DOS header points to the PE header, which must be within the
+	// sniffed data, compared as uint32 so that large offsets cannot
+	// overflow an int
+	offset := binary.LittleEndian.Uint32(data[60:])
+	if offset > uint32(len(data)-4) || string(data[offset:offset+4]) != "PE\x00\x00" {
+		return ""
+	}
+	return "application/vnd.microsoft.portable-executable"
+}
+
+// sniffSVG detects SVG images, skipping any leading xml declaration,
+// comments and doctype before looking for the <svg> root element.
+func sniffSVG(data []byte) string {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	for {
+		data = bytes.TrimLeft(data, " \t\r\n")
+		switch {
+		case bytes.HasPrefix(data, []byte("<?")):
+			data = skipPast(data, "?>")
+		case bytes.HasPrefix(data, []byte("<!--")):
+			data = skipPast(data, "-->")
+		case bytes.HasPrefix(data, []byte("<!")):
+			data = skipPast(data, ">")
+		default:
+			if len(data) > 4 && strings.EqualFold(string(data[:4]), "<svg") {
+				switch data[4] {
+				case ' ', '\t', '\r', '\n', '>', '/':
+					return "image/svg+xml"
+				}
+			}
+			return ""
+		}
+		if data == nil {
+			return ""
+		}
+	}
+}
+
+// skipPast returns data after the first occurrence of end,
+// or nil if end is not found.
+func skipPast(data []byte, end string) []byte {
+	i := bytes.Index(data, []byte(end))
+	if i < 0 {
+		return nil
+	}
+	return data[i+len(end):]
+}
@@ -0,0 +1,120 @@
+package webmod
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+// ftyp builds the start of an ISO base media file with the given brands
+func ftyp(major string, compatible ...string) []byte {
+	box := []byte{0, 0, 0, byte(16 + 4*len(compatible))}
+	box = append(box, "ftyp"+major+"\x00\x00\x00\x00"...)
+	for _, c := range compatible {
+		box = append(box, c...)
+	}
+	return box
+}
+
+// exe builds the start of a windows executable, whose DOS header points
+// to a PE header at offset
+func exe(offset uint32) []byte {
+	data := make([]byte, 128)
+	copy(data, "MZ")
+	binary.LittleEndian.PutUint32(data[60:], offset)
+	copy(data[64:], "PE\x00\x00")
+	return data
+}
+
+// zipWith builds a zip archive containing empty entries with the given names
+func zipWith(t *testing.T, names ...string) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, name := range names {
+		if _, err := zw.Create(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	zw.Close()
+	return buf.Bytes()
+}
+
+func TestTools_DetectContentType(t *testing.T) {
+	var testTool Tools
+
+	png, err := os.ReadFile("./testdata/img.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		data     []byte
+		expected string
+	}{
+		{name: "PNG", data: png, expected: "image/png"},
+		{name: "WebP", data: []byte("RIFF\x00\x00\x00\x00WEBPVP8 "), expected: "image/webp"},
+		{name: "HEIC", data: ftyp("heic", "mif1", "heic"), expected: "image/heic"},
+		{name: "HEIF with HEIC brand", data: ftyp("mif1", "mif1", "heic"), expected: "image/heic"},
+		{name: "AVIF", data: ftyp("avif", "mif1"), expected: "image/avif"},
+		{name: "MP4", data: ftyp("isom", "isom", "avc1"), expected: "video/mp4"},
+		{name: "M4A", data: ftyp("M4A ", "isom"), expected: "audio/mp4"},
+		{name: "QuickTime", data: ftyp("qt  ", "qt  "), expected: "video/quicktime"},
+		{name: "3GP", data: ftyp("3gp5", "isom"), expected: "video/3gpp"},
+		{name: "DOCX", data: zipWith(t, "[Content_Types].xml", "_rels/.rels", "word/document.xml"), expected: "application/vnd.openxmlformats-officedocument.wordprocessingml.document"},
+		{name: "XLSX", data: zipWith(t, "[Content_Types].xml", "xl/workbook.xml"), expected: "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"},
+		{name: "Plain zip", data: zipWith(t, "foo.txt"), expected: "application/zip"},
+		{name: "SVG", data: []byte(`<svg xmlns="http://www.w3.org/2000/svg"></svg>`), expected: "image/svg+xml"},
+		{name: "SVG with prolog", data: []byte("<?xml version=\"1.0\"?>\n<!-- logo -->\n<!DOCTYPE svg>\n<svg>"), expected: "image/svg+xml"},
+		{name: "XML", data: []byte(`<?xml version="1.0"?><note></note>`), expected: "text/xml; charset=utf-8"},
+		{name: "HTML", data: []byte(`<html><body></body></html>`), expected: "text/html; charset=utf-8"},
+		{name: "Text", data: []byte("hello world"), expected: "text/plain; charset=utf-8"},
+		{name: "Executable", data: exe(64), expected: "application/vnd.microsoft.portable-executable"},
+		{name: "MZ text without PE header", data: []byte("MZ" + strings.Repeat("not an executable ", 10)), expected: "text/plain; charset=utf-8"},
+		{name: "MZ with PE offset past data", data: exe(4096), expected: "application/octet-stream"},
+		{name: "MZ with PE offset above 2^31", data: exe(0x80000000), expected: "application/octet-stream"},
+		{name: "MZ with largest PE offset", data: exe(0xffffffff), expected: "application/octet-stream"},
+	}
+
+	for _, e := range tests {
+		ctype := testTool.DetectContentType(e.data)
+		if ctype != e.expected {
+			expected := fmt.Sprintf("Expected: %s", e.expected)
+			received := fmt.Sprintf("Received: %s", ctype)
+			printErr(t, e.name, "Wrong content type detected", expected, received)
+		}
+	}
+}
+
+func TestTools_ExtensionMatchesType(t *testing.T) {
+	var testTool Tools
+
+	tests := []struct {
+		name     string
+		filename string
+		ctype    string
+		expected bool
+	}{
+		{name: "Matching extension", filename: "img.png", ctype: "image/png", expected: true},
+		{name: "Alternative extension", filename: "img.JPEG", ctype: "image/jpeg", expected: true},
+		{name: "PNG named exe", filename: "img.exe", ctype: "image/png", expected: false},
+		{name: "PNG named html", filename: "evil.html", ctype: "image/png", expected: false},
+		{name: "Unknown type, unknown extension", filename: "data.dat", ctype: "application/octet-stream", expected: true},
+		{name: "Unknown type, known extension", filename: "data.png", ctype: "application/octet-stream", expected: false},
+		{name: "Type with parameters", filename: "notes.txt", ctype: "text/plain; charset=utf-8", expected: true},
+	}
+
+	for _, e := range tests {
+		if testTool.ExtensionMatchesType(e.filename, e.ctype) != e.expected {
+			printErr(t, e.name, fmt.Sprintf("Expected match for %s as %s to be %t", e.filename, e.ctype, e.expected))
+		}
+	}
+
+	if ext := testTool.ExtensionForType("image/jpeg"); ext != ".jpg" {
+		printErr(t, "Extension for type", "Wrong extension", "Expected: .jpg", fmt.Sprintf("Received: %s", ext))
+	}
+}
@@ -0,0 +1,238 @@
+package webmod
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Errors returned when parsing IDs
+var (
+	ErrInvalidUUID = errors.New("Invalid UUID")
+	ErrInvalidULID = errors.New("Invalid ULID")
+)
+
+// UUID is a RFC 9562 universally unique identifier
+type UUID [16]byte
+
+// ULID is a universally unique lexicographically sortable identifier
+type ULID [16]byte
+
+// crockfordBase32 is the alphabet used to encode ULIDs
+const crockfordBase32 = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// idState keeps the last time based IDs generated, so that IDs generated
+// within the same millisecond are still in order. It is shared by all Tools.
+var idState struct {
+	mu       sync.Mutex
+	uuidMs   uint64
+	uuidRand [10]byte
+	ulidMs   uint64
+	ulidRand [10]byte
+}
+
+// NewUUIDv4 returns a random (version 4) UUID
+func (t *Tools) NewUUIDv4() (UUID, error) {
+	var u UUID
+	_, err := rand.Read(u[:])
+	if err != nil {
+		return UUID{}, err
+	}
+
+	u[6] = (u[6] & 0x0f) | 0x40 // version 4
+	u[8] = (u[8] & 0x3f) | 0x80 // RFC 9562 variant
+	return u, nil
+}
+
+// NewUUIDv7 returns a time ordered (version 7) UUID, made of a millisecond
+// timestamp and random bits. UUIDs generated within the same millisecond are
+// still increasing, as the random bits of the previous one are incremented.
+func (t *Tools) NewUUIDv7() (UUID, error) {
+	idState.mu.Lock()
+	defer idState.mu.Unlock()
+
+	ms, random, err := nextMonotonic(&idState.uuidMs, &idState.uuidRand, 74)
+	if err != nil {
+		return UUID{}, err
+	}
+
+	// 48 bits timestamp, 4 bits version, 12 bits random,
+	// 2 bits variant and 62 bits random
+	randA := (uint64(binary.BigEndian.Uint16(random[:2]))<<2 | uint64(random[2]>>6)) & 0xfff
+	randB := binary.BigEndian.Uint64(random[2:]) & (1<<62 - 1)
+
+	var u UUID
+	binary.BigEndian.PutUint64(u[:8], ms<<16|0x7000|randA)
+	binary.BigEndian.PutUint64(u[8:], 1<<63|randB)
+	return u, nil
+}
+
+// NewULID returns a ULID, made of a millisecond timestamp and random bits.
+// ULIDs generated within the same millisecond are still increasing, as the
+// random bits of the previous one are incremented.
+func (t *Tools) NewULID() (ULID, error) {
+	idState.mu.Lock()
+	defer idState.mu.Unlock()
+
+	ms, random, err := nextMonotonic(&idState.ulidMs, &idState.ulidRand, 80)
+	if err != nil {
+		return ULID{}, err
+	}
+
+	var u ULID
+	binary.BigEndian.PutUint64(u[:8], ms<<16)
+	copy(u[6:], random[:])
+	return u, nil
+}
+
+// nextMonotonic returns the timestamp and random bits of the next time based
+// ID, given those of the last one. Only the low bits of random are used, if
+// the timestamp has not moved on they are incremented instead of drawn again,
+// overflowing into the timestamp.
+func nextMonotonic(lastMs *uint64, lastRand *[10]byte, bits int) (uint64, [10]byte, error) {
+	ms := uint64(time.Now().UnixMilli())
+	random := *lastRand
+
+	if ms > *lastMs {
+		_, err := rand.Read(random[:])
+		if err != nil {
+			return 0, random, err
+		}
+		// keep the top bit clear, so that there is room to increment
+		random[(80-bits)/8] &= 0xff >> (1 + (80-bits)%8)
+	} else {
+		// the clock has not moved on, or went back
+		ms = *lastMs
+		carry := true
+		for i := len(random) - 1; i >= 0 && carry; i-- {
+			random[i]++
+			carry = random[i] == 0
+		}
+		if carry || random[(80-bits)/8]>>(8-(80-bits)%8) != 0 {
+			ms++
+			_, err := rand.Read(random[:])
+			if err != nil {
+				return 0, random, err
+			}
+			random[(80-bits)/8] &= 0xff >> (1 + (80-bits)%8)
+		}
+	}
+	*lastMs, *lastRand = ms, random
+	return ms, random, nil
+}
+
+// ParseUUID parses a UUID in its canonical form, e.g.
+// "f81d4fae-7dec-11d0-a765-00a0c91e6bf6", optionally prefixed by "urn:uuid:".
+func (t *Tools) ParseUUID(s string) (UUID, error) {
+	s = strings.TrimPrefix(strings.ToLower(s), "urn:uuid:")
+	if len(s) != 36 || s[8] != '-' || s[13] != '-' || s[18] != '-' || s[23] != '-' {
+		return UUID{}, ErrInvalidUUID
+	}
+
+	var u UUID
+	_, err := hex.Decode(u[:], []byte(s[0:8]+s[9:13]+s[14:18]+s[19:23]+s[24:]))
+	if err != nil {
+		return UUID{}, ErrInvalidUUID
+	}
+	return u, nil
+}
+
+// IsValidUUID reports whether s is a UUID of the RFC 9562 variant,
+// with a version between 1 and 8.
+func (t *Tools) IsValidUUID(s string) bool {
+	u, err := t.ParseUUID(s)
+	if err != nil {
+		return false
+	}
+	return u[8]&0xc0 == 0x80 && u.Version() >= 1 && u.Version() <= 8
+}
+
+// String returns the canonical form of the UUID
+func (u UUID) String() string {
+	var buf [36]byte
+	hex.Encode(buf[0:8], u[0:4])
+	buf[8] = '-'
+	hex.Encode(buf[9:13], u[4:6])
+	buf[13] = '-'
+	hex.Encode(buf[14:18], u[6:8])
+	buf[18] = '-'
+	hex.Encode(buf[19:23], u[8:10])
+	buf[23] = '-'
+	hex.Encode(buf[24:], u[10:])
+	return string(buf[:])
+}
+
+// Version returns the version of the UUID
+func (u UUID) Version() int {
+	return int(u[6] >> 4)
+}
+
+// Time returns the time a version 7 UUID was generated,
+// and the zero time for other versions.
+func (u UUID) Time() time.Time {
+	if u.Version() != 7 {
+		return time.Time{}
+	}
+	return time.UnixMilli(int64(binary.BigEndian.Uint64(u[:8]) >> 16))
+}
+
+// ParseULID parses a ULID in its 26 character base32 form, ignoring case
+func (t *Tools) ParseULID(s string) (ULID, error) {
+	if len(s) != 26 {
+		return ULID{}, ErrInvalidULID
+	}
+
+	// 26 characters of 5 bits are 130 bits, the first
+	// character holds only the top 3 bits of the ULID
+	var hi, lo uint64
+	for i := 0; i < len(s); i++ {
+		v := strings.IndexByte(crockfordBase32, upper(s[i]))
+		if v < 0 || (i == 0 && v > 7) {
+			return ULID{}, ErrInvalidULID
+		}
+		hi = hi<<5 | lo>>59
+		lo = lo<<5 | uint64(v)
+	}
+
+	var u ULID
+	binary.BigEndian.PutUint64(u[:8], hi)
+	binary.BigEndian.PutUint64(u[8:], lo)
+	return u, nil
+}
+
+// IsValidULID reports whether s is a ULID
+func (t *Tools) IsValidULID(s string) bool {
+	_, err := t.ParseULID(s)
+	return err == nil
+}
+
+// String returns the 26 character base32 form of the ULID
+func (u ULID) String() string {
+	hi := binary.BigEndian.Uint64(u[:8])
+	lo := binary.BigEndian.Uint64(u[8:])
+
+	var buf [26]byte
+	for i := len(buf) - 1; i >= 0; i-- {
+		buf[i] = crockfordBase32[lo&0x1f]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(buf[:])
+}
+
+// Time returns the time the ULID was generated
+func (u ULID) Time() time.Time {
+	return time.UnixMilli(int64(binary.BigEndian.Uint64(u[:8]) >> 16))
+}
+
+// upper returns the upper case of an ASCII letter
+func upper(c byte) byte {
+	if 'a' <= c && c <= 'z' {
+		return c - 'a' + 'A'
+	}
+	return c
+}
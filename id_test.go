@@ -0,0 +1,137 @@
+package webmod
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTools_NewUUID(t *testing.T) {
+	var testTool Tools
+
+	v4, err := testTool.NewUUIDv4()
+	if err != nil {
+		printErr(t, "UUIDv4", err.Error())
+	}
+	if v4.Version() != 4 || !testTool.IsValidUUID(v4.String()) {
+		printErr(t, "UUIDv4", "Invalid UUID generated", fmt.Sprintf("Received: %s", v4))
+	}
+
+	before := time.Now().Truncate(time.Millisecond)
+	v7, err := testTool.NewUUIDv7()
+	if err != nil {
+		printErr(t, "UUIDv7", err.Error())
+	}
+	if v7.Version() != 7 || !testTool.IsValidUUID(v7.String()) {
+		printErr(t, "UUIDv7", "Invalid UUID generated", fmt.Sprintf("Received: %s", v7))
+	}
+	if v7.Time().Before(before) || v7.Time().After(time.Now()) {
+		printErr(t, "UUIDv7", "Wrong timestamp", fmt.Sprintf("Received: %s", v7.Time()))
+	}
+
+	// parsing returns the same UUID
+	parsed, err := testTool.ParseUUID("urn:uuid:" + v7.String())
+	if err != nil || parsed != v7 {
+		printErr(t, "Parse UUID", "Wrong UUID parsed", fmt.Sprintf("Expected: %s", v7), fmt.Sprintf("Received: %s", parsed))
+	}
+
+	invalid := []string{
+		"",
+		"f81d4fae7dec11d0a76500a0c91e6bf6",
+		"f81d4fae-7dec-11d0-a765-00a0c91e6bfg",
+		"f81d4fae-7dec-01d0-a765-00a0c91e6bf6",
+		"f81d4fae-7dec-11d0-c765-00a0c91e6bf6",
+	}
+	for _, s := range invalid {
+		if testTool.IsValidUUID(s) {
+			printErr(t, "Validate UUID", fmt.Sprintf("Invalid UUID %q accepted", s))
+		}
+	}
+}
+
+func TestTools_NewULID(t *testing.T) {
+	var testTool Tools
+
+	u, err := testTool.NewULID()
+	if err != nil {
+		printErr(t, "ULID", err.Error())
+	}
+	s := u.String()
+	if len(s) != 26 || !testTool.IsValidULID(s) {
+		printErr(t, "ULID", "Invalid ULID generated", fmt.Sprintf("Received: %s", s))
+	}
+
+	parsed, err := testTool.ParseULID("01ARZ3NDEKTSV4RRFFQ69G5FAV")
+	if err != nil || parsed.String() != "01ARZ3NDEKTSV4RRFFQ69G5FAV" || parsed.Time().UnixMilli() != 1469922850259 {
+		printErr(t, "Parse ULID", "Wrong ULID parsed", fmt.Sprintf("Received: %s %v", parsed, err))
+	}
+	lower, err := testTool.ParseULID("01arz3ndektsv4rrffq69g5fav")
+	if err != nil || lower != parsed {
+		printErr(t, "Parse lower case ULID", "Wrong ULID parsed")
+	}
+
+	for _, s := range []string{"", "01ARZ3NDEKTSV4RRFFQ69G5FA", "81ARZ3NDEKTSV4RRFFQ69G5FAV", "01ARZ3NDEKTSV4RRFFQ69G5FAU"} {
+		if testTool.IsValidULID(s) {
+			printErr(t, "Validate ULID", fmt.Sprintf("Invalid ULID %q accepted", s))
+		}
+	}
+}
+
+func TestTools_MonotonicIDs(t *testing.T) {
+	var testTool Tools
+
+	// IDs generated concurrently are unique, and increasing
+	// when generated one after the other
+	var mu sync.Mutex
+	seen := make(map[string]bool)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var lastUUID, lastULID string
+			for j := 0; j < 1000; j++ {
+				v7, _ := testTool.NewUUIDv7()
+				u, _ := testTool.NewULID()
+				if v7.String() <= lastUUID || u.String() <= lastULID {
+					printErr(t, "Monotonic IDs", "IDs not increasing")
+					return
+				}
+				lastUUID, lastULID = v7.String(), u.String()
+
+				mu.Lock()
+				if seen[lastUUID] || seen[lastULID] {
+					printErr(t, "Monotonic IDs", "Duplicate ID generated")
+				}
+				seen[lastUUID], seen[lastULID] = true, true
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestNextMonotonic(t *testing.T) {
+	future := uint64(time.Now().Add(time.Hour).UnixMilli())
+
+	// the random bits are incremented while the clock is behind
+	last := [10]byte{9: 1}
+	ms, random, err := nextMonotonic(&future, &last, 80)
+	if err != nil || ms != future || random != [10]byte{9: 2} {
+		printErr(t, "Increment", "Random bits not incremented", fmt.Sprintf("Received: %d %v", ms, random))
+	}
+
+	// and overflow into the timestamp
+	for _, bits := range []int{74, 80} {
+		lastMs := future
+		full := [10]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+		if bits == 74 {
+			full[0] = 0x03
+		}
+		ms, _, err = nextMonotonic(&lastMs, &full, bits)
+		if err != nil || ms != future+1 {
+			printErr(t, fmt.Sprintf("Overflow (%d bits)", bits), "Timestamp not incremented", fmt.Sprintf("Received: %d", ms))
+		}
+	}
+}
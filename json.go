@@ -1,10 +1,13 @@
 package webmod
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
 	"strings"
 )
@@ -14,6 +17,8 @@ type JSONResponse struct {
 	Error   bool        `json:"error"`
 	Message string      `json:"message"`
 	Data    interface{} `json:"data,omitempty"`
+	// ID of the request answered with an error, set by RequestID
+	RequestID string `json:"request_id,omitempty"`
 }
 
 // ReadJson tries to read the body of a request and
@@ -120,7 +125,8 @@ func (t *Tools) WriteJSON(w http.ResponseWriter, status int, jdata interface{},
 }
 
 // ErrorJSON is a utility function to easily write errors to client in JSON format.
-// It optionally takes status code as an argument
+// It optionally takes status code as an argument, and includes the request ID
+// set by the RequestID middleware.
 func (t *Tools) ErrorJSON(w http.ResponseWriter, err error, status ...int) error {
 	statusCode := http.StatusBadRequest
 	if len(status) > 0 {
@@ -128,9 +134,59 @@ func (t *Tools) ErrorJSON(w http.ResponseWriter, err error, status ...int) error
 	}
 
 	jdata := JSONResponse{
-		Error:   true,
-		Message: err.Error(),
+		Error:     true,
+		Message:   err.Error(),
+		RequestID: w.Header().Get(t.requestIDHeader()),
 	}
 
 	return t.WriteJSON(w, statusCode, jdata)
 }
+
+// logError passes an error hidden from the client to ErrorLogger,
+// or logs it with the standard logger
+func (t *Tools) logError(r *http.Request, err error) {
+	if t.ErrorLogger != nil {
+		t.ErrorLogger(r, err)
+		return
+	}
+	id, _ := t.RequestIDFromContext(r.Context())
+	log.Printf("error serving %s %s (request %s): %v", r.Method, r.URL.Path, id, err)
+}
+
+// internalError answers r with a 500 error that does not reveal err,
+// which is logged by logError
+func (t *Tools) internalError(w http.ResponseWriter, r *http.Request, err error) {
+	t.logError(r, err)
+	t.ErrorJSON(w, ErrInternal, http.StatusInternalServerError)
+}
+
+// PushJSONToRemote posts data as JSON to uri, forwarding the request ID of
+// ctx set by the RequestID middleware. It optionally takes the client used
+// to send the request. The caller must close the body of the response.
+func (t *Tools) PushJSONToRemote(ctx context.Context, uri string, data interface{}, client ...*http.Client) (*http.Response, int, error) {
+	// encode data into json
+	out, err := json.Marshal(data)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	httpClient := http.DefaultClient
+	if len(client) > 0 && client[0] != nil {
+		httpClient = client[0]
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, uri, bytes.NewReader(out))
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if id, ok := t.RequestIDFromContext(ctx); ok {
+		req.Header.Set(t.requestIDHeader(), id)
+	}
+
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	return res, res.StatusCode, nil
+}
@@ -208,3 +208,47 @@ func TestTools_ErrorJSON(t *testing.T) {
 		printErr(t, tname, msg, expected, received)
 	}
 }
+
+func TestTools_PushJSONToRemote(t *testing.T) {
+	tname := "Push JSON to remote"
+	var testTool Tools
+
+	var received struct {
+		ContentType string
+		RequestID   string
+		Body        map[string]string
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received.ContentType = r.Header.Get("Content-Type")
+		received.RequestID = r.Header.Get("X-Request-ID")
+		json.NewDecoder(r.Body).Decode(&received.Body)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	// the request ID of the incoming request is forwarded
+	var res *http.Response
+	var status int
+	var err error
+	handler := testTool.RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		res, status, err = testTool.PushJSONToRemote(r.Context(), server.URL, map[string]string{"foo": "bar"}, server.Client())
+	}))
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("X-Request-ID", "abc-123")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	if err != nil {
+		printErr(t, tname, "Failed to push JSON", fmt.Sprintf("Error: %s", err.Error()))
+		return
+	}
+	res.Body.Close()
+	if status != http.StatusAccepted {
+		printErr(t, tname, "Wrong status code", fmt.Sprintf("Expected: %d", http.StatusAccepted), fmt.Sprintf("Received: %d", status))
+	}
+	if received.ContentType != "application/json" || received.Body["foo"] != "bar" {
+		printErr(t, tname, "Wrong JSON received", fmt.Sprintf("Received: %+v", received))
+	}
+	if received.RequestID != "abc-123" {
+		printErr(t, tname, "Request ID not forwarded", fmt.Sprintf("Received: %q", received.RequestID))
+	}
+}
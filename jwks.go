@@ -0,0 +1,264 @@
+package webmod
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrJWKSFetch is returned when a JWKS can not be fetched or parsed
+var ErrJWKSFetch = errors.New("Unable to fetch JWKS")
+
+// jwksMinRefresh is how long to wait after fetching a JWKS, or failing to,
+// before fetching it again because it is stale or because of a token signed
+// by an unknown key
+var jwksMinRefresh = time.Minute
+
+// jwksFetchTimeout is the longest time a fetch of a JWKS can take
+const jwksFetchTimeout = 10 * time.Second
+
+// JWKS is a JSON Web Key Set fetched from URL, with the keys used to verify
+// JWTs of another issuer. The keys are fetched again every RefreshInterval
+// (defaulting to an hour), and when a token is signed by an unknown key so
+// that keys can be rotated by the issuer. RS256 and EdDSA keys are supported.
+// Keys are fetched at most once a minute, even if fetching them fails, and
+// concurrent verifications share a single fetch.
+type JWKS struct {
+	URL             string
+	Client          *http.Client
+	RefreshInterval time.Duration
+
+	mu        sync.Mutex
+	keySet    []JWTKey
+	fetched   time.Time
+	attempted time.Time
+	lastErr   error
+	inflight  *jwksFetch
+}
+
+// jwksFetch is a fetch of a JWKS in progress, done is closed once it is over
+type jwksFetch struct {
+	done chan struct{}
+	err  error
+}
+
+// jwk is a JSON Web Key, as described in RFC 7517
+type jwk struct {
+	KeyType   string `json:"kty"`
+	Use       string `json:"use,omitempty"`
+	Algorithm string `json:"alg,omitempty"`
+	KeyID     string `json:"kid,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	X         string `json:"x,omitempty"`
+}
+
+// Refresh fetches the keys of the set, or waits for the fetch in progress
+func (k *JWKS) Refresh(ctx context.Context) error {
+	return k.refresh(ctx)
+}
+
+// refresh fetches the keys of the set, joining the fetch in progress if
+// there is one. Giving up when ctx is done leaves the fetch running for the
+// other callers.
+func (k *JWKS) refresh(ctx context.Context) error {
+	k.mu.Lock()
+	f := k.inflight
+	if f == nil {
+		f = &jwksFetch{done: make(chan struct{})}
+		k.inflight = f
+		go k.fetchShared(f)
+	}
+	k.mu.Unlock()
+
+	select {
+	case <-f.done:
+		return f.err
+	case <-ctx.Done():
+		return fmt.Errorf("%w: %s", ErrJWKSFetch, ctx.Err())
+	}
+}
+
+// fetchShared does the fetch f shared by the callers of refresh, without
+// holding k.mu. It does not depend on their contexts, so that it only
+// fails, and is only recorded as an attempt, when fetching fails.
+func (k *JWKS) fetchShared(f *jwksFetch) {
+	ctx, cancel := context.WithTimeout(context.Background(), jwksFetchTimeout)
+	defer cancel()
+	keys, err := k.fetch(ctx)
+
+	k.mu.Lock()
+	k.attempted, k.lastErr = time.Now(), err
+	if err == nil {
+		k.keySet, k.fetched = keys, k.attempted
+	}
+	k.inflight = nil
+	k.mu.Unlock()
+
+	f.err = err
+	close(f.done)
+}
+
+// fetch fetches and parses the keys of the set
+func (k *JWKS) fetch(ctx context.Context) ([]JWTKey, error) {
+	client := k.Client
+	if client == nil {
+		client = &http.Client{Timeout: jwksFetchTimeout}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, k.URL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrJWKSFetch, err)
+	}
+	req.Header.Set("Accept", "application/json")
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrJWKSFetch, err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: status %d", ErrJWKSFetch, res.StatusCode)
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	err = json.NewDecoder(io.LimitReader(res.Body, 1024*1024)).Decode(&set)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrJWKSFetch, err)
+	}
+
+	var keys []JWTKey
+	for _, j := range set.Keys {
+		key, ok := j.jwtKey()
+		if ok {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+// keys returns the keys with the ID kid, or all keys if kid is empty,
+// fetching the keys first if they are stale or kid is unknown. While the
+// keys can not be fetched, the error of the last attempt is returned if
+// there are no keys at all.
+func (k *JWKS) keys(ctx context.Context, kid string) ([]JWTKey, error) {
+	interval := k.RefreshInterval
+	if interval == 0 {
+		interval = time.Hour
+	}
+
+	k.mu.Lock()
+	stale := time.Since(k.fetched) > interval && time.Since(k.attempted) > jwksMinRefresh
+	k.mu.Unlock()
+	if stale {
+		k.refresh(ctx)
+	}
+
+	k.mu.Lock()
+	found := k.find(kid)
+	retry := len(found) == 0 && time.Since(k.attempted) > jwksMinRefresh
+	k.mu.Unlock()
+	if retry {
+		k.refresh(ctx)
+		k.mu.Lock()
+		found = k.find(kid)
+		k.mu.Unlock()
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.keySet == nil && k.lastErr != nil {
+		return nil, k.lastErr
+	}
+	return found, nil
+}
+
+// find returns the keys with the ID kid, or all keys if kid is empty,
+// k.mu must be held
+func (k *JWKS) find(kid string) []JWTKey {
+	var found []JWTKey
+	for _, key := range k.keySet {
+		if kid == "" || key.ID == kid {
+			found = append(found, key)
+		}
+	}
+	return found
+}
+
+// jwtKey returns the key to verify JWTs with, and false
+// for keys that are not signing keys or are not supported
+func (j jwk) jwtKey() (JWTKey, bool) {
+	if j.Use != "" && j.Use != "sig" {
+		return JWTKey{}, false
+	}
+
+	key := JWTKey{ID: j.KeyID}
+	switch {
+	case j.KeyType == "RSA" && (j.Algorithm == "" || j.Algorithm == JWTRS256):
+		n, errN := base64.RawURLEncoding.DecodeString(j.N)
+		e, errE := base64.RawURLEncoding.DecodeString(j.E)
+		if errN != nil || errE != nil || len(n) == 0 || len(e) == 0 || len(e) > 4 {
+			return JWTKey{}, false
+		}
+		key.Algorithm = JWTRS256
+		key.PublicKey = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+
+	case j.KeyType == "OKP" && j.Curve == "Ed25519" && (j.Algorithm == "" || j.Algorithm == JWTEdDSA):
+		x, err := base64.RawURLEncoding.DecodeString(j.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return JWTKey{}, false
+		}
+		key.Algorithm = JWTEdDSA
+		key.PublicKey = ed25519.PublicKey(x)
+
+	default:
+		return JWTKey{}, false
+	}
+	return key, true
+}
+
+// JWKSHandler serves the public keys of JWTKeys as a JSON Web Key Set, for
+// other services to verify the tokens made by SignJWT. HS256 keys are secret
+// and left out.
+func (t *Tools) JWKSHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keys := []jwk{}
+		for _, k := range t.JWTKeys {
+			switch pub := k.publicKey().(type) {
+			case *rsa.PublicKey:
+				keys = append(keys, jwk{
+					KeyType:   "RSA",
+					Use:       "sig",
+					Algorithm: JWTRS256,
+					KeyID:     k.ID,
+					N:         base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+					E:         base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+				})
+			case ed25519.PublicKey:
+				keys = append(keys, jwk{
+					KeyType:   "OKP",
+					Use:       "sig",
+					Algorithm: JWTEdDSA,
+					KeyID:     k.ID,
+					Curve:     "Ed25519",
+					X:         base64.RawURLEncoding.EncodeToString(pub),
+				})
+			}
+		}
+
+		headers := http.Header{}
+		headers.Set("Cache-Control", "public, max-age=300")
+		t.WriteJSON(w, http.StatusOK, map[string][]jwk{"keys": keys}, headers)
+	})
+}
@@ -0,0 +1,160 @@
+package webmod
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestJWKS_Rotation(t *testing.T) {
+	_, rsKey, edKey := testJWTKeys(t)
+
+	// the issuer starts with the RS256 key, then rotates to the EdDSA key
+	issuer := &Tools{JWTKeys: []JWTKey{rsKey}}
+	var fetches int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		issuer.JWKSHandler().ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	testTool := Tools{JWTKeySet: &JWKS{URL: server.URL}}
+	verify := func(name string, token string, expected error) {
+		_, err := testTool.VerifyJWT(context.Background(), token)
+		if !errors.Is(err, expected) {
+			printErr(t, name, "Unexpected error", fmt.Sprintf("Expected: %v", expected), fmt.Sprintf("Received: %v", err))
+		}
+	}
+
+	token, err := issuer.SignJWT(JWTClaims{Subject: "alice", ExpiresAt: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	verify("RS256 key", token, nil)
+	verify("Cached key", token, nil)
+	if n := atomic.LoadInt32(&fetches); n != 1 {
+		printErr(t, "Cached key", "Keys should be fetched once", fmt.Sprintf("Fetched: %d", n))
+	}
+
+	issuer.JWTKeys = []JWTKey{edKey, rsKey}
+	token, err = issuer.SignJWT(JWTClaims{Subject: "alice", ExpiresAt: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// unknown keys are not fetched again right away
+	verify("Rotated key too soon", token, ErrJWTUnknownKey)
+
+	defer func(d time.Duration) { jwksMinRefresh = d }(jwksMinRefresh)
+	jwksMinRefresh = 0
+	verify("Rotated key", token, nil)
+	verify("Unknown key", "eyJhbGciOiJFZERTQSIsImtpZCI6InVua25vd24ifQ.e30.AAAA", ErrJWTUnknownKey)
+	if n := atomic.LoadInt32(&fetches); n != 3 {
+		printErr(t, "Rotated key", "Keys should be fetched again for unknown keys", fmt.Sprintf("Fetched: %d", n))
+	}
+}
+
+func TestJWKS_Refresh(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"keys":[
+			{"kty":"RSA","kid":"enc","use":"enc","n":"AQAB","e":"AQAB"},
+			{"kty":"oct","kid":"hs","k":"c2VjcmV0"},
+			{"kty":"OKP","kid":"ed","crv":"Ed25519","x":"11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo"}
+		]}`))
+	}))
+	defer server.Close()
+
+	jwks := &JWKS{URL: server.URL}
+	err := jwks.Refresh(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jwks.keySet) != 1 || jwks.keySet[0].ID != "ed" || jwks.keySet[0].Algorithm != JWTEdDSA {
+		printErr(t, "Refresh", "Only the Ed25519 signing key should be loaded", fmt.Sprintf("Received: %+v", jwks.keySet))
+	}
+
+	missing := httptest.NewServer(http.NotFoundHandler())
+	defer missing.Close()
+
+	failing := &JWKS{URL: missing.URL}
+	err = failing.Refresh(context.Background())
+	if !errors.Is(err, ErrJWKSFetch) {
+		printErr(t, "Refresh", "Expected fetch error", fmt.Sprintf("Received: %v", err))
+	}
+}
+
+func TestJWKS_FetchLimits(t *testing.T) {
+	_, rsKey, _ := testJWTKeys(t)
+	token, err := (&Tools{JWTKeys: []JWTKey{rsKey}}).SignJWT(JWTClaims{Subject: "alice", ExpiresAt: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var fetches int32
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		<-release
+		http.Error(w, "down", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	testTool := Tools{JWTKeySet: &JWKS{URL: server.URL}}
+
+	// concurrent verifications share a single fetch
+	var wg sync.WaitGroup
+	errs := make([]error, 10)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = testTool.VerifyJWT(context.Background(), token)
+		}(i)
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	// and failed fetches are not retried right away
+	for i := 0; i < 10; i++ {
+		_, err := testTool.VerifyJWT(context.Background(), token)
+		errs = append(errs, err)
+	}
+
+	for _, err := range errs {
+		if !errors.Is(err, ErrJWKSFetch) {
+			printErr(t, "Endpoint down", "Expected fetch error", fmt.Sprintf("Received: %v", err))
+			break
+		}
+	}
+	if n := atomic.LoadInt32(&fetches); n != 1 {
+		printErr(t, "Endpoint down", "Keys should be fetched once", fmt.Sprintf("Fetched: %d", n))
+	}
+}
+
+func TestJWKS_CallerGone(t *testing.T) {
+	_, rsKey, _ := testJWTKeys(t)
+	keys := (&Tools{JWTKeys: []JWTKey{rsKey}}).JWKSHandler()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+		keys.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	set := &JWKS{URL: server.URL}
+
+	// the first caller going away does not fail the fetch
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	set.keys(ctx, rsKey.ID)
+
+	// so the other callers get the keys
+	found, err := set.keys(context.Background(), rsKey.ID)
+	if err != nil || len(found) != 1 {
+		printErr(t, "Other caller", "Keys not fetched", fmt.Sprintf("Received: %d %v", len(found), err))
+	}
+}
@@ -0,0 +1,427 @@
+package webmod
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Errors returned when signing or verifying JWTs
+var (
+	ErrNoJWTKey         = errors.New("No JWT signing key")
+	ErrJWTMalformed     = errors.New("Malformed token")
+	ErrJWTAlgorithm     = errors.New("Unsupported token algorithm")
+	ErrJWTUnknownKey    = errors.New("Unknown token key")
+	ErrJWTSignature     = errors.New("Invalid token signature")
+	ErrJWTExpired       = errors.New("Token has expired")
+	ErrJWTNoExpiry      = errors.New("Token has no expiry")
+	ErrJWTNotYetValid   = errors.New("Token is not valid yet")
+	ErrJWTIssuer        = errors.New("Invalid token issuer")
+	ErrJWTAudience      = errors.New("Invalid token audience")
+	ErrJWTMissingBearer = errors.New("Missing bearer token")
+	ErrJWTInvalid       = errors.New("Invalid token")
+)
+
+// JWT signing algorithms
+const (
+	JWTHS256 = "HS256"
+	JWTRS256 = "RS256"
+	JWTEdDSA = "EdDSA"
+)
+
+// JWTKey is a key used to sign or verify JWTs with Algorithm, identified by
+// its ID (the "kid" header) so that keys can be rotated. HS256 keys use
+// Secret, RS256 and EdDSA keys use PrivateKey (an *rsa.PrivateKey or an
+// ed25519.PrivateKey) to sign and PublicKey, or the public part of
+// PrivateKey, to verify.
+type JWTKey struct {
+	ID         string
+	Algorithm  string
+	Secret     []byte
+	PrivateKey crypto.Signer
+	PublicKey  crypto.PublicKey
+}
+
+// publicKey returns the key used to verify signatures
+func (k JWTKey) publicKey() crypto.PublicKey {
+	if k.PublicKey == nil && k.PrivateKey != nil {
+		return k.PrivateKey.Public()
+	}
+	return k.PublicKey
+}
+
+// JWTClaims are the claims of a JWT. Zero values are left out of the token.
+// Custom holds any other claim.
+type JWTClaims struct {
+	Issuer    string
+	Subject   string
+	Audience  []string
+	ExpiresAt time.Time
+	NotBefore time.Time
+	IssuedAt  time.Time
+	ID        string
+	Custom    map[string]interface{}
+}
+
+// MarshalJSON encodes the claims as a JWT payload
+func (c JWTClaims) MarshalJSON() ([]byte, error) {
+	m := map[string]interface{}{}
+	for k, v := range c.Custom {
+		m[k] = v
+	}
+
+	set := func(name, value string) {
+		if value != "" {
+			m[name] = value
+		}
+	}
+	set("iss", c.Issuer)
+	set("sub", c.Subject)
+	set("jti", c.ID)
+
+	setTime := func(name string, value time.Time) {
+		if !value.IsZero() {
+			m[name] = value.Unix()
+		}
+	}
+	setTime("exp", c.ExpiresAt)
+	setTime("nbf", c.NotBefore)
+	setTime("iat", c.IssuedAt)
+
+	switch len(c.Audience) {
+	case 0:
+	case 1:
+		m["aud"] = c.Audience[0]
+	default:
+		m["aud"] = c.Audience
+	}
+	return json.Marshal(m)
+}
+
+// UnmarshalJSON decodes the claims of a JWT payload
+func (c *JWTClaims) UnmarshalJSON(data []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var m map[string]interface{}
+	err := dec.Decode(&m)
+	if err != nil {
+		return err
+	}
+
+	*c = JWTClaims{}
+	var ok bool
+	str := func(name string, dst *string) {
+		if v, found := m[name]; found {
+			*dst, ok = v.(string)
+			if !ok {
+				err = fmt.Errorf("%w: %q is not a string", ErrJWTMalformed, name)
+			}
+			delete(m, name)
+		}
+	}
+	str("iss", &c.Issuer)
+	str("sub", &c.Subject)
+	str("jti", &c.ID)
+
+	date := func(name string, dst *time.Time) {
+		if v, found := m[name]; found {
+			n, isNumber := v.(json.Number)
+			f, numErr := n.Float64()
+			if !isNumber || numErr != nil {
+				err = fmt.Errorf("%w: %q is not a date", ErrJWTMalformed, name)
+			} else {
+				*dst = time.Unix(int64(f), 0)
+			}
+			delete(m, name)
+		}
+	}
+	date("exp", &c.ExpiresAt)
+	date("nbf", &c.NotBefore)
+	date("iat", &c.IssuedAt)
+
+	switch aud := m["aud"].(type) {
+	case nil:
+	case string:
+		c.Audience = []string{aud}
+	case []interface{}:
+		for _, a := range aud {
+			s, isString := a.(string)
+			if !isString {
+				err = fmt.Errorf("%w: \"aud\" is not a string array", ErrJWTMalformed)
+			}
+			c.Audience = append(c.Audience, s)
+		}
+	default:
+		err = fmt.Errorf("%w: \"aud\" is not a string array", ErrJWTMalformed)
+	}
+	delete(m, "aud")
+
+	if len(m) > 0 {
+		c.Custom = m
+	}
+	return err
+}
+
+// jwtHeader is the header of a JWT
+type jwtHeader struct {
+	Algorithm string `json:"alg"`
+	Type      string `json:"typ,omitempty"`
+	KeyID     string `json:"kid,omitempty"`
+}
+
+// SignJWT returns a JWT holding claims, signed with the first of JWTKeys.
+// IssuedAt is set to the current time if it is not set.
+func (t *Tools) SignJWT(claims JWTClaims) (string, error) {
+	if len(t.JWTKeys) == 0 {
+		return "", ErrNoJWTKey
+	}
+	key := t.JWTKeys[0]
+
+	if claims.IssuedAt.IsZero() {
+		claims.IssuedAt = time.Now()
+	}
+
+	header, err := json.Marshal(jwtHeader{Algorithm: key.Algorithm, Type: "JWT", KeyID: key.ID})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	sig, err := jwtSign(key, []byte(signingInput))
+	if err != nil {
+		return "", err
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// VerifyJWT verifies the signature of token with JWTKeys or the keys of
+// JWTKeySet, and checks its claims: it must expire, unless JWTAllowNoExpiry
+// is set, and not have expired, it must be valid already, and if JWTIssuer or
+// JWTAudience are set it must have been issued by JWTIssuer for JWTAudience. Times are checked with JWTLeeway, to allow
+// for clock skew. The algorithm of the token must be that of its key.
+func (t *Tools) VerifyJWT(ctx context.Context, token string) (*JWTClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrJWTMalformed
+	}
+
+	var header jwtHeader
+	err := decodeJWTPart(parts[0], &header)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrJWTMalformed
+	}
+
+	keys, err := t.jwtVerificationKeys(ctx, header)
+	if err != nil {
+		return nil, err
+	}
+	verified := false
+	for _, key := range keys {
+		if jwtVerify(key, []byte(parts[0]+"."+parts[1]), sig) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, ErrJWTSignature
+	}
+
+	var claims JWTClaims
+	err = decodeJWTPart(parts[1], &claims)
+	if err != nil {
+		return nil, err
+	}
+	err = t.validateJWTClaims(&claims, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	return &claims, nil
+}
+
+// jwtVerificationKeys returns the keys that may have signed a token with
+// header, looking the key up in JWTKeySet if it is not in JWTKeys.
+func (t *Tools) jwtVerificationKeys(ctx context.Context, header jwtHeader) ([]JWTKey, error) {
+	switch header.Algorithm {
+	case JWTHS256, JWTRS256, JWTEdDSA:
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrJWTAlgorithm, header.Algorithm)
+	}
+
+	var keys []JWTKey
+	for _, k := range t.JWTKeys {
+		if k.Algorithm == header.Algorithm && (header.KeyID == "" || k.ID == header.KeyID) {
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) == 0 && t.JWTKeySet != nil {
+		found, err := t.JWTKeySet.keys(ctx, header.KeyID)
+		if err != nil {
+			return nil, err
+		}
+		for _, k := range found {
+			if k.Algorithm == header.Algorithm {
+				keys = append(keys, k)
+			}
+		}
+	}
+
+	if len(keys) == 0 {
+		return nil, ErrJWTUnknownKey
+	}
+	return keys, nil
+}
+
+// validateJWTClaims checks the times, issuer and audience of claims
+func (t *Tools) validateJWTClaims(claims *JWTClaims, now time.Time) error {
+	if claims.ExpiresAt.IsZero() && !t.JWTAllowNoExpiry {
+		return ErrJWTNoExpiry
+	}
+	if !claims.ExpiresAt.IsZero() && !now.Before(claims.ExpiresAt.Add(t.JWTLeeway)) {
+		return ErrJWTExpired
+	}
+	if !claims.NotBefore.IsZero() && now.Add(t.JWTLeeway).Before(claims.NotBefore) {
+		return ErrJWTNotYetValid
+	}
+	if !claims.IssuedAt.IsZero() && now.Add(t.JWTLeeway).Before(claims.IssuedAt) {
+		return ErrJWTNotYetValid
+	}
+
+	if t.JWTIssuer != "" && claims.Issuer != t.JWTIssuer {
+		return ErrJWTIssuer
+	}
+	if t.JWTAudience != "" {
+		for _, aud := range claims.Audience {
+			if aud == t.JWTAudience {
+				return nil
+			}
+		}
+		return ErrJWTAudience
+	}
+	return nil
+}
+
+// decodeJWTPart decodes a base64url encoded JSON part of a JWT into v
+func decodeJWTPart(part string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return ErrJWTMalformed
+	}
+	err = json.Unmarshal(data, v)
+	if errors.Is(err, ErrJWTMalformed) {
+		return err
+	}
+	if err != nil {
+		return ErrJWTMalformed
+	}
+	return nil
+}
+
+// jwtSign returns the signature of signingInput with key
+func jwtSign(key JWTKey, signingInput []byte) ([]byte, error) {
+	switch key.Algorithm {
+	case JWTHS256:
+		if len(key.Secret) == 0 {
+			return nil, ErrNoJWTKey
+		}
+		mac := hmac.New(sha256.New, key.Secret)
+		mac.Write(signingInput)
+		return mac.Sum(nil), nil
+
+	case JWTRS256:
+		if _, ok := key.PrivateKey.(*rsa.PrivateKey); !ok {
+			return nil, ErrNoJWTKey
+		}
+		digest := sha256.Sum256(signingInput)
+		return key.PrivateKey.Sign(rand.Reader, digest[:], crypto.SHA256)
+
+	case JWTEdDSA:
+		if _, ok := key.PrivateKey.(ed25519.PrivateKey); !ok {
+			return nil, ErrNoJWTKey
+		}
+		return key.PrivateKey.Sign(rand.Reader, signingInput, crypto.Hash(0))
+	}
+	return nil, fmt.Errorf("%w: %q", ErrJWTAlgorithm, key.Algorithm)
+}
+
+// jwtVerify reports whether sig is the signature of signingInput with key
+func jwtVerify(key JWTKey, signingInput, sig []byte) bool {
+	switch key.Algorithm {
+	case JWTHS256:
+		if len(key.Secret) == 0 {
+			return false
+		}
+		mac := hmac.New(sha256.New, key.Secret)
+		mac.Write(signingInput)
+		return hmac.Equal(sig, mac.Sum(nil))
+
+	case JWTRS256:
+		pub, ok := key.publicKey().(*rsa.PublicKey)
+		if !ok {
+			return false
+		}
+		digest := sha256.Sum256(signingInput)
+		return rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], sig) == nil
+
+	case JWTEdDSA:
+		pub, ok := key.publicKey().(ed25519.PublicKey)
+		if !ok {
+			return false
+		}
+		return ed25519.Verify(pub, signingInput, sig)
+	}
+	return false
+}
+
+// jwtClaimsKey is the context key of verified JWT claims
+type jwtClaimsKey struct{}
+
+// RequireJWT is middleware that only lets requests with a valid bearer JWT
+// in their Authorization header through, see VerifyJWT. The claims of the
+// token are put in the request context, see JWTClaimsFromContext. Any other
+// request is answered with a 401 error, and the reason the token was
+// rejected is logged rather than revealed.
+func (t *Tools) RequireJWT(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if len(auth) < 7 || !strings.EqualFold(auth[:7], "Bearer ") {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			t.ErrorJSON(w, ErrJWTMissingBearer, http.StatusUnauthorized)
+			return
+		}
+
+		claims, err := t.VerifyJWT(r.Context(), strings.TrimSpace(auth[7:]))
+		if err != nil {
+			t.logError(r, err)
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			t.ErrorJSON(w, ErrJWTInvalid, http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), jwtClaimsKey{}, claims)))
+	})
+}
+
+// JWTClaimsFromContext returns the claims put in ctx by RequireJWT
+func (t *Tools) JWTClaimsFromContext(ctx context.Context) (*JWTClaims, bool) {
+	claims, ok := ctx.Value(jwtClaimsKey{}).(*JWTClaims)
+	return claims, ok
+}
@@ -0,0 +1,210 @@
+package webmod
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testJWTKeys returns an HS256, an RS256 and an EdDSA key
+func testJWTKeys(t *testing.T) (JWTKey, JWTKey, JWTKey) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return JWTKey{ID: "hs", Algorithm: JWTHS256, Secret: []byte("secret")},
+		JWTKey{ID: "rs", Algorithm: JWTRS256, PrivateKey: rsaKey},
+		JWTKey{ID: "ed", Algorithm: JWTEdDSA, PrivateKey: edKey}
+}
+
+func TestTools_VerifyJWT(t *testing.T) {
+	hsKey, rsKey, edKey := testJWTKeys(t)
+	now := time.Now()
+
+	sign := func(key JWTKey, claims JWTClaims) string {
+		testTool := Tools{JWTKeys: []JWTKey{key}}
+		token, err := testTool.SignJWT(claims)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	valid := JWTClaims{Issuer: "auth", Subject: "alice", Audience: []string{"api"}, ExpiresAt: now.Add(time.Minute)}
+	with := func(change func(c *JWTClaims)) JWTClaims {
+		c := valid
+		change(&c)
+		return c
+	}
+
+	// a token signed with the HMAC of the public RSA key
+	// must not be accepted as an RS256 token
+	rsPub, _ := json.Marshal(rsKey.publicKey())
+	confused := sign(JWTKey{ID: "rs", Algorithm: JWTHS256, Secret: rsPub}, valid)
+
+	// an unsigned token
+	none := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`)) + "." +
+		base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"alice"}`)) + "."
+
+	otherKey := JWTKey{ID: "hs", Algorithm: JWTHS256, Secret: []byte("other")}
+
+	testTool := Tools{
+		JWTKeys:     []JWTKey{hsKey, rsKey, {ID: "ed", Algorithm: JWTEdDSA, PublicKey: edKey.PrivateKey.Public()}},
+		JWTIssuer:   "auth",
+		JWTAudience: "api",
+		JWTLeeway:   30 * time.Second,
+	}
+
+	tests := []struct {
+		name     string
+		token    string
+		errorExp error
+	}{
+		{name: "HS256", token: sign(hsKey, valid)},
+		{name: "RS256", token: sign(rsKey, valid)},
+		{name: "EdDSA", token: sign(edKey, valid)},
+		{name: "Wrong secret", token: sign(otherKey, valid), errorExp: ErrJWTSignature},
+		{name: "Unknown key", token: sign(JWTKey{ID: "x", Algorithm: JWTHS256, Secret: []byte("x")}, valid), errorExp: ErrJWTUnknownKey},
+		{name: "Algorithm confusion", token: confused, errorExp: ErrJWTUnknownKey},
+		{name: "Algorithm none", token: none, errorExp: ErrJWTAlgorithm},
+		{name: "Malformed", token: "abc.def", errorExp: ErrJWTMalformed},
+		{name: "Tampered", token: strings.Replace(sign(hsKey, valid), ".", ".e", 1), errorExp: ErrJWTSignature},
+		{name: "Expired", token: sign(hsKey, with(func(c *JWTClaims) { c.ExpiresAt = now.Add(-time.Minute) })), errorExp: ErrJWTExpired},
+		{name: "Expired within leeway", token: sign(hsKey, with(func(c *JWTClaims) { c.ExpiresAt = now.Add(-10 * time.Second) }))},
+		{name: "Not yet valid", token: sign(hsKey, with(func(c *JWTClaims) { c.NotBefore = now.Add(time.Minute) })), errorExp: ErrJWTNotYetValid},
+		{name: "Not before within leeway", token: sign(hsKey, with(func(c *JWTClaims) { c.NotBefore = now.Add(10 * time.Second) }))},
+		{name: "Issued in the future", token: sign(hsKey, with(func(c *JWTClaims) { c.IssuedAt = now.Add(time.Hour) })), errorExp: ErrJWTNotYetValid},
+		{name: "Wrong issuer", token: sign(hsKey, with(func(c *JWTClaims) { c.Issuer = "other" })), errorExp: ErrJWTIssuer},
+		{name: "Wrong audience", token: sign(hsKey, with(func(c *JWTClaims) { c.Audience = []string{"web"} })), errorExp: ErrJWTAudience},
+		{name: "One of audiences", token: sign(hsKey, with(func(c *JWTClaims) { c.Audience = []string{"web", "api"} }))},
+		{name: "No expiry", token: sign(hsKey, with(func(c *JWTClaims) { c.ExpiresAt = time.Time{} })), errorExp: ErrJWTNoExpiry},
+	}
+
+	for _, e := range tests {
+		claims, err := testTool.VerifyJWT(context.Background(), e.token)
+		if !errors.Is(err, e.errorExp) {
+			printErr(t, e.name, "Unexpected error", fmt.Sprintf("Expected: %v", e.errorExp), fmt.Sprintf("Received: %v", err))
+			continue
+		}
+		if err == nil && claims.Subject != "alice" {
+			printErr(t, e.name, "Wrong claims", fmt.Sprintf("Received: %+v", claims))
+		}
+	}
+
+	// tokens without expiry can be allowed
+	testTool.JWTAllowNoExpiry = true
+	if _, err := testTool.VerifyJWT(context.Background(), sign(hsKey, with(func(c *JWTClaims) { c.ExpiresAt = time.Time{} }))); err != nil {
+		printErr(t, "Allowed no expiry", "Unexpected error", fmt.Sprintf("Received: %v", err))
+	}
+}
+
+func TestJWTClaims_JSON(t *testing.T) {
+	exp := time.Unix(1700000000, 0)
+	claims := JWTClaims{
+		Issuer:    "auth",
+		Audience:  []string{"api"},
+		ExpiresAt: exp,
+		Custom:    map[string]interface{}{"role": "admin"},
+	}
+
+	data, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `{"aud":"api","exp":1700000000,"iss":"auth","role":"admin"}` {
+		printErr(t, "Marshal claims", "Wrong JSON", fmt.Sprintf("Received: %s", data))
+	}
+
+	var decoded JWTClaims
+	err = json.Unmarshal(data, &decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Issuer != "auth" || !decoded.ExpiresAt.Equal(exp) || len(decoded.Audience) != 1 || decoded.Custom["role"] != "admin" {
+		printErr(t, "Unmarshal claims", "Wrong claims", fmt.Sprintf("Received: %+v", decoded))
+	}
+
+	err = json.Unmarshal([]byte(`{"exp":"tomorrow"}`), &decoded)
+	if !errors.Is(err, ErrJWTMalformed) {
+		printErr(t, "Unmarshal claims", "Expected malformed error", fmt.Sprintf("Received: %v", err))
+	}
+}
+
+func TestTools_RequireJWT(t *testing.T) {
+	hsKey, rsKey, _ := testJWTKeys(t)
+	var logged error
+	testTool := Tools{
+		JWTKeys:     []JWTKey{hsKey},
+		JWTKeySet:   &JWKS{URL: "http://127.0.0.1:1/jwks.json"},
+		ErrorLogger: func(r *http.Request, err error) { logged = err },
+	}
+
+	token, err := testTool.SignJWT(JWTClaims{Subject: "alice", ExpiresAt: time.Now().Add(time.Minute)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, _ := testTool.SignJWT(JWTClaims{Subject: "alice", ExpiresAt: time.Now().Add(-time.Minute)})
+	unknown, _ := (&Tools{JWTKeys: []JWTKey{rsKey}}).SignJWT(JWTClaims{Subject: "alice", ExpiresAt: time.Now().Add(time.Minute)})
+
+	handler := testTool.RequireJWT(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := testTool.JWTClaimsFromContext(r.Context())
+		if !ok {
+			t.Error("No claims in context")
+			return
+		}
+		w.Write([]byte(claims.Subject))
+	}))
+
+	tests := []struct {
+		name          string
+		authorization string
+		expectedCode  int
+		expectedBody  string
+		expectedLog   error
+	}{
+		{name: "Valid token", authorization: "Bearer " + token, expectedCode: http.StatusOK, expectedBody: "alice"},
+		{name: "Lower case scheme", authorization: "bearer " + token, expectedCode: http.StatusOK, expectedBody: "alice"},
+		{name: "Missing token", expectedCode: http.StatusUnauthorized, expectedBody: ErrJWTMissingBearer.Error()},
+		{name: "Basic auth", authorization: "Basic YWxpY2U6cGFzcw==", expectedCode: http.StatusUnauthorized, expectedBody: ErrJWTMissingBearer.Error()},
+		{name: "Expired token", authorization: "Bearer " + expired, expectedCode: http.StatusUnauthorized, expectedBody: ErrJWTInvalid.Error(), expectedLog: ErrJWTExpired},
+		{name: "Key set down", authorization: "Bearer " + unknown, expectedCode: http.StatusUnauthorized, expectedBody: ErrJWTInvalid.Error(), expectedLog: ErrJWKSFetch},
+	}
+
+	for _, e := range tests {
+		logged = nil
+		r := httptest.NewRequest("GET", "/", nil)
+		if e.authorization != "" {
+			r.Header.Set("Authorization", e.authorization)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		if w.Code != e.expectedCode || !strings.Contains(w.Body.String(), e.expectedBody) {
+			printErr(t, e.name, "Wrong response", fmt.Sprintf("Expected: %d %s", e.expectedCode, e.expectedBody), fmt.Sprintf("Received: %d %s", w.Code, w.Body.String()))
+		}
+		if w.Code == http.StatusUnauthorized && !strings.HasPrefix(w.Header().Get("WWW-Authenticate"), "Bearer") {
+			printErr(t, e.name, "Missing WWW-Authenticate header")
+		}
+
+		// the reason is logged, not revealed
+		if !errors.Is(logged, e.expectedLog) {
+			printErr(t, e.name, "Wrong logged error", fmt.Sprintf("Expected: %v", e.expectedLog), fmt.Sprintf("Received: %v", logged))
+		}
+		if e.expectedLog != nil && (strings.Contains(w.Body.String(), "expired") || strings.Contains(w.Body.String(), "127.0.0.1")) {
+			printErr(t, e.name, "Reason revealed", fmt.Sprintf("Received: %s", w.Body.String()))
+		}
+	}
+}
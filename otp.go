@@ -0,0 +1,227 @@
+package webmod
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Errors returned by the one-time password and code helpers
+var (
+	ErrInvalidOTPConfig  = errors.New("Invalid one-time password configuration")
+	ErrOTPReplayed       = errors.New("The one-time password was already used")
+	ErrInvalidCodeLength = errors.New("Codes must have at least one digit")
+)
+
+// maxOTPDigits is the length of the longest one-time passwords allowed by
+// RFC 4226, longer codes taken from 31 bits would not cover all their values
+const maxOTPDigits = 8
+
+// OTPConfig configures HOTP (RFC 4226) and TOTP (RFC 6238) one-time
+// passwords. Digits defaults to 6 and is at most 8, Period to 30 seconds and Algorithm to
+// "SHA1", the only one supported by most authenticator apps ("SHA256" and
+// "SHA512" are also supported).
+//
+// Skew is the number of time steps accepted before and after the current
+// one for TOTP, and the number of counters accepted after the expected one
+// for HOTP. MarkUsed, if set, is called with the counter (the time step for
+// TOTP) of a matching code. It should record the counter as used and report
+// whether it was unused, codes whose counter was used are rejected with
+// ErrOTPReplayed.
+type OTPConfig struct {
+	Secret    []byte
+	Digits    int
+	Period    time.Duration
+	Algorithm string
+	Skew      int
+	MarkUsed  func(counter uint64) bool
+}
+
+// withDefaults returns the config with the defaults set for zero values
+func (c OTPConfig) withDefaults() OTPConfig {
+	if c.Digits == 0 {
+		c.Digits = 6
+	}
+	if c.Period == 0 {
+		c.Period = 30 * time.Second
+	}
+	if c.Algorithm == "" {
+		c.Algorithm = "SHA1"
+	}
+	return c
+}
+
+// hash returns the hash function of the algorithm,
+// or nil if the config is not valid
+func (c OTPConfig) hash() func() hash.Hash {
+	if len(c.Secret) == 0 || c.Digits < 1 || c.Digits > maxOTPDigits || c.Period < time.Second || c.Skew < 0 {
+		return nil
+	}
+	switch strings.ToUpper(c.Algorithm) {
+	case "SHA1":
+		return sha1.New
+	case "SHA256":
+		return sha256.New
+	case "SHA512":
+		return sha512.New
+	}
+	return nil
+}
+
+// GenerateCode returns a random numeric code of the given number of digits,
+// e.g. for email verification. Every code is equally likely.
+// ErrInvalidCodeLength is returned if digits is less than one.
+func (t *Tools) GenerateCode(digits int) (string, error) {
+	if digits < 1 {
+		return "", ErrInvalidCodeLength
+	}
+	return randomFrom("0123456789", digits)
+}
+
+// GenerateOTPSecret returns a random 160 bit secret for one-time passwords
+func (t *Tools) GenerateOTPSecret() ([]byte, error) {
+	secret := make([]byte, 20)
+	_, err := rand.Read(secret)
+	if err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
+
+// HOTP returns the HOTP code for counter
+func (t *Tools) HOTP(config OTPConfig, counter uint64) (string, error) {
+	config = config.withDefaults()
+	h := config.hash()
+	if h == nil {
+		return "", ErrInvalidOTPConfig
+	}
+	return otpCode(h, config.Secret, counter, config.Digits), nil
+}
+
+// VerifyHOTP reports whether code matches the HOTP code for counter, or
+// for one of the next Skew counters. If it does, the counter to expect
+// next is returned and should be stored.
+func (t *Tools) VerifyHOTP(config OTPConfig, code string, counter uint64) (uint64, bool, error) {
+	config = config.withDefaults()
+	h := config.hash()
+	if h == nil {
+		return counter, false, ErrInvalidOTPConfig
+	}
+
+	for i := uint64(0); i <= uint64(config.Skew); i++ {
+		if otpMatches(h, config, code, counter+i) {
+			if config.MarkUsed != nil && !config.MarkUsed(counter+i) {
+				return counter, false, ErrOTPReplayed
+			}
+			return counter + i + 1, true, nil
+		}
+	}
+	return counter, false, nil
+}
+
+// TOTP returns the TOTP code at time at
+func (t *Tools) TOTP(config OTPConfig, at time.Time) (string, error) {
+	config = config.withDefaults()
+	h := config.hash()
+	if h == nil {
+		return "", ErrInvalidOTPConfig
+	}
+	return otpCode(h, config.Secret, totpStep(config, at), config.Digits), nil
+}
+
+// VerifyTOTP reports whether code matches the TOTP code at time at,
+// or up to Skew time steps before or after it.
+func (t *Tools) VerifyTOTP(config OTPConfig, code string, at time.Time) (bool, error) {
+	config = config.withDefaults()
+	h := config.hash()
+	if h == nil {
+		return false, ErrInvalidOTPConfig
+	}
+
+	step := totpStep(config, at)
+	for i := -config.Skew; i <= config.Skew; i++ {
+		if i < 0 && uint64(-i) > step {
+			continue
+		}
+		s := step + uint64(i)
+		if otpMatches(h, config, code, s) {
+			if config.MarkUsed != nil && !config.MarkUsed(s) {
+				return false, ErrOTPReplayed
+			}
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// OTPAuthURL returns the otpauth:// URL of a TOTP config, to be shown
+// as a QR code to be scanned by authenticator apps. The issuer is the name
+// of the service and account is the name of the user, e.g. their email.
+func (t *Tools) OTPAuthURL(config OTPConfig, issuer, account string) (string, error) {
+	config = config.withDefaults()
+	if config.hash() == nil {
+		return "", ErrInvalidOTPConfig
+	}
+
+	label := account
+	if issuer != "" {
+		label = issuer + ":" + account
+	}
+
+	q := url.Values{}
+	q.Set("secret", base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(config.Secret))
+	if issuer != "" {
+		q.Set("issuer", issuer)
+	}
+	q.Set("algorithm", strings.ToUpper(config.Algorithm))
+	q.Set("digits", strconv.Itoa(config.Digits))
+	q.Set("period", strconv.Itoa(int(config.Period/time.Second)))
+
+	u := url.URL{Scheme: "otpauth", Host: "totp", Path: "/" + label, RawQuery: q.Encode()}
+	return u.String(), nil
+}
+
+// totpStep returns the time step of at
+func totpStep(config OTPConfig, at time.Time) uint64 {
+	if at.Unix() < 0 {
+		return 0
+	}
+	return uint64(at.Unix()) / uint64(config.Period/time.Second)
+}
+
+// otpMatches reports whether code is the code for counter, in constant time
+func otpMatches(h func() hash.Hash, config OTPConfig, code string, counter uint64) bool {
+	expected := otpCode(h, config.Secret, counter, config.Digits)
+	return subtle.ConstantTimeCompare([]byte(code), []byte(expected)) == 1
+}
+
+// otpCode returns the code for counter, as described in RFC 4226
+func otpCode(h func() hash.Hash, secret []byte, counter uint64, digits int) string {
+	mac := hmac.New(h, secret)
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], counter)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	// dynamic truncation
+	offset := sum[len(sum)-1] & 0x0f
+	value := uint64(binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff)
+
+	mod := uint64(1)
+	for i := 0; i < digits; i++ {
+		mod *= 10
+	}
+	code := strconv.FormatUint(value%mod, 10)
+	return strings.Repeat("0", digits-len(code)) + code
+}
@@ -0,0 +1,208 @@
+package webmod
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestTools_GenerateCode(t *testing.T) {
+	var testTool Tools
+
+	code, err := testTool.GenerateCode(6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(code) != 6 {
+		printErr(t, "Generate code", "Wrong length", fmt.Sprintf("Received: %s", code))
+	}
+
+	for _, digits := range []int{0, -1} {
+		_, err := testTool.GenerateCode(digits)
+		if !errors.Is(err, ErrInvalidCodeLength) {
+			printErr(t, fmt.Sprintf("Generate code of %d digits", digits), "Expected invalid length error", fmt.Sprintf("Received: %v", err))
+		}
+	}
+
+	// every digit shows up in every position
+	var seen [6][10]bool
+	for i := 0; i < 1000; i++ {
+		code, _ = testTool.GenerateCode(6)
+		for j, c := range code {
+			if c < '0' || c > '9' {
+				printErr(t, "Generate code", "Not a digit", fmt.Sprintf("Received: %s", code))
+				return
+			}
+			seen[j][c-'0'] = true
+		}
+	}
+	for j := range seen {
+		for d, ok := range seen[j] {
+			if !ok {
+				printErr(t, "Generate code", fmt.Sprintf("Digit %d never generated at position %d", d, j))
+			}
+		}
+	}
+}
+
+func TestTools_HOTP(t *testing.T) {
+	var testTool Tools
+	config := OTPConfig{Secret: []byte("12345678901234567890")}
+
+	// test values from RFC 4226 appendix D
+	expected := []string{"755224", "287082", "359152", "969429", "338314", "254676", "287922", "162583", "399871", "520489"}
+	for i, e := range expected {
+		code, err := testTool.HOTP(config, uint64(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != e {
+			printErr(t, "HOTP", fmt.Sprintf("Wrong code for counter %d", i), fmt.Sprintf("Expected: %s", e), fmt.Sprintf("Received: %s", code))
+		}
+	}
+
+	config.Skew = 2
+	tests := []struct {
+		name     string
+		code     string
+		counter  uint64
+		next     uint64
+		expected bool
+	}{
+		{name: "Expected counter", code: "755224", counter: 0, next: 1, expected: true},
+		{name: "Within look-ahead", code: "359152", counter: 0, next: 3, expected: true},
+		{name: "Beyond look-ahead", code: "969429", counter: 0, next: 0, expected: false},
+		{name: "Previous counter", code: "755224", counter: 1, next: 1, expected: false},
+	}
+
+	for _, e := range tests {
+		next, ok, err := testTool.VerifyHOTP(config, e.code, e.counter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != e.expected || next != e.next {
+			printErr(t, e.name, fmt.Sprintf("Expected %t and counter %d", e.expected, e.next), fmt.Sprintf("Received: %t and counter %d", ok, next))
+		}
+	}
+}
+
+func TestTools_TOTP(t *testing.T) {
+	var testTool Tools
+
+	// test values from RFC 6238 appendix B
+	sha1Secret := []byte("12345678901234567890")
+	sha256Secret := []byte("12345678901234567890123456789012")
+	sha512Secret := []byte("1234567890123456789012345678901234567890123456789012345678901234")
+
+	tests := []struct {
+		name     string
+		config   OTPConfig
+		at       int64
+		expected string
+	}{
+		{name: "SHA1 59", config: OTPConfig{Secret: sha1Secret, Digits: 8}, at: 59, expected: "94287082"},
+		{name: "SHA256 59", config: OTPConfig{Secret: sha256Secret, Digits: 8, Algorithm: "SHA256"}, at: 59, expected: "46119246"},
+		{name: "SHA512 59", config: OTPConfig{Secret: sha512Secret, Digits: 8, Algorithm: "SHA512"}, at: 59, expected: "90693936"},
+		{name: "SHA1 1111111109", config: OTPConfig{Secret: sha1Secret, Digits: 8}, at: 1111111109, expected: "07081804"},
+		{name: "SHA256 1234567890", config: OTPConfig{Secret: sha256Secret, Digits: 8, Algorithm: "sha256"}, at: 1234567890, expected: "91819424"},
+		{name: "SHA512 20000000000", config: OTPConfig{Secret: sha512Secret, Digits: 8, Algorithm: "SHA512"}, at: 20000000000, expected: "47863826"},
+	}
+
+	for _, e := range tests {
+		code, err := testTool.TOTP(e.config, time.Unix(e.at, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != e.expected {
+			printErr(t, e.name, "Wrong code", fmt.Sprintf("Expected: %s", e.expected), fmt.Sprintf("Received: %s", code))
+		}
+	}
+
+	_, err := testTool.TOTP(OTPConfig{Secret: sha1Secret, Algorithm: "MD5"}, time.Now())
+	if !errors.Is(err, ErrInvalidOTPConfig) {
+		printErr(t, "Unknown algorithm", "Expected invalid config error", fmt.Sprintf("Received: %v", err))
+	}
+
+	_, err = testTool.TOTP(OTPConfig{Secret: sha1Secret, Digits: 9}, time.Now())
+	if !errors.Is(err, ErrInvalidOTPConfig) {
+		printErr(t, "Too many digits", "Expected invalid config error", fmt.Sprintf("Received: %v", err))
+	}
+}
+
+func TestTools_VerifyTOTP(t *testing.T) {
+	var testTool Tools
+
+	now := time.Unix(1700000000, 0)
+	used := map[uint64]bool{}
+	config := OTPConfig{
+		Secret: []byte("12345678901234567890"),
+		Skew:   1,
+		MarkUsed: func(counter uint64) bool {
+			if used[counter] {
+				return false
+			}
+			used[counter] = true
+			return true
+		},
+	}
+
+	codeAt := func(d time.Duration) string {
+		code, err := testTool.TOTP(config, now.Add(d))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return code
+	}
+
+	tests := []struct {
+		name     string
+		code     string
+		expected bool
+		errorExp error
+	}{
+		{name: "Current code", code: codeAt(0), expected: true},
+		{name: "Replayed code", code: codeAt(0), expected: false, errorExp: ErrOTPReplayed},
+		{name: "Previous step", code: codeAt(-30 * time.Second), expected: true},
+		{name: "Next step", code: codeAt(30 * time.Second), expected: true},
+		{name: "Beyond skew", code: codeAt(-90 * time.Second), expected: false},
+		{name: "Wrong length", code: "12345", expected: false},
+	}
+
+	for _, e := range tests {
+		ok, err := testTool.VerifyTOTP(config, e.code, now)
+		if !errors.Is(err, e.errorExp) {
+			printErr(t, e.name, "Unexpected error", fmt.Sprintf("Expected: %v", e.errorExp), fmt.Sprintf("Received: %v", err))
+		}
+		if ok != e.expected {
+			printErr(t, e.name, fmt.Sprintf("Expected verified to be %t", e.expected))
+		}
+	}
+}
+
+func TestTools_OTPAuthURL(t *testing.T) {
+	var testTool Tools
+
+	config := OTPConfig{Secret: []byte("12345678901234567890")}
+	u, err := testTool.OTPAuthURL(config, "Example Co", "alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := url.Parse(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := parsed.Query()
+
+	if parsed.Scheme != "otpauth" || parsed.Host != "totp" || parsed.Path != "/Example Co:alice@example.com" {
+		printErr(t, "OTP auth URL", "Wrong label", fmt.Sprintf("Received: %s", u))
+	}
+	if q.Get("secret") != "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ" || q.Get("issuer") != "Example Co" {
+		printErr(t, "OTP auth URL", "Wrong secret or issuer", fmt.Sprintf("Received: %s", u))
+	}
+	if q.Get("algorithm") != "SHA1" || q.Get("digits") != "6" || q.Get("period") != "30" {
+		printErr(t, "OTP auth URL", "Wrong parameters", fmt.Sprintf("Received: %s", u))
+	}
+}
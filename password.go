@@ -0,0 +1,228 @@
+package webmod
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Errors returned by the password helpers
+var (
+	ErrInvalidPasswordHash = errors.New("Invalid or unsupported password hash")
+	ErrPasswordPolicy      = errors.New("The password policy cannot be satisfied")
+)
+
+// Argon2Params are the parameters of argon2id password hashes.
+// Memory is in KiB.
+type Argon2Params struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// withDefaults returns the parameters with the defaults set for zero values
+func (p Argon2Params) withDefaults() Argon2Params {
+	if p.Memory == 0 {
+		p.Memory = 64 * 1024
+	}
+	if p.Iterations == 0 {
+		p.Iterations = 3
+	}
+	if p.Parallelism == 0 {
+		p.Parallelism = 2
+	}
+	if p.SaltLength == 0 {
+		p.SaltLength = 16
+	}
+	if p.KeyLength == 0 {
+		p.KeyLength = 32
+	}
+	return p
+}
+
+// HashPassword hashes password with argon2id, using the PasswordHashing
+// parameters. The parameters and salt are encoded in the returned hash,
+// e.g. "$argon2id$v=19$m=65536,t=3,p=2$<salt>$<key>", so that it can be
+// verified after the parameters change.
+func (t *Tools) HashPassword(password string) (string, error) {
+	p := t.PasswordHashing.withDefaults()
+
+	salt := make([]byte, p.SaltLength)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, p.Memory, p.Iterations, p.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// VerifyPassword reports whether password matches hash, which is either
+// an argon2id hash from HashPassword or a bcrypt hash. ErrInvalidPasswordHash
+// is returned for hashes in any other format.
+func (t *Tools) VerifyPassword(password, hash string) (bool, error) {
+	if isBcryptHash(hash) {
+		err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, nil
+		}
+		if err != nil {
+			return false, fmt.Errorf("%w: %s", ErrInvalidPasswordHash, err)
+		}
+		return true, nil
+	}
+
+	p, salt, key, err := decodeArgon2Hash(hash)
+	if err != nil {
+		return false, err
+	}
+	other := argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
+	return subtle.ConstantTimeCompare(key, other) == 1, nil
+}
+
+// NeedsRehash reports whether hash was not made with the current
+// PasswordHashing parameters, e.g. a bcrypt hash or an argon2id hash
+// using less memory. It should be checked after the password is verified,
+// to store a new hash while the password is known.
+func (t *Tools) NeedsRehash(hash string) bool {
+	p, _, _, err := decodeArgon2Hash(hash)
+	if err != nil {
+		return true
+	}
+	return p != t.PasswordHashing.withDefaults()
+}
+
+// isBcryptHash reports whether hash looks like a bcrypt hash
+func isBcryptHash(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$")
+}
+
+// decodeArgon2Hash returns the parameters, salt and key of an argon2id hash
+func decodeArgon2Hash(hash string) (p Argon2Params, salt, key []byte, err error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[0] != "" || parts[1] != "argon2id" {
+		return p, nil, nil, ErrInvalidPasswordHash
+	}
+
+	var version int
+	_, err = fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil || version != argon2.Version {
+		return p, nil, nil, ErrInvalidPasswordHash
+	}
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Iterations, &p.Parallelism)
+	if err != nil || p.Memory == 0 || p.Iterations == 0 || p.Parallelism == 0 {
+		return p, nil, nil, ErrInvalidPasswordHash
+	}
+
+	salt, err = base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil || len(salt) == 0 {
+		return p, nil, nil, ErrInvalidPasswordHash
+	}
+	key, err = base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return p, nil, nil, ErrInvalidPasswordHash
+	}
+	p.SaltLength, p.KeyLength = uint32(len(salt)), uint32(len(key))
+	return p, salt, key, nil
+}
+
+// default character classes of generated passwords
+const (
+	passwordLower   = "abcdefghijklmnopqrstuvwxyz"
+	passwordUpper   = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	passwordDigits  = "0123456789"
+	passwordSymbols = "!#$%&*+-=?@^_~"
+)
+
+// PasswordPolicy describes the passwords made by GeneratePassword. Length
+// defaults to 16, and the password holds at least the given number of
+// characters of each class. A negative minimum leaves the class out
+// entirely. Symbols replaces the default set of ASCII symbols, and must
+// only hold ASCII characters too.
+type PasswordPolicy struct {
+	Length     int
+	MinLower   int
+	MinUpper   int
+	MinDigits  int
+	MinSymbols int
+	Symbols    string
+}
+
+// GeneratePassword returns a random password satisfying policy.
+// ErrPasswordPolicy is returned if the minimums add up to more than the
+// length, if every character class is left out or if Symbols is too long
+// or not ASCII.
+func (t *Tools) GeneratePassword(policy PasswordPolicy) (string, error) {
+	if policy.Length <= 0 {
+		policy.Length = 16
+	}
+	if policy.Symbols == "" {
+		policy.Symbols = passwordSymbols
+	}
+	// characters are picked byte by byte
+	for i := 0; i < len(policy.Symbols); i++ {
+		if policy.Symbols[i] >= utf8.RuneSelf {
+			return "", ErrPasswordPolicy
+		}
+	}
+
+	classes := []struct {
+		chars string
+		min   int
+	}{
+		{passwordLower, policy.MinLower},
+		{passwordUpper, policy.MinUpper},
+		{passwordDigits, policy.MinDigits},
+		{policy.Symbols, policy.MinSymbols},
+	}
+
+	var all string
+	var required int
+	for _, c := range classes {
+		if c.min >= 0 {
+			all += c.chars
+			required += c.min
+		}
+	}
+	if all == "" || len(all) > 255 || required > policy.Length {
+		return "", ErrPasswordPolicy
+	}
+
+	password := make([]byte, 0, policy.Length)
+	for _, c := range classes {
+		if c.min > 0 {
+			s, err := randomFrom(c.chars, c.min)
+			if err != nil {
+				return "", err
+			}
+			password = append(password, s...)
+		}
+	}
+
+	s, err := randomFrom(all, policy.Length-required)
+	if err != nil {
+		return "", err
+	}
+	password = append(password, s...)
+
+	// shuffle, so that the required characters are not always first
+	for i := len(password) - 1; i > 0; i-- {
+		j, err := rand.Int(rand.Reader, big.NewInt(int64(i+1)))
+		if err != nil {
+			return "", err
+		}
+		password[i], password[j.Int64()] = password[j.Int64()], password[i]
+	}
+	return string(password), nil
+}
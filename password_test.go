@@ -0,0 +1,148 @@
+package webmod
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// small parameters, to keep the tests fast
+var testArgon2Params = Argon2Params{Memory: 64, Iterations: 1, Parallelism: 1}
+
+func TestTools_HashPassword(t *testing.T) {
+	testTool := Tools{PasswordHashing: testArgon2Params}
+
+	hash, err := testTool.HashPassword("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=64,t=1,p=1$") {
+		printErr(t, "Hash password", "Wrong hash format", fmt.Sprintf("Received: %s", hash))
+	}
+
+	other, _ := testTool.HashPassword("correct horse")
+	if other == hash {
+		printErr(t, "Hash password", "Hashes of the same password should be salted")
+	}
+
+	legacy, err := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		password string
+		hash     string
+		expected bool
+		errorExp error
+	}{
+		{name: "Correct password", password: "correct horse", hash: hash, expected: true},
+		{name: "Wrong password", password: "battery staple", hash: hash, expected: false},
+		{name: "Bcrypt correct password", password: "correct horse", hash: string(legacy), expected: true},
+		{name: "Bcrypt wrong password", password: "battery staple", hash: string(legacy), expected: false},
+		{name: "Unknown format", password: "correct horse", hash: "$1$abc$def", errorExp: ErrInvalidPasswordHash},
+		{name: "Bad parameters", password: "correct horse", hash: "$argon2id$v=19$m=0,t=1,p=1$c2FsdA$a2V5", errorExp: ErrInvalidPasswordHash},
+		{name: "Bad salt", password: "correct horse", hash: "$argon2id$v=19$m=64,t=1,p=1$!!$a2V5", errorExp: ErrInvalidPasswordHash},
+		{name: "Truncated bcrypt", password: "correct horse", hash: string(legacy[:20]), errorExp: ErrInvalidPasswordHash},
+	}
+
+	for _, e := range tests {
+		ok, err := testTool.VerifyPassword(e.password, e.hash)
+		if !errors.Is(err, e.errorExp) {
+			printErr(t, e.name, "Unexpected error", fmt.Sprintf("Expected: %v", e.errorExp), fmt.Sprintf("Received: %v", err))
+		}
+		if ok != e.expected {
+			printErr(t, e.name, fmt.Sprintf("Expected verified to be %t", e.expected))
+		}
+	}
+}
+
+func TestTools_NeedsRehash(t *testing.T) {
+	oldTool := Tools{PasswordHashing: testArgon2Params}
+	hash, err := oldTool.HashPassword("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy, _ := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+
+	upgraded := testArgon2Params
+	upgraded.Iterations = 2
+	newTool := Tools{PasswordHashing: upgraded}
+
+	tests := []struct {
+		name     string
+		tool     *Tools
+		hash     string
+		expected bool
+	}{
+		{name: "Current parameters", tool: &oldTool, hash: hash, expected: false},
+		{name: "Upgraded parameters", tool: &newTool, hash: hash, expected: true},
+		{name: "Bcrypt hash", tool: &oldTool, hash: string(legacy), expected: true},
+		{name: "Default parameters", tool: &Tools{}, hash: hash, expected: true},
+	}
+
+	for _, e := range tests {
+		if e.tool.NeedsRehash(e.hash) != e.expected {
+			printErr(t, e.name, fmt.Sprintf("Expected needs rehash to be %t", e.expected))
+		}
+	}
+}
+
+func TestTools_GeneratePassword(t *testing.T) {
+	var testTool Tools
+
+	tests := []struct {
+		name     string
+		policy   PasswordPolicy
+		length   int
+		errorExp error
+	}{
+		{name: "Default policy", policy: PasswordPolicy{}, length: 16},
+		{name: "All classes", policy: PasswordPolicy{Length: 8, MinLower: 2, MinUpper: 2, MinDigits: 2, MinSymbols: 2}, length: 8},
+		{name: "Digits only", policy: PasswordPolicy{Length: 6, MinLower: -1, MinUpper: -1, MinSymbols: -1}, length: 6},
+		{name: "Custom symbols", policy: PasswordPolicy{Length: 12, MinSymbols: 4, Symbols: ".,"}, length: 12},
+		{name: "Too many required", policy: PasswordPolicy{Length: 4, MinLower: 3, MinDigits: 3}, errorExp: ErrPasswordPolicy},
+		{name: "Non-ASCII symbols", policy: PasswordPolicy{Length: 12, MinSymbols: 2, Symbols: "€£"}, errorExp: ErrPasswordPolicy},
+		{name: "No classes", policy: PasswordPolicy{MinLower: -1, MinUpper: -1, MinDigits: -1, MinSymbols: -1}, errorExp: ErrPasswordPolicy},
+	}
+
+	count := func(s, chars string) int {
+		n := 0
+		for _, c := range s {
+			if strings.ContainsRune(chars, c) {
+				n++
+			}
+		}
+		return n
+	}
+
+	for _, e := range tests {
+		password, err := testTool.GeneratePassword(e.policy)
+		if !errors.Is(err, e.errorExp) {
+			printErr(t, e.name, "Unexpected error", fmt.Sprintf("Expected: %v", e.errorExp), fmt.Sprintf("Received: %v", err))
+			continue
+		}
+		if err != nil {
+			continue
+		}
+
+		symbols := e.policy.Symbols
+		if symbols == "" {
+			symbols = passwordSymbols
+		}
+		if len(password) != e.length {
+			printErr(t, e.name, "Wrong length", fmt.Sprintf("Received: %s", password))
+		}
+		if count(password, passwordLower) < e.policy.MinLower || count(password, passwordUpper) < e.policy.MinUpper ||
+			count(password, passwordDigits) < e.policy.MinDigits || count(password, symbols) < e.policy.MinSymbols {
+			printErr(t, e.name, "Policy not satisfied", fmt.Sprintf("Received: %s", password))
+		}
+		if e.policy.MinLower < 0 && count(password, passwordLower) > 0 || e.policy.MinSymbols < 0 && count(password, symbols) > 0 {
+			printErr(t, e.name, "Excluded class used", fmt.Sprintf("Received: %s", password))
+		}
+	}
+}
@@ -0,0 +1,448 @@
+package webmod
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The phases of an upload reported in UploadProgress
+const (
+	UploadReceiving  = "receiving"
+	UploadProcessing = "processing"
+	UploadDone       = "done"
+)
+
+// UploadIDHeader is the request header holding the ID of an upload, it can
+// also be given as the "upload_id" query parameter of the upload request.
+// UploadTracker only tracks uploads whose ID was issued by NewUploadID.
+const UploadIDHeader = "X-Upload-ID"
+
+// ErrUploadNotTracked is returned when UploadTracker is not set
+var ErrUploadNotTracked = errors.New("Upload progress is not tracked")
+
+// UploadProgress is the progress of an upload handled by UploadFiles.
+// TotalBytes is the size of the request body, or -1 if it isn't known.
+type UploadProgress struct {
+	UploadID      string         `json:"upload_id"`
+	Phase         string         `json:"phase"`
+	BytesReceived int64          `json:"bytes_received"`
+	TotalBytes    int64          `json:"total_bytes"`
+	Files         []FileProgress `json:"files"`
+	Error         string         `json:"error,omitempty"`
+}
+
+// FileProgress is the progress of a single file of an upload.
+// Stored is set once the file has been written to the upload dir.
+type FileProgress struct {
+	Field         string `json:"field"`
+	FileName      string `json:"file_name"`
+	BytesReceived int64  `json:"bytes_received"`
+	Stored        bool   `json:"stored"`
+}
+
+// uploadProgress collects the progress of one upload and reports it to
+// OnUploadProgress and UploadTracker.
+type uploadProgress struct {
+	t        *Tools
+	mu       sync.Mutex
+	progress UploadProgress
+	pw       *io.PipeWriter
+	counted  chan struct{}
+}
+
+// trackUploadProgress starts tracking the progress of the upload request r,
+// it returns nil if progress isn't reported or r is not a multipart request.
+func (t *Tools) trackUploadProgress(r *http.Request) *uploadProgress {
+	if t.OnUploadProgress == nil && t.UploadTracker == nil {
+		return nil
+	}
+	mediatype, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || !strings.HasPrefix(mediatype, "multipart/") || params["boundary"] == "" || r.Body == nil {
+		return nil
+	}
+
+	id := r.Header.Get(UploadIDHeader)
+	if id == "" {
+		id = r.URL.Query().Get("upload_id")
+	}
+	// the progress of other uploads is only passed to OnUploadProgress
+	if t.UploadTracker != nil && id != "" && !t.UploadTracker.claim(id) {
+		id = ""
+	}
+	total := r.ContentLength
+	if total < 0 {
+		total = -1
+	}
+
+	p := &uploadProgress{
+		t: t,
+		progress: UploadProgress{
+			UploadID:   id,
+			Phase:      UploadReceiving,
+			TotalBytes: total,
+			Files:      []FileProgress{},
+		},
+		counted: make(chan struct{}),
+	}
+	p.report(func(*UploadProgress) {})
+
+	// everything read from the body is also sent through a pipe
+	// to a second multipart reader, which counts the bytes of each file
+	pr, pw := io.Pipe()
+	p.pw = pw
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.TeeReader(r.Body, pw), r.Body}
+	go p.count(pr, params["boundary"])
+
+	return p
+}
+
+// count reads the multipart body from r and reports the bytes received
+func (p *uploadProgress) count(r io.Reader, boundary string) {
+	defer close(p.counted)
+	// drain the pipe whatever happens, so that reading the body never blocks
+	defer io.Copy(io.Discard, r)
+
+	mr := multipart.NewReader(&progressReader{Reader: r, p: p}, boundary)
+	buf := make([]byte, 32*1024)
+	for {
+		part, err := mr.NextPart()
+		if err != nil {
+			return
+		}
+
+		file := -1
+		if part.FileName() != "" {
+			p.report(func(up *UploadProgress) {
+				file = len(up.Files)
+				up.Files = append(up.Files, FileProgress{Field: part.FormName(), FileName: part.FileName()})
+			})
+		}
+		for {
+			n, err := part.Read(buf)
+			if n > 0 && file >= 0 {
+				p.report(func(up *UploadProgress) {
+					up.Files[file].BytesReceived += int64(n)
+				})
+			}
+			if err != nil {
+				break
+			}
+		}
+	}
+}
+
+// received stops counting once the body has been read, and
+// moves on to the processing phase.
+func (p *uploadProgress) received() {
+	if p == nil {
+		return
+	}
+	p.pw.Close()
+	<-p.counted
+	p.report(func(up *UploadProgress) {
+		up.Phase = UploadProcessing
+	})
+}
+
+// stored marks a file as written to the upload dir
+func (p *uploadProgress) stored(field, fileName string) {
+	if p == nil {
+		return
+	}
+	p.report(func(up *UploadProgress) {
+		for i := range up.Files {
+			f := &up.Files[i]
+			if !f.Stored && f.Field == field && f.FileName == fileName {
+				f.Stored = true
+				return
+			}
+		}
+	})
+}
+
+// finish reports the end of the upload, and the error if it failed
+func (p *uploadProgress) finish(err error) {
+	if p == nil {
+		return
+	}
+	p.pw.Close()
+	<-p.counted
+	p.report(func(up *UploadProgress) {
+		up.Phase = UploadDone
+		if err != nil {
+			up.Error = err.Error()
+		}
+	})
+}
+
+// report applies update to the progress and passes a copy of
+// the result to OnUploadProgress and UploadTracker.
+func (p *uploadProgress) report(update func(*UploadProgress)) {
+	p.mu.Lock()
+	update(&p.progress)
+	progress := p.progress
+	progress.Files = append([]FileProgress{}, p.progress.Files...)
+	p.mu.Unlock()
+
+	if p.t.OnUploadProgress != nil {
+		p.t.OnUploadProgress(progress)
+	}
+	if p.t.UploadTracker != nil && progress.UploadID != "" {
+		p.t.UploadTracker.Update(progress)
+	}
+}
+
+// progressReader reports the number of bytes read from the request body
+type progressReader struct {
+	io.Reader
+	p *uploadProgress
+}
+
+func (r *progressReader) Read(b []byte) (int, error) {
+	n, err := r.Reader.Read(b)
+	if n > 0 {
+		r.p.report(func(up *UploadProgress) {
+			up.BytesReceived += int64(n)
+		})
+	}
+	return n, err
+}
+
+// UploadProgressTracker keeps the latest progress of uploads by their ID,
+// so that it can be served by UploadProgressHandler. Uploads are forgotten
+// once they haven't been updated for Retention, which defaults to five minutes.
+// UploadProgressHandler streams the progress of uploads that have not started
+// yet for StartTimeout, which defaults to 30 seconds.
+type UploadProgressTracker struct {
+	Retention    time.Duration
+	StartTimeout time.Duration
+
+	mu      sync.Mutex
+	uploads map[string]*trackedUpload
+	// closed and replaced whenever an upload is added
+	added chan struct{}
+}
+
+// trackedUpload is the progress of an upload, changed is closed and
+// replaced whenever the progress is updated, and closed when the upload is
+// forgotten. Claimed uploads have been started with their ID.
+type trackedUpload struct {
+	progress *UploadProgress
+	updated  time.Time
+	changed  chan struct{}
+	claimed  bool
+}
+
+// NewUploadID issues the ID of an upload tracked by UploadTracker, for
+// clients to send in the UploadIDHeader of their upload and to follow its
+// progress with UploadProgressHandler. IDs are ULIDs, which cannot be
+// guessed, and each of them can only be used by a single upload request.
+func (t *Tools) NewUploadID() (string, error) {
+	if t.UploadTracker == nil {
+		return "", ErrUploadNotTracked
+	}
+	id, err := t.NewULID()
+	if err != nil {
+		return "", err
+	}
+
+	ut := t.UploadTracker
+	ut.mu.Lock()
+	defer ut.mu.Unlock()
+	ut.upload(id.String())
+	return id.String(), nil
+}
+
+// claim reports whether id was issued by NewUploadID and not used yet,
+// and marks it as used
+func (ut *UploadProgressTracker) claim(id string) bool {
+	ut.mu.Lock()
+	defer ut.mu.Unlock()
+
+	u, ok := ut.uploads[id]
+	if !ok || u.claimed {
+		return false
+	}
+	u.claimed = true
+	return true
+}
+
+// Update stores the progress of an upload
+func (ut *UploadProgressTracker) Update(progress UploadProgress) {
+	ut.mu.Lock()
+	defer ut.mu.Unlock()
+
+	u := ut.upload(progress.UploadID)
+	u.progress = &progress
+	u.updated = time.Now()
+	u.claimed = true
+	close(u.changed)
+	u.changed = make(chan struct{})
+}
+
+// Get returns the latest progress of an upload
+func (ut *UploadProgressTracker) Get(id string) (UploadProgress, bool) {
+	ut.mu.Lock()
+	defer ut.mu.Unlock()
+
+	u, ok := ut.uploads[id]
+	if !ok || u.progress == nil {
+		return UploadProgress{}, false
+	}
+	return *u.progress, true
+}
+
+// watch returns the latest progress of an upload and a channel that is
+// closed on its next update. If the upload is not tracked, the channel is
+// closed when any upload is added instead, and no progress is returned.
+func (ut *UploadProgressTracker) watch(id string) (*UploadProgress, <-chan struct{}, bool) {
+	ut.mu.Lock()
+	defer ut.mu.Unlock()
+
+	u, ok := ut.uploads[id]
+	if !ok {
+		if ut.added == nil {
+			ut.added = make(chan struct{})
+		}
+		return nil, ut.added, false
+	}
+	return u.progress, u.changed, true
+}
+
+// startTimeout returns how long to wait for an upload to start
+func (ut *UploadProgressTracker) startTimeout() time.Duration {
+	if ut.StartTimeout == 0 {
+		return 30 * time.Second
+	}
+	return ut.StartTimeout
+}
+
+// upload returns the tracked upload with the given id, adding it if
+// needed, and forgets expired uploads. ut.mu must be held.
+func (ut *UploadProgressTracker) upload(id string) *trackedUpload {
+	retention := ut.Retention
+	if retention == 0 {
+		retention = 5 * time.Minute
+	}
+	if ut.uploads == nil {
+		ut.uploads = make(map[string]*trackedUpload)
+	}
+
+	u, ok := ut.uploads[id]
+	if ok {
+		return u
+	}
+
+	now := time.Now()
+	for k, u := range ut.uploads {
+		if now.Sub(u.updated) > retention {
+			delete(ut.uploads, k)
+			close(u.changed)
+		}
+	}
+	u = &trackedUpload{updated: now, changed: make(chan struct{})}
+	ut.uploads[id] = u
+	if ut.added != nil {
+		close(ut.added)
+		ut.added = nil
+	}
+	return u
+}
+
+// UploadProgressHandler is a handler serving the progress of an upload
+// from UploadTracker, the upload is given by the "id" query parameter.
+// It responds with the progress as JSON, or streams every update as
+// server-sent events until the upload is done or forgotten if the client
+// accepts "text/event-stream". Streams wait for uploads that are not
+// tracked yet for the StartTimeout of the tracker, then respond with a 404
+// error.
+func (t *Tools) UploadProgressHandler(w http.ResponseWriter, r *http.Request) {
+	if t.UploadTracker == nil {
+		t.ErrorJSON(w, ErrUploadNotTracked, http.StatusNotFound)
+		return
+	}
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		t.ErrorJSON(w, errors.New("Missing upload id"))
+		return
+	}
+
+	if !strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		progress, ok := t.UploadTracker.Get(id)
+		if !ok {
+			t.ErrorJSON(w, errors.New("Unknown upload id"), http.StatusNotFound)
+			return
+		}
+		t.WriteJSON(w, http.StatusOK, JSONResponse{Message: progress.Phase, Data: progress})
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		t.ErrorJSON(w, errors.New("Streaming is not supported"), http.StatusInternalServerError)
+		return
+	}
+
+	// the upload may not have started yet, so wait for it for a while
+	progress, changed, ok := t.UploadTracker.watch(id)
+	if !ok {
+		timeout := time.NewTimer(t.UploadTracker.startTimeout())
+		defer timeout.Stop()
+		for !ok {
+			select {
+			case <-changed:
+			case <-timeout.C:
+				t.ErrorJSON(w, errors.New("Unknown upload id"), http.StatusNotFound)
+				return
+			case <-r.Context().Done():
+				return
+			}
+			progress, changed, ok = t.UploadTracker.watch(id)
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		if progress != nil {
+			data, err := json.Marshal(progress)
+			if err != nil {
+				return
+			}
+			_, err = fmt.Fprintf(w, "data: %s\n\n", data)
+			if err != nil {
+				return
+			}
+			flusher.Flush()
+
+			if progress.Phase == UploadDone {
+				return
+			}
+		}
+
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		}
+		// the upload may have been forgotten meanwhile
+		progress, changed, ok = t.UploadTracker.watch(id)
+		if !ok {
+			return
+		}
+	}
+}
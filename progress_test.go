@@ -0,0 +1,185 @@
+package webmod
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTools_UploadProgress(t *testing.T) {
+	tname := "Upload progress"
+	data := bytes.Repeat([]byte("a"), 100*1024)
+
+	var updates []UploadProgress
+	testTools := Tools{
+		OnUploadProgress: func(p UploadProgress) { updates = append(updates, p) },
+		UploadTracker:    &UploadProgressTracker{},
+	}
+
+	id, err := testTools.NewUploadID()
+	if err != nil || !testTools.IsValidULID(id) {
+		t.Fatalf("Wrong upload id: %q %v", id, err)
+	}
+	r := newUploadRequest(t, testUpload{field: "file", filename: "a.txt", data: data})
+	r.Header.Set(UploadIDHeader, id)
+	bodySize := r.ContentLength
+
+	_, err = testTools.UploadOneFile(r, t.TempDir())
+	if err != nil {
+		printErr(t, tname, err.Error())
+	}
+
+	if len(updates) < 3 {
+		printErr(t, tname, "Expected several progress updates", fmt.Sprintf("Received: %d", len(updates)))
+		return
+	}
+	if updates[0].Phase != UploadReceiving {
+		printErr(t, tname, "Wrong first phase", fmt.Sprintf("Received: %s", updates[0].Phase))
+	}
+
+	last := updates[len(updates)-1]
+	if last.Phase != UploadDone || last.Error != "" {
+		printErr(t, tname, "Upload not reported as done", fmt.Sprintf("Received: %+v", last))
+	}
+	if last.BytesReceived != bodySize || last.TotalBytes != bodySize {
+		expected := fmt.Sprintf("Expected: %d", bodySize)
+		received := fmt.Sprintf("Received: %d of %d", last.BytesReceived, last.TotalBytes)
+		printErr(t, tname, "Wrong number of bytes received", expected, received)
+	}
+	if len(last.Files) != 1 || last.Files[0].BytesReceived != int64(len(data)) || !last.Files[0].Stored {
+		printErr(t, tname, "Wrong file progress", fmt.Sprintf("Received: %+v", last.Files))
+	}
+
+	// the tracked progress is served as JSON
+	rec := httptest.NewRecorder()
+	testTools.UploadProgressHandler(rec, httptest.NewRequest(http.MethodGet, "/progress?id="+id, nil))
+	var jdata struct {
+		Data UploadProgress `json:"data"`
+	}
+	err = json.NewDecoder(rec.Body).Decode(&jdata)
+	if err != nil || jdata.Data.Phase != UploadDone {
+		printErr(t, "Poll progress", "Wrong progress served", fmt.Sprintf("Received: %+v", jdata.Data))
+	}
+
+	// and as server-sent events
+	rec = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/progress?id="+id, nil)
+	req.Header.Set("Accept", "text/event-stream")
+	testTools.UploadProgressHandler(rec, req)
+	if rec.Header().Get("Content-Type") != "text/event-stream" || !strings.HasPrefix(rec.Body.String(), "data: {") {
+		printErr(t, "Stream progress", "Wrong event stream", fmt.Sprintf("Received: %s", rec.Body.String()))
+	}
+
+	// unknown uploads are not found
+	rec = httptest.NewRecorder()
+	testTools.UploadProgressHandler(rec, httptest.NewRequest(http.MethodGet, "/progress?id=nope", nil))
+	if rec.Code != http.StatusNotFound {
+		printErr(t, "Unknown upload", "Wrong status code", fmt.Sprintf("Received: %d", rec.Code))
+	}
+}
+
+func TestTools_UploadProgressIDs(t *testing.T) {
+	testTools := Tools{UploadTracker: &UploadProgressTracker{}}
+	upload := func(id, filename string) {
+		r := newUploadRequest(t, testUpload{field: "file", filename: filename, data: []byte("hello")})
+		r.Header.Set(UploadIDHeader, id)
+		_, err := testTools.UploadOneFile(r, t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// IDs chosen by clients are not tracked
+	upload("chosen", "a.txt")
+	if _, ok := testTools.UploadTracker.Get("chosen"); ok {
+		printErr(t, "Client ID", "Upload tracked")
+	}
+
+	// issued IDs are tracked once, later uploads cannot replace the progress
+	id, err := testTools.NewUploadID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	upload(id, "a.txt")
+	upload(id, "b.txt")
+	progress, ok := testTools.UploadTracker.Get(id)
+	if !ok || len(progress.Files) != 1 || progress.Files[0].FileName != "a.txt" {
+		printErr(t, "Issued ID", "Wrong progress", fmt.Sprintf("Received: %v %+v", ok, progress))
+	}
+
+	_, err = (&Tools{}).NewUploadID()
+	if err != ErrUploadNotTracked {
+		printErr(t, "No tracker", "Wrong error", fmt.Sprintf("Received: %v", err))
+	}
+}
+
+func TestTools_UploadProgressStreamForgotten(t *testing.T) {
+	tracker := &UploadProgressTracker{Retention: 50 * time.Millisecond}
+	testTools := Tools{UploadTracker: tracker}
+	tracker.Update(UploadProgress{UploadID: "stalled", Phase: UploadReceiving})
+
+	// the upload is forgotten while it is streamed, ending the stream
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		tracker.Update(UploadProgress{UploadID: "other", Phase: UploadReceiving})
+	}()
+	done := make(chan *httptest.ResponseRecorder, 1)
+	go func() {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/progress?id=stalled", nil)
+		req.Header.Set("Accept", "text/event-stream")
+		testTools.UploadProgressHandler(rec, req)
+		done <- rec
+	}()
+
+	select {
+	case rec := <-done:
+		if !strings.Contains(rec.Body.String(), `"phase":"receiving"`) {
+			printErr(t, "Forgotten upload", "Wrong event stream", fmt.Sprintf("Received: %s", rec.Body.String()))
+		}
+	case <-time.After(5 * time.Second):
+		printErr(t, "Forgotten upload", "Stream not ended")
+	}
+}
+
+func TestTools_UploadProgressStreamStart(t *testing.T) {
+	tracker := &UploadProgressTracker{StartTimeout: 100 * time.Millisecond}
+	testTools := Tools{UploadTracker: tracker}
+
+	stream := func(id string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/progress?id="+id, nil)
+		req.Header.Set("Accept", "text/event-stream")
+		testTools.UploadProgressHandler(rec, req)
+		return rec
+	}
+
+	// other uploads starting do not end the wait for this one
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		tracker.Update(UploadProgress{UploadID: "other", Phase: UploadReceiving})
+		time.Sleep(20 * time.Millisecond)
+		tracker.Update(UploadProgress{UploadID: "late", Phase: UploadDone})
+	}()
+	rec := stream("late")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"phase":"done"`) {
+		printErr(t, "Late upload", "Wrong event stream", fmt.Sprintf("Received: %d %s", rec.Code, rec.Body.String()))
+	}
+
+	// uploads that never start are not found, and not tracked
+	rec = stream("nope")
+	if rec.Code != http.StatusNotFound {
+		printErr(t, "Unknown upload", "Wrong status code", fmt.Sprintf("Received: %d", rec.Code))
+	}
+	tracker.mu.Lock()
+	_, tracked := tracker.uploads["nope"]
+	tracker.mu.Unlock()
+	if tracked {
+		printErr(t, "Unknown upload", "Unknown upload tracked")
+	}
+}
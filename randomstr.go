@@ -17,3 +17,26 @@ func (t *Tools) RandomString(n int) string {
 
 	return string(s)
 }
+
+// randomFrom returns a string of n characters picked uniformly
+// from source, which must have less than 256 characters.
+func randomFrom(source string, n int) (string, error) {
+	// random bytes at or above max would make some characters more likely
+	max := 256 - 256%len(source)
+
+	s := make([]byte, 0, n)
+	buf := make([]byte, n+n/2)
+	for len(s) < n {
+		_, err := rand.Read(buf)
+		if err != nil {
+			return "", err
+		}
+		for _, b := range buf {
+			if int(b) < max && len(s) < n {
+				s = append(s, source[int(b)%len(source)])
+			}
+		}
+	}
+
+	return string(s), nil
+}
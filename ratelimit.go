@@ -0,0 +1,266 @@
+package webmod
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Errors returned by RateLimit
+var (
+	ErrRateLimited        = errors.New("Too many requests")
+	ErrRateLimitPolicy    = errors.New("Invalid rate limit policy")
+	ErrRateLimitStoreDown = errors.New("Rate limit store unavailable")
+)
+
+// RateLimitPolicy allows Requests per Period. By default it is a token
+// bucket, refilled at Requests per Period and holding Burst requests
+// (defaulting to Requests). With SlidingWindow, no more than Requests are
+// allowed within any Period, using the weighted count of the previous and
+// current windows.
+type RateLimitPolicy struct {
+	Requests      int
+	Period        time.Duration
+	Burst         int
+	SlidingWindow bool
+}
+
+// burst returns the size of the token bucket
+func (p RateLimitPolicy) burst() int {
+	if p.Burst > 0 {
+		return p.Burst
+	}
+	return p.Requests
+}
+
+// RateLimitResult is the outcome of taking a request from a limit.
+// Reset is how long until the limit is fully available again, and
+// RetryAfter how long until a denied request would be allowed.
+type RateLimitResult struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	Reset      time.Duration
+	RetryAfter time.Duration
+}
+
+// RateLimitStore keeps the state of rate limits by key. Stores can be shared
+// by several servers, and by several limits if their keys do not collide.
+type RateLimitStore interface {
+	// Take takes a request from the limit of key, following policy
+	Take(ctx context.Context, key string, policy RateLimitPolicy) (RateLimitResult, error)
+}
+
+// RateLimitOptions configures RateLimit. Key returns the key requests are
+// limited by, defaulting to RateLimitByIP, and requests with an empty key
+// are limited by IP. Store defaults to a MemoryRateLimitStore of the limit.
+// If the Store fails requests are rejected, unless FailOpen is set.
+type RateLimitOptions struct {
+	Policy   RateLimitPolicy
+	Key      func(r *http.Request) string
+	Store    RateLimitStore
+	FailOpen bool
+}
+
+// RateLimitByIP returns the client IP of a request, to limit requests by IP
+func RateLimitByIP(r *http.Request) string {
+	return "ip:" + clientIP(r)
+}
+
+// RateLimitByHeader returns a key function limiting requests by the value
+// of a header, e.g. "X-API-Key"
+func RateLimitByHeader(name string) func(r *http.Request) string {
+	return func(r *http.Request) string {
+		v := r.Header.Get(name)
+		if v == "" {
+			return ""
+		}
+		return "header:" + v
+	}
+}
+
+// RateLimit is middleware limiting requests to next as described by opts.
+// Responses carry RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset
+// headers, and requests over the limit are answered with a 429 error and a
+// Retry-After header.
+func (t *Tools) RateLimit(next http.Handler, opts RateLimitOptions) http.Handler {
+	if opts.Key == nil {
+		opts.Key = RateLimitByIP
+	}
+	if opts.Store == nil {
+		opts.Store = &MemoryRateLimitStore{}
+	}
+	policy := fmt.Sprintf("%d;w=%d", opts.Policy.Requests, int(math.Ceil(opts.Policy.Period.Seconds())))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := opts.Key(r)
+		if key == "" {
+			key = RateLimitByIP(r)
+		}
+
+		res, err := opts.Store.Take(r.Context(), key, opts.Policy)
+		switch {
+		case errors.Is(err, ErrRateLimitPolicy):
+			t.ErrorJSON(w, err, http.StatusInternalServerError)
+			return
+		case err != nil && opts.FailOpen:
+			next.ServeHTTP(w, r)
+			return
+		case err != nil:
+			t.ErrorJSON(w, fmt.Errorf("%w: %s", ErrRateLimitStoreDown, err), http.StatusServiceUnavailable)
+			return
+		}
+
+		h := w.Header()
+		h.Set("RateLimit-Policy", policy)
+		h.Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+		h.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+		h.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(res.Reset)))
+		if !res.Allowed {
+			h.Set("Retry-After", strconv.Itoa(ceilSeconds(res.RetryAfter)))
+			t.ErrorJSON(w, ErrRateLimited, http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ceilSeconds returns d in whole seconds, rounded up
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// MemoryRateLimitStore keeps rate limits in memory, for a single server.
+// Keys that have not been used for long enough to be back at their full
+// limit are evicted. The zero value is ready to use.
+type MemoryRateLimitStore struct {
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+	windows map[string]*slidingWindow
+	swept   time.Time
+}
+
+// slidingWindow counts the requests of the current and previous windows
+type slidingWindow struct {
+	period   time.Duration
+	start    time.Time
+	current  int
+	previous int
+}
+
+// Take takes a request from the limit of key
+func (m *MemoryRateLimitStore) Take(ctx context.Context, key string, policy RateLimitPolicy) (RateLimitResult, error) {
+	if policy.Requests <= 0 || policy.Period <= 0 {
+		return RateLimitResult{}, ErrRateLimitPolicy
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(m.swept) > time.Minute {
+		m.sweep(now)
+	}
+	if policy.SlidingWindow {
+		return m.takeWindow(key, policy, now), nil
+	}
+	return m.takeToken(key, policy, now), nil
+}
+
+// takeToken takes a token from the bucket of key
+func (m *MemoryRateLimitStore) takeToken(key string, policy RateLimitPolicy, now time.Time) RateLimitResult {
+	if m.buckets == nil {
+		m.buckets = map[string]*tokenBucket{}
+	}
+	b, ok := m.buckets[key]
+	if !ok {
+		b = newTokenBucket(float64(policy.Requests)/policy.Period.Seconds(), float64(policy.burst()), now)
+		m.buckets[key] = b
+	}
+
+	allowed, wait := b.take(1, now)
+	return RateLimitResult{
+		Allowed:    allowed,
+		Limit:      policy.burst(),
+		Remaining:  int(b.tokens),
+		Reset:      time.Duration((b.burst - b.tokens) / b.rate * float64(time.Second)),
+		RetryAfter: wait,
+	}
+}
+
+// takeWindow counts a request in the window of key
+func (m *MemoryRateLimitStore) takeWindow(key string, policy RateLimitPolicy, now time.Time) RateLimitResult {
+	if m.windows == nil {
+		m.windows = map[string]*slidingWindow{}
+	}
+	w, ok := m.windows[key]
+	if !ok {
+		w = &slidingWindow{period: policy.Period, start: now.Truncate(policy.Period)}
+		m.windows[key] = w
+	}
+
+	// move on to the window of now
+	if elapsed := now.Sub(w.start); elapsed >= policy.Period {
+		if elapsed < 2*policy.Period {
+			w.previous = w.current
+		} else {
+			w.previous = 0
+		}
+		w.current = 0
+		w.start = now.Truncate(policy.Period)
+	}
+
+	period := float64(policy.Period)
+	elapsed := float64(now.Sub(w.start))
+	limit := float64(policy.Requests)
+	count := float64(w.previous)*(1-elapsed/period) + float64(w.current)
+
+	res := RateLimitResult{Limit: policy.Requests}
+	if count+1 <= limit {
+		w.current++
+		res.Allowed = true
+		res.Remaining = int(limit - count - 1)
+	}
+
+	// the previous window no longer counts at the end of this one,
+	// and this one no longer counts at the end of the next one
+	res.Reset = w.start.Add(policy.Period).Sub(now)
+	if w.current > 0 {
+		res.Reset += policy.Period
+	}
+	if res.Allowed {
+		return res
+	}
+
+	// wait until the previous window weighs little enough, or if this one
+	// is full, until this one weighs little enough in the next window
+	var wait float64
+	if float64(w.current)+1 <= limit {
+		wait = period*(1-(limit-float64(w.current)-1)/float64(w.previous)) - elapsed
+	} else {
+		wait = period - elapsed + period*(1-(limit-1)/float64(w.current))
+	}
+	res.RetryAfter = time.Duration(math.Max(wait, 0))
+	return res
+}
+
+// sweep evicts the keys that are back at their full limit
+func (m *MemoryRateLimitStore) sweep(now time.Time) {
+	for k, b := range m.buckets {
+		b.refill(now)
+		if b.tokens >= b.burst {
+			delete(m.buckets, k)
+		}
+	}
+	for k, w := range m.windows {
+		if now.Sub(w.start) >= 2*w.period {
+			delete(m.windows, k)
+		}
+	}
+	m.swept = now
+}
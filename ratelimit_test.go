@@ -0,0 +1,156 @@
+package webmod
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTools_RateLimit(t *testing.T) {
+	var testTool Tools
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("handled")) })
+
+	tests := []struct {
+		name     string
+		opts     RateLimitOptions
+		requests int
+		header   string
+		allowed  int
+	}{
+		{name: "Token bucket", opts: RateLimitOptions{Policy: RateLimitPolicy{Requests: 3, Period: time.Minute}}, requests: 5, allowed: 3},
+		{name: "Token bucket burst", opts: RateLimitOptions{Policy: RateLimitPolicy{Requests: 3, Period: time.Minute, Burst: 4}}, requests: 5, allowed: 4},
+		{name: "Sliding window", opts: RateLimitOptions{Policy: RateLimitPolicy{Requests: 3, Period: time.Minute, SlidingWindow: true}}, requests: 5, allowed: 3},
+		{name: "By header", opts: RateLimitOptions{Policy: RateLimitPolicy{Requests: 2, Period: time.Minute}, Key: RateLimitByHeader("X-API-Key")}, requests: 4, header: "key", allowed: 4},
+	}
+
+	for _, e := range tests {
+		handler := testTool.RateLimit(ok, e.opts)
+
+		allowed := 0
+		var last *httptest.ResponseRecorder
+		for i := 0; i < e.requests; i++ {
+			r := httptest.NewRequest("GET", "/", nil)
+			if e.header != "" {
+				// alternate between two API keys from the same IP
+				r.Header.Set("X-API-Key", fmt.Sprintf("%s%d", e.header, i%2))
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code == http.StatusOK {
+				allowed++
+			}
+			last = w
+		}
+
+		if allowed != e.allowed {
+			printErr(t, e.name, "Wrong number of allowed requests", fmt.Sprintf("Expected: %d", e.allowed), fmt.Sprintf("Received: %d", allowed))
+		}
+		if last.Header().Get("RateLimit-Limit") == "" || last.Header().Get("RateLimit-Remaining") == "" || last.Header().Get("RateLimit-Reset") == "" {
+			printErr(t, e.name, "Missing RateLimit headers", fmt.Sprintf("Received: %v", last.Header()))
+		}
+		if e.allowed < e.requests {
+			if last.Code != http.StatusTooManyRequests || !strings.Contains(last.Body.String(), ErrRateLimited.Error()) {
+				printErr(t, e.name, "Expected a 429 error", fmt.Sprintf("Received: %d %s", last.Code, last.Body.String()))
+			}
+			if last.Header().Get("Retry-After") == "" || last.Header().Get("RateLimit-Remaining") != "0" {
+				printErr(t, e.name, "Wrong headers", fmt.Sprintf("Received: %v", last.Header()))
+			}
+		}
+	}
+}
+
+// failingRateLimitStore is a RateLimitStore that is down
+type failingRateLimitStore struct{}
+
+func (failingRateLimitStore) Take(ctx context.Context, key string, policy RateLimitPolicy) (RateLimitResult, error) {
+	return RateLimitResult{}, errors.New("connection refused")
+}
+
+func TestTools_RateLimitErrors(t *testing.T) {
+	var testTool Tools
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("handled")) })
+	policy := RateLimitPolicy{Requests: 1, Period: time.Second}
+
+	tests := []struct {
+		name         string
+		opts         RateLimitOptions
+		expectedCode int
+	}{
+		{name: "Store down", opts: RateLimitOptions{Policy: policy, Store: failingRateLimitStore{}}, expectedCode: http.StatusServiceUnavailable},
+		{name: "Store down fail open", opts: RateLimitOptions{Policy: policy, Store: failingRateLimitStore{}, FailOpen: true}, expectedCode: http.StatusOK},
+		{name: "Invalid policy", opts: RateLimitOptions{Policy: RateLimitPolicy{Requests: 1}, FailOpen: true}, expectedCode: http.StatusInternalServerError},
+	}
+
+	for _, e := range tests {
+		w := httptest.NewRecorder()
+		testTool.RateLimit(ok, e.opts).ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		if w.Code != e.expectedCode {
+			printErr(t, e.name, "Wrong status", fmt.Sprintf("Expected: %d", e.expectedCode), fmt.Sprintf("Received: %d", w.Code))
+		}
+	}
+}
+
+func TestMemoryRateLimitStore_TakeWindow(t *testing.T) {
+	var store MemoryRateLimitStore
+	policy := RateLimitPolicy{Requests: 10, Period: time.Minute, SlidingWindow: true}
+	start := time.Now().Truncate(time.Minute)
+
+	tests := []struct {
+		name       string
+		at         time.Duration
+		requests   int
+		allowed    int
+		retryAfter time.Duration
+	}{
+		{name: "First window", at: 0, requests: 12, allowed: 10, retryAfter: 66 * time.Second},
+		// 10 requests of the previous window weigh 7.5 a quarter into this one
+		{name: "Quarter into second window", at: 75 * time.Second, requests: 5, allowed: 2, retryAfter: 3 * time.Second},
+		{name: "Third window", at: 3 * time.Minute, requests: 10, allowed: 10},
+	}
+
+	for _, e := range tests {
+		allowed := 0
+		var res RateLimitResult
+		for i := 0; i < e.requests; i++ {
+			res = store.takeWindow("k", policy, start.Add(e.at))
+			if res.Allowed {
+				allowed++
+			}
+		}
+		if allowed != e.allowed {
+			printErr(t, e.name, "Wrong number of allowed requests", fmt.Sprintf("Expected: %d", e.allowed), fmt.Sprintf("Received: %d", allowed))
+		}
+		if !res.Allowed && res.RetryAfter.Round(time.Second) != e.retryAfter {
+			printErr(t, e.name, "Wrong retry after", fmt.Sprintf("Expected: %s", e.retryAfter), fmt.Sprintf("Received: %s", res.RetryAfter))
+		}
+	}
+}
+
+func TestMemoryRateLimitStore_Eviction(t *testing.T) {
+	var store MemoryRateLimitStore
+	ctx := context.Background()
+
+	store.Take(ctx, "bucket", RateLimitPolicy{Requests: 100, Period: 100 * time.Millisecond})
+	store.Take(ctx, "window", RateLimitPolicy{Requests: 1, Period: 50 * time.Millisecond, SlidingWindow: true})
+	store.Take(ctx, "busy", RateLimitPolicy{Requests: 1, Period: time.Hour})
+
+	time.Sleep(150 * time.Millisecond)
+	store.mu.Lock()
+	store.sweep(time.Now())
+	store.mu.Unlock()
+
+	if _, ok := store.buckets["bucket"]; ok {
+		printErr(t, "Eviction", "Full bucket not evicted")
+	}
+	if _, ok := store.windows["window"]; ok {
+		printErr(t, "Eviction", "Old window not evicted")
+	}
+	if _, ok := store.buckets["busy"]; !ok {
+		printErr(t, "Eviction", "Used bucket evicted")
+	}
+}
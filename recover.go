@@ -0,0 +1,108 @@
+package webmod
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"runtime/debug"
+)
+
+// ErrInternal is answered to clients when a handler panics
+var ErrInternal = errors.New("Internal server error")
+
+// Recover is middleware recovering from panics in next. Panics are logged
+// with their stack trace by PanicLogger, or the standard logger if it is not
+// set, and answered with a 500 error. The panic value is only shown to
+// clients if ExposePanics is set. The headers set by next are dropped, but
+// those set before, e.g. by CORS or SecurityHeaders, are kept. If the
+// response was already started, it can no longer be replaced by an error,
+// so the connection is aborted to let the client know that the response is
+// incomplete.
+func (t *Tools) Recover(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rw := &recoverWriter{wrappedWriter: wrappedWriter{w}}
+		before := w.Header().Clone()
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			// handlers abort responses on purpose with http.ErrAbortHandler
+			if v == http.ErrAbortHandler {
+				panic(v)
+			}
+
+			stack := debug.Stack()
+			if t.PanicLogger != nil {
+				t.PanicLogger(r, v, stack)
+			} else {
+				id, _ := t.RequestIDFromContext(r.Context())
+				log.Printf("panic serving %s %s (request %s): %v\n%s", r.Method, r.URL.Path, id, v, stack)
+			}
+
+			if rw.hijacked {
+				return
+			}
+			if rw.written {
+				panic(http.ErrAbortHandler)
+			}
+			err := ErrInternal
+			if t.ExposePanics {
+				err = fmt.Errorf("%w: %v", ErrInternal, v)
+			}
+			// drop the headers set by next for the response that was not
+			// sent, keeping those of the outer middleware and the request ID
+			id := w.Header().Get(t.requestIDHeader())
+			for k := range w.Header() {
+				delete(w.Header(), k)
+			}
+			for k, v := range before {
+				w.Header()[k] = v
+			}
+			if id != "" {
+				w.Header().Set(t.requestIDHeader(), id)
+			}
+			t.ErrorJSON(w, err, http.StatusInternalServerError)
+		}()
+		next.ServeHTTP(exposeWriter(rw), r)
+	})
+}
+
+// recoverWriter is a ResponseWriter recording whether the
+// response was started
+type recoverWriter struct {
+	wrappedWriter
+	written  bool
+	hijacked bool
+}
+
+func (rw *recoverWriter) WriteHeader(status int) {
+	// informational headers do not start the response
+	if status >= 200 {
+		rw.written = true
+	}
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+func (rw *recoverWriter) Write(p []byte) (int, error) {
+	rw.written = true
+	return rw.ResponseWriter.Write(p)
+}
+
+// Flush sends any buffered data to the client, if the original
+// ResponseWriter supports it
+func (rw *recoverWriter) Flush() {
+	rw.flush(func() { rw.written = true })
+}
+
+// Hijack lets the caller take over the connection,
+// if the original ResponseWriter supports it
+func (rw *recoverWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return rw.hijack(func() error {
+		rw.hijacked = true
+		return nil
+	})
+}
@@ -0,0 +1,114 @@
+package webmod
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTools_Recover(t *testing.T) {
+	tests := []struct {
+		name          string
+		handler       http.HandlerFunc
+		expose        bool
+		expectedCode  int
+		expectedBody  string
+		hiddenBody    string
+		expectedPanic interface{}
+		logged        bool
+	}{
+		{name: "No panic", handler: func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("handled")) }, expectedCode: http.StatusOK, expectedBody: "handled"},
+		{name: "Panic", handler: func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Disposition", "attachment")
+			panic("database password is hunter2")
+		}, expectedCode: http.StatusInternalServerError, expectedBody: ErrInternal.Error(), hiddenBody: "hunter2", logged: true},
+		{name: "Exposed panic", handler: func(w http.ResponseWriter, r *http.Request) { panic("nil map") }, expose: true, expectedCode: http.StatusInternalServerError, expectedBody: "nil map", logged: true},
+		{name: "Panic after writing", handler: func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("partial"))
+			panic("nil map")
+		}, expectedCode: http.StatusOK, expectedBody: "partial", expectedPanic: http.ErrAbortHandler, logged: true},
+		{name: "Aborted", handler: func(w http.ResponseWriter, r *http.Request) { panic(http.ErrAbortHandler) }, expectedCode: http.StatusOK, expectedPanic: http.ErrAbortHandler},
+	}
+
+	for _, e := range tests {
+		var logged []byte
+		testTool := Tools{
+			ExposePanics: e.expose,
+			PanicLogger:  func(r *http.Request, v interface{}, stack []byte) { logged = stack },
+		}
+
+		w := httptest.NewRecorder()
+		var panicked interface{}
+		func() {
+			defer func() { panicked = recover() }()
+			testTool.Recover(e.handler).ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		}()
+
+		if w.Code != e.expectedCode {
+			printErr(t, e.name, "Wrong status", fmt.Sprintf("Expected: %d", e.expectedCode), fmt.Sprintf("Received: %d", w.Code))
+		}
+		if !strings.Contains(w.Body.String(), e.expectedBody) || (e.hiddenBody != "" && strings.Contains(w.Body.String(), e.hiddenBody)) {
+			printErr(t, e.name, "Wrong body", fmt.Sprintf("Received: %s", w.Body.String()))
+		}
+		if e.expectedCode == http.StatusInternalServerError && w.Header().Get("Content-Disposition") != "" {
+			printErr(t, e.name, "Headers of the failed response kept")
+		}
+		if panicked != e.expectedPanic {
+			printErr(t, e.name, "Wrong panic", fmt.Sprintf("Expected: %v", e.expectedPanic), fmt.Sprintf("Received: %v", panicked))
+		}
+		if (logged != nil) != e.logged || (e.logged && !strings.Contains(string(logged), "recover_test.go")) {
+			printErr(t, e.name, "Wrong logging", fmt.Sprintf("Received: %s", logged))
+		}
+	}
+}
+
+func TestTools_RecoverMiddlewareHeaders(t *testing.T) {
+	testTool := Tools{PanicLogger: func(r *http.Request, v interface{}, stack []byte) {}}
+	handler := testTool.RequestID(testTool.SecurityHeaders(testTool.CORS(testTool.Recover(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Disposition", "attachment")
+		w.Header().Set("X-Content-Type-Options", "changed")
+		panic("nil map")
+	})), CORSOptions{AllowedOrigins: []string{"https://app.example.com"}}), APISecurityHeaders()))
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Origin", "https://app.example.com")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	// the headers of the outer middleware are kept on the 500
+	expected := map[string]string{
+		"Access-Control-Allow-Origin": "https://app.example.com",
+		"Vary":                        "Origin",
+		"Strict-Transport-Security":   "max-age=63072000; includeSubDomains",
+		"Content-Security-Policy":     "default-src 'none'; frame-ancestors 'none'",
+		"X-Content-Type-Options":      "nosniff",
+		"Content-Disposition":         "",
+	}
+	if w.Code != http.StatusInternalServerError {
+		printErr(t, "Middleware headers", "Wrong status", fmt.Sprintf("Received: %d", w.Code))
+	}
+	for k, v := range expected {
+		if w.Header().Get(k) != v {
+			printErr(t, "Middleware headers", "Wrong "+k, fmt.Sprintf("Expected: %q", v), fmt.Sprintf("Received: %q", w.Header().Get(k)))
+		}
+	}
+	if id := w.Header().Get("X-Request-ID"); id == "" || !strings.Contains(w.Body.String(), id) {
+		printErr(t, "Middleware headers", "Request ID lost", fmt.Sprintf("Received: %v %s", w.Header(), w.Body.String()))
+	}
+}
+
+func TestTools_RecoverWriterInterfaces(t *testing.T) {
+	var testTool Tools
+
+	// handlers only see the interfaces of the original ResponseWriter
+	for name, w := range map[string]http.ResponseWriter{"Flusher": httptest.NewRecorder(), "Plain": plainWriter{httptest.NewRecorder()}} {
+		_, expected := w.(http.Flusher)
+		testTool.Recover(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if flusher, hijacker := writerInterfaces(w); flusher != expected || hijacker {
+				printErr(t, name, "Wrong interfaces", fmt.Sprintf("Received: %v %v", flusher, hijacker))
+			}
+		})).ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	}
+}
@@ -0,0 +1,318 @@
+package webmod
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RedisSessionStore keeps sessions in Redis, or any server speaking its
+// protocol (e.g. Valkey or KeyDB), so that they are shared by several
+// servers. Address is the address of the server, e.g. "localhost:6379".
+// Sessions are stored under Prefix (default "session:") followed by the
+// session ID, and expire with the session.
+//
+// Connections are authenticated once and reused, keeping up to 4 idle ones
+// open until Close. A RedisSessionStore must not be copied after first use.
+type RedisSessionStore struct {
+	Address  string
+	Username string
+	Password string
+	DB       int
+	Prefix   string
+	// Timeout for a whole command, defaults to 5 seconds
+	Timeout time.Duration
+
+	mu     sync.Mutex
+	idle   []*redisConn
+	closed bool
+}
+
+// maxRedisIdleConns is the number of idle connections
+// kept open by a RedisSessionStore
+const maxRedisIdleConns = 4
+
+// errRedisNil is the nil reply of Redis, e.g. for a missing key
+var errRedisNil = errors.New("redis: nil")
+
+// redisError is an error reply of Redis, e.g. for a wrong password
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+// Load returns the data of a session
+func (s *RedisSessionStore) Load(ctx context.Context, id string) ([]byte, bool, error) {
+	reply, err := s.command(ctx, "GET", s.key(id))
+	if errors.Is(err, errRedisNil) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	data, ok := reply.([]byte)
+	if !ok {
+		return nil, false, fmt.Errorf("redis: unexpected reply: %v", reply)
+	}
+	return data, true, nil
+}
+
+// Save stores the data of a session until expires
+func (s *RedisSessionStore) Save(ctx context.Context, id string, data []byte, expires time.Time) error {
+	ttl := time.Until(expires).Milliseconds()
+	if ttl <= 0 {
+		return s.Delete(ctx, id)
+	}
+	_, err := s.command(ctx, "SET", s.key(id), string(data), "PX", strconv.FormatInt(ttl, 10))
+	return err
+}
+
+// Delete removes a session
+func (s *RedisSessionStore) Delete(ctx context.Context, id string) error {
+	_, err := s.command(ctx, "DEL", s.key(id))
+	return err
+}
+
+// key returns the Redis key of a session
+func (s *RedisSessionStore) key(id string) string {
+	if s.Prefix == "" {
+		return "session:" + id
+	}
+	return s.Prefix + id
+}
+
+// command sends a command to Redis on an idle connection, or on a new one
+// after authenticating and selecting the database, and returns its reply.
+func (s *RedisSessionStore) command(ctx context.Context, args ...string) (interface{}, error) {
+	timeout := s.Timeout
+	if timeout == 0 {
+		timeout = 5 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	for {
+		conn, reused, err := s.conn(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		commands := [][]string{args}
+		if !reused {
+			// the setup commands and the command are pipelined
+			commands = append(s.setup(), args)
+		}
+		replies, err := conn.do(ctx, commands)
+		if ctx.Err() != nil {
+			conn.Close()
+			return nil, ctx.Err()
+		}
+		if err != nil {
+			conn.Close()
+			// an idle connection may have been closed by the server meanwhile
+			if reused {
+				continue
+			}
+			return nil, err
+		}
+
+		// a connection failing its setup is not kept
+		for _, reply := range replies[:len(replies)-1] {
+			if err, ok := reply.(redisError); ok {
+				conn.Close()
+				return nil, err
+			}
+		}
+		s.release(conn)
+		if err, ok := replies[len(replies)-1].(error); ok {
+			return nil, err
+		}
+		return replies[len(replies)-1], nil
+	}
+}
+
+// setup returns the commands authenticating and selecting the database
+func (s *RedisSessionStore) setup() [][]string {
+	var commands [][]string
+	if s.Password != "" {
+		if s.Username != "" {
+			commands = append(commands, []string{"AUTH", s.Username, s.Password})
+		} else {
+			commands = append(commands, []string{"AUTH", s.Password})
+		}
+	}
+	if s.DB != 0 {
+		commands = append(commands, []string{"SELECT", strconv.Itoa(s.DB)})
+	}
+	return commands
+}
+
+// conn returns an idle connection, and true, or a new one
+func (s *RedisSessionStore) conn(ctx context.Context) (*redisConn, bool, error) {
+	s.mu.Lock()
+	if n := len(s.idle); n > 0 {
+		conn := s.idle[n-1]
+		s.idle = s.idle[:n-1]
+		s.mu.Unlock()
+		return conn, true, nil
+	}
+	s.mu.Unlock()
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", s.Address)
+	if err != nil {
+		return nil, false, err
+	}
+	return &redisConn{Conn: conn, r: bufio.NewReader(conn), w: bufio.NewWriter(conn)}, false, nil
+}
+
+// release keeps a connection for the next commands, or closes
+// it if there are enough idle connections
+func (s *RedisSessionStore) release(conn *redisConn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed || len(s.idle) >= maxRedisIdleConns {
+		conn.Close()
+		return
+	}
+	s.idle = append(s.idle, conn)
+}
+
+// Close closes the idle connections. The store can still be used,
+// but no longer keeps connections open.
+func (s *RedisSessionStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	for _, conn := range s.idle {
+		conn.Close()
+	}
+	s.idle = nil
+	return nil
+}
+
+// redisConn is a connection to Redis with its buffers
+type redisConn struct {
+	net.Conn
+	r *bufio.Reader
+	w *bufio.Writer
+}
+
+// do sends pipelined commands and returns their replies, with error and nil
+// replies as errors. An error is only returned when the connection failed, it
+// is closed if ctx is cancelled during the commands.
+func (c *redisConn) do(ctx context.Context, commands [][]string) ([]interface{}, error) {
+	deadline, _ := ctx.Deadline()
+	err := c.SetDeadline(deadline)
+	if err != nil {
+		return nil, err
+	}
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		select {
+		case <-ctx.Done():
+			c.Close()
+		case <-stop:
+		}
+	}()
+	// the connection is not released before the goroutine is done with it
+	defer func() {
+		close(stop)
+		<-done
+	}()
+
+	for _, cmd := range commands {
+		writeRedisCommand(c.w, cmd)
+	}
+	err = c.w.Flush()
+	if err != nil {
+		return nil, err
+	}
+
+	replies := make([]interface{}, len(commands))
+	for i := range commands {
+		replies[i], err = readRedisReply(c.r)
+		var redisErr redisError
+		if errors.As(err, &redisErr) || errors.Is(err, errRedisNil) {
+			replies[i] = err
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	return replies, nil
+}
+
+// writeRedisCommand writes a command as an array of bulk strings
+func writeRedisCommand(w *bufio.Writer, args []string) {
+	fmt.Fprintf(w, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(w, "$%d\r\n%s\r\n", len(a), a)
+	}
+}
+
+// readRedisReply reads a reply, returning a string for simple strings,
+// an int64 for integers, []byte for bulk strings and []interface{} for
+// arrays. Error replies are returned as redisError and nil replies as
+// errRedisNil, in arrays they are items of type redisError and nil.
+func readRedisReply(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("redis: malformed reply: %q", line)
+	}
+	kind, value := line[0], line[1:len(line)-2]
+
+	switch kind {
+	case '+':
+		return value, nil
+	case '-':
+		return nil, redisError(value)
+	case ':':
+		return strconv.ParseInt(value, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("redis: malformed reply: %q", line)
+		}
+		if n < 0 {
+			return nil, errRedisNil
+		}
+		data := make([]byte, n+2)
+		_, err = io.ReadFull(r, data)
+		if err != nil {
+			return nil, err
+		}
+		return data[:n], nil
+	case '*':
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("redis: malformed reply: %q", line)
+		}
+		if n < 0 {
+			return nil, errRedisNil
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			items[i], err = readRedisReply(r)
+			var redisErr redisError
+			if errors.As(err, &redisErr) {
+				items[i] = redisErr
+			} else if err != nil && !errors.Is(err, errRedisNil) {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("redis: malformed reply: %q", line)
+}
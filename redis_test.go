@@ -0,0 +1,264 @@
+package webmod
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeRedis serves the AUTH, SELECT, GET, SET (with PX) and DEL
+// commands of Redis on l, requiring password if it is not empty.
+func fakeRedis(l net.Listener, password string) {
+	var mu sync.Mutex
+	values := map[string]string{}
+	expires := map[string]time.Time{}
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+
+		go func(conn net.Conn) {
+			defer conn.Close()
+			r := bufio.NewReader(conn)
+			authenticated := password == ""
+
+			for {
+				reply, err := readRedisReply(r)
+				if err != nil {
+					return
+				}
+				items := reply.([]interface{})
+				args := make([]string, len(items))
+				for i, item := range items {
+					args[i] = string(item.([]byte))
+				}
+
+				mu.Lock()
+				switch cmd := strings.ToUpper(args[0]); {
+				case cmd == "AUTH":
+					authenticated = args[len(args)-1] == password
+					if authenticated {
+						conn.Write([]byte("+OK\r\n"))
+					} else {
+						conn.Write([]byte("-WRONGPASS invalid password\r\n"))
+					}
+				case !authenticated:
+					conn.Write([]byte("-NOAUTH Authentication required.\r\n"))
+				case cmd == "SELECT":
+					conn.Write([]byte("+OK\r\n"))
+				case cmd == "GET":
+					v, ok := values[args[1]]
+					if !ok || !time.Now().Before(expires[args[1]]) {
+						conn.Write([]byte("$-1\r\n"))
+					} else {
+						fmt.Fprintf(conn, "$%d\r\n%s\r\n", len(v), v)
+					}
+				case cmd == "SET" && len(args) == 5 && strings.ToUpper(args[3]) == "PX":
+					ms, _ := strconv.Atoi(args[4])
+					values[args[1]] = args[2]
+					expires[args[1]] = time.Now().Add(time.Duration(ms) * time.Millisecond)
+					conn.Write([]byte("+OK\r\n"))
+				case cmd == "DEL":
+					_, ok := values[args[1]]
+					delete(values, args[1])
+					if ok {
+						conn.Write([]byte(":1\r\n"))
+					} else {
+						conn.Write([]byte(":0\r\n"))
+					}
+				default:
+					conn.Write([]byte("-ERR unknown command\r\n"))
+				}
+				mu.Unlock()
+			}
+		}(conn)
+	}
+}
+
+// startFakeRedis starts a fake Redis server requiring password
+func startFakeRedis(t *testing.T, password string) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("Unable to listen: %s", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go fakeRedis(l, password)
+	return l.Addr().String()
+}
+
+func TestRedisSessionStore_Auth(t *testing.T) {
+	address := startFakeRedis(t, "secret")
+	ctx := context.Background()
+
+	tests := []struct {
+		name     string
+		store    *RedisSessionStore
+		errorExp string
+	}{
+		{name: "Password", store: &RedisSessionStore{Address: address, Password: "secret", DB: 2}},
+		{name: "ACL user", store: &RedisSessionStore{Address: address, Username: "app", Password: "secret"}},
+		{name: "Wrong password", store: &RedisSessionStore{Address: address, Password: "wrong"}, errorExp: "WRONGPASS"},
+		{name: "No password", store: &RedisSessionStore{Address: address}, errorExp: "NOAUTH"},
+	}
+
+	for _, e := range tests {
+		err := e.store.Save(ctx, "id", []byte("data"), time.Now().Add(time.Minute))
+		if e.errorExp == "" && err != nil || e.errorExp != "" && (err == nil || !strings.Contains(err.Error(), e.errorExp)) {
+			printErr(t, e.name, "Unexpected error", fmt.Sprintf("Expected: %s", e.errorExp), fmt.Sprintf("Received: %v", err))
+		}
+	}
+}
+
+// countingListener counts the connections it accepts
+type countingListener struct {
+	net.Listener
+	accepted int32
+}
+
+func (l *countingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err == nil {
+		atomic.AddInt32(&l.accepted, 1)
+	}
+	return conn, err
+}
+
+func TestRedisSessionStore_Reuse(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("Unable to listen: %s", err)
+	}
+	cl := &countingListener{Listener: l}
+	defer l.Close()
+	go fakeRedis(cl, "secret")
+
+	ctx := context.Background()
+	store := &RedisSessionStore{Address: l.Addr().String(), Password: "secret", DB: 2}
+	defer store.Close()
+
+	// commands one after the other use a single connection
+	for i := 0; i < 10; i++ {
+		err := store.Save(ctx, "id", []byte("data"), time.Now().Add(time.Minute))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, ok, err := store.Load(ctx, "id")
+		if err != nil || !ok {
+			printErr(t, "Sequential", "Unable to load", fmt.Sprintf("Received: %v %v", ok, err))
+		}
+	}
+	if n := atomic.LoadInt32(&cl.accepted); n != 1 {
+		printErr(t, "Sequential", "Connections not reused", "Expected: 1", fmt.Sprintf("Received: %d", n))
+	}
+
+	// concurrent commands open more connections, and some are kept
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			store.Load(ctx, "id")
+		}()
+	}
+	wg.Wait()
+	store.mu.Lock()
+	idle := len(store.idle)
+	store.mu.Unlock()
+	if idle < 1 || idle > maxRedisIdleConns {
+		printErr(t, "Concurrent", "Wrong number of idle connections", fmt.Sprintf("Received: %d", idle))
+	}
+
+	// a dead idle connection is replaced, authenticating again
+	store.Close()
+	store = &RedisSessionStore{Address: l.Addr().String(), Password: "secret", DB: 2}
+	defer store.Close()
+	store.Delete(ctx, "other")
+	store.idle[0].Conn.Close()
+	before := atomic.LoadInt32(&cl.accepted)
+	_, ok, err := store.Load(ctx, "id")
+	if err != nil || !ok {
+		printErr(t, "Dead connection", "Unable to load", fmt.Sprintf("Received: %v %v", ok, err))
+	}
+	if n := atomic.LoadInt32(&cl.accepted); n != before+1 {
+		printErr(t, "Dead connection", "Connection not replaced", fmt.Sprintf("Received: %d", n-before))
+	}
+}
+
+func TestRedisSessionStore_ErrorReplies(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("Unable to listen: %s", err)
+	}
+	cl := &countingListener{Listener: l}
+	defer l.Close()
+	go fakeRedis(cl, "secret")
+	ctx := context.Background()
+
+	// error replies are returned as is, keeping the connection
+	store := &RedisSessionStore{Address: l.Addr().String(), Password: "secret"}
+	defer store.Close()
+	for i := 0; i < 3; i++ {
+		_, err = store.command(ctx, "PING")
+		var redisErr redisError
+		if !errors.As(err, &redisErr) || !strings.Contains(err.Error(), "unknown command") {
+			printErr(t, "Error reply", "Unexpected error", fmt.Sprintf("Received: %v", err))
+		}
+	}
+	if n := atomic.LoadInt32(&cl.accepted); n != 1 {
+		printErr(t, "Error reply", "Command retried or connection closed", "Expected: 1", fmt.Sprintf("Received: %d", n))
+	}
+	_, ok, err := store.Load(ctx, "id")
+	if err != nil || ok {
+		printErr(t, "Error reply", "Connection not usable", fmt.Sprintf("Received: %v %v", ok, err))
+	}
+
+	// connections failing their setup are not kept
+	wrong := &RedisSessionStore{Address: l.Addr().String(), Password: "wrong"}
+	defer wrong.Close()
+	_, _, err = wrong.Load(ctx, "id")
+	if err == nil || !strings.Contains(err.Error(), "WRONGPASS") || len(wrong.idle) != 0 {
+		printErr(t, "Failed setup", "Connection kept", fmt.Sprintf("Received: %v %d", err, len(wrong.idle)))
+	}
+}
+
+func TestReadRedisReply(t *testing.T) {
+	tests := []struct {
+		name     string
+		reply    string
+		expected string
+		errorExp error
+	}{
+		{name: "Simple string", reply: "+OK\r\n", expected: "OK"},
+		{name: "Integer", reply: ":42\r\n", expected: "42"},
+		{name: "Bulk string", reply: "$5\r\na\r\nbc\r\n", expected: "[97 13 10 98 99]"},
+		{name: "Nil", reply: "$-1\r\n", errorExp: errRedisNil},
+		{name: "Array", reply: "*2\r\n$1\r\na\r\n$-1\r\n", expected: "[[97] <nil>]"},
+		{name: "Error in array", reply: "*2\r\n-ERR boom\r\n:1\r\n", expected: "[redis: ERR boom 1]"},
+	}
+
+	for _, e := range tests {
+		reply, err := readRedisReply(bufio.NewReader(strings.NewReader(e.reply)))
+		if !errors.Is(err, e.errorExp) {
+			printErr(t, e.name, "Unexpected error", fmt.Sprintf("Received: %v", err))
+			continue
+		}
+		if err == nil && fmt.Sprint(reply) != e.expected {
+			printErr(t, e.name, "Wrong reply", fmt.Sprintf("Expected: %s", e.expected), fmt.Sprintf("Received: %v", reply))
+		}
+	}
+
+	_, err := readRedisReply(bufio.NewReader(strings.NewReader("-ERR boom\r\n")))
+	if err == nil || !strings.Contains(err.Error(), "ERR boom") {
+		printErr(t, "Error", "Expected error reply", fmt.Sprintf("Received: %v", err))
+	}
+}
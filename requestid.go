@@ -0,0 +1,79 @@
+package webmod
+
+import (
+	"context"
+	"net/http"
+)
+
+// requestIDKey is the context key of the request ID
+type requestIDKey struct{}
+
+// maxRequestIDLength is the length of the longest request ID accepted
+// from clients
+const maxRequestIDLength = 128
+
+// RequestID is middleware giving every request an ID, to correlate client
+// error reports with server logs. The ID is taken from the RequestIDHeader
+// of the request if it holds a valid one, and generated otherwise. It is put
+// in the request context, echoed in the RequestIDHeader of the response and
+// included in the responses of ErrorJSON, and forwarded by PushJSONToRemote.
+func (t *Tools) RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := t.requestIDHeader()
+		id := r.Header.Get(header)
+		if !validRequestID(id) {
+			var err error
+			id, err = t.newRequestID()
+			if err != nil {
+				t.ErrorJSON(w, err, http.StatusInternalServerError)
+				return
+			}
+		}
+
+		w.Header().Set(header, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+// RequestIDFromContext returns the request ID put in ctx by RequestID
+func (t *Tools) RequestIDFromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(requestIDKey{}).(string)
+	return id, ok
+}
+
+// requestIDHeader returns the header holding request IDs
+func (t *Tools) requestIDHeader() string {
+	if t.RequestIDHeader != "" {
+		return t.RequestIDHeader
+	}
+	return "X-Request-ID"
+}
+
+// newRequestID generates a request ID, a ULID by default
+func (t *Tools) newRequestID() (string, error) {
+	if t.NewRequestID != nil {
+		return t.NewRequestID()
+	}
+	id, err := t.NewULID()
+	if err != nil {
+		return "", err
+	}
+	return id.String(), nil
+}
+
+// validRequestID reports whether id can be accepted from a client, allowing
+// only characters that cannot be used to forge log lines or headers.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':', c == '/', c == '+', c == '=':
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,81 @@
+package webmod
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTools_RequestID(t *testing.T) {
+	tests := []struct {
+		name      string
+		tool      Tools
+		header    string
+		requestID string
+		generated bool
+		expected  string
+	}{
+		{name: "Generated", header: "X-Request-ID", generated: true},
+		{name: "Accepted", header: "X-Request-ID", requestID: "3f2b1c9e-client.42", expected: "3f2b1c9e-client.42"},
+		{name: "Forged log line", header: "X-Request-ID", requestID: "1\n2024/01/01 admin logged in", generated: true},
+		{name: "Too long", header: "X-Request-ID", requestID: strings.Repeat("a", 129), generated: true},
+		{name: "Custom header", tool: Tools{RequestIDHeader: "X-Correlation-ID"}, header: "X-Correlation-ID", requestID: "abc", expected: "abc"},
+		{name: "Custom generator", tool: Tools{NewRequestID: func() (string, error) { return "custom", nil }}, header: "X-Request-ID", expected: "custom"},
+	}
+
+	for _, e := range tests {
+		testTool := e.tool
+		var fromContext string
+		handler := testTool.RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fromContext, _ = testTool.RequestIDFromContext(r.Context())
+			testTool.ErrorJSON(w, errors.New("Not found"), http.StatusNotFound)
+		}))
+
+		r := httptest.NewRequest("GET", "/", nil)
+		if e.requestID != "" {
+			r.Header.Set(e.header, e.requestID)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		id := w.Header().Get(e.header)
+		if e.generated {
+			if !testTool.IsValidULID(id) {
+				printErr(t, e.name, "Expected a generated ULID", fmt.Sprintf("Received: %q", id))
+			}
+		} else if id != e.expected {
+			printErr(t, e.name, "Wrong request ID", fmt.Sprintf("Expected: %q", e.expected), fmt.Sprintf("Received: %q", id))
+		}
+		if fromContext != id {
+			printErr(t, e.name, "Wrong request ID in context", fmt.Sprintf("Expected: %q", id), fmt.Sprintf("Received: %q", fromContext))
+		}
+
+		var jdata JSONResponse
+		json.NewDecoder(w.Body).Decode(&jdata)
+		if jdata.RequestID != id {
+			printErr(t, e.name, "Wrong request ID in error", fmt.Sprintf("Expected: %q", id), fmt.Sprintf("Received: %q", jdata.RequestID))
+		}
+	}
+}
+
+func TestTools_RequestIDRecover(t *testing.T) {
+	testTool := Tools{PanicLogger: func(r *http.Request, v interface{}, stack []byte) {}}
+	handler := testTool.RequestID(testTool.Recover(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("nil map")
+	})))
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("X-Request-ID", "abc")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	var jdata JSONResponse
+	json.NewDecoder(w.Body).Decode(&jdata)
+	if w.Header().Get("X-Request-ID") != "abc" || jdata.RequestID != "abc" {
+		printErr(t, "Recovered panic", "Request ID lost", fmt.Sprintf("Received: %v %+v", w.Header(), jdata))
+	}
+}
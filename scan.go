@@ -0,0 +1,85 @@
+package webmod
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+)
+
+// Errors returned by UploadFiles when an uploaded file is flagged by
+// the Scanner, or could not be scanned.
+var (
+	ErrFileInfected = errors.New("The uploaded file is infected")
+	ErrScanFailed   = errors.New("The uploaded file could not be scanned")
+)
+
+// ScanResult is the outcome of scanning a file
+type ScanResult struct {
+	Infected  bool
+	Signature string
+}
+
+// Scanner is implemented by malware scanners. UploadFiles calls Scan with
+// the content of every uploaded file before the file is accepted.
+type Scanner interface {
+	Scan(ctx context.Context, r io.Reader) (ScanResult, error)
+}
+
+// scanUploadedFile scans a file that has been written to f but not yet
+// committed. Infected files are moved to QuarantineDir (if set), files that
+// can't be scanned are only accepted if ScanFailOpen is set.
+func (t *Tools) scanUploadedFile(ctx context.Context, f *atomicFile, fileName string) error {
+	_, err := f.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	result, err := t.Scanner.Scan(ctx, f)
+	if err != nil {
+		if t.ScanFailOpen {
+			return nil
+		}
+		return &UploadError{FileName: fileName, Err: fmt.Errorf("%w: %s", ErrScanFailed, err)}
+	}
+	if !result.Infected {
+		return nil
+	}
+
+	if t.QuarantineDir != "" {
+		err = t.quarantine(f, fileName)
+		if err != nil {
+			return err
+		}
+	}
+
+	return &UploadError{FileName: fileName, Err: fmt.Errorf("%w: %s", ErrFileInfected, result.Signature)}
+}
+
+// quarantine moves a flagged file to QuarantineDir, under a random name
+// followed by its original name.
+func (t *Tools) quarantine(f *atomicFile, fileName string) error {
+	err := t.CreateDirIfNotExists(t.QuarantineDir)
+	if err != nil {
+		return err
+	}
+
+	// the file is copied, as the quarantine dir may be on another device
+	_, err = f.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	dst := filepath.Join(t.QuarantineDir, fmt.Sprintf("%s-%s", t.RandomString(16), filepath.Base(fileName)))
+	qfile, err := createAtomicFile(dst, t.SyncUploads)
+	if err != nil {
+		return err
+	}
+	defer qfile.Abort()
+
+	_, err = io.Copy(qfile, f)
+	if err != nil {
+		return err
+	}
+	return qfile.Commit()
+}
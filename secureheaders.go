@@ -0,0 +1,127 @@
+package webmod
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cspNonceKey is the context key of the CSP nonce
+type cspNonceKey struct{}
+
+// cspNoncePlaceholder is replaced by the nonce of each request in a
+// Content-Security-Policy
+const cspNoncePlaceholder = "{nonce}"
+
+// SecurityHeadersOptions configures SecurityHeaders. Empty values leave
+// their header out, so APISecurityHeaders and HTMLSecurityHeaders are the
+// usual starting points.
+//
+// HSTSMaxAge sets Strict-Transport-Security. ContentSecurityPolicy may use
+// "{nonce}", e.g. "script-src 'nonce-{nonce}'", replaced by a new nonce for
+// every request, which templates get with CSPNonceFromContext. With
+// CSPReportOnly the policy is only reported, to try it out. FrameOptions is
+// the X-Frame-Options, "DENY" or "SAMEORIGIN".
+type SecurityHeadersOptions struct {
+	HSTSMaxAge            time.Duration
+	HSTSIncludeSubdomains bool
+	HSTSPreload           bool
+	ContentSecurityPolicy string
+	CSPReportOnly         bool
+	NoSniff               bool
+	ReferrerPolicy        string
+	PermissionsPolicy     string
+	FrameOptions          string
+}
+
+// APISecurityHeaders returns the options for JSON APIs, whose responses
+// are never rendered or framed by browsers.
+func APISecurityHeaders() SecurityHeadersOptions {
+	return SecurityHeadersOptions{
+		HSTSMaxAge:            2 * 365 * 24 * time.Hour,
+		HSTSIncludeSubdomains: true,
+		ContentSecurityPolicy: "default-src 'none'; frame-ancestors 'none'",
+		NoSniff:               true,
+		ReferrerPolicy:        "no-referrer",
+		FrameOptions:          "DENY",
+	}
+}
+
+// HTMLSecurityHeaders returns the options for HTML apps, only running
+// their own scripts and styles, or those carrying the nonce of the request.
+func HTMLSecurityHeaders() SecurityHeadersOptions {
+	return SecurityHeadersOptions{
+		HSTSMaxAge:            2 * 365 * 24 * time.Hour,
+		HSTSIncludeSubdomains: true,
+		ContentSecurityPolicy: "default-src 'self'; script-src 'self' 'nonce-{nonce}'; style-src 'self' 'nonce-{nonce}'; " +
+			"object-src 'none'; base-uri 'self'; form-action 'self'; frame-ancestors 'self'",
+		NoSniff:           true,
+		ReferrerPolicy:    "strict-origin-when-cross-origin",
+		PermissionsPolicy: "camera=(), microphone=(), geolocation=(), payment=(), usb=()",
+		FrameOptions:      "SAMEORIGIN",
+	}
+}
+
+// SecurityHeaders is middleware setting the security headers described by
+// opts on every response. They are set before next is called, which can
+// still change them for its own responses.
+func (t *Tools) SecurityHeaders(next http.Handler, opts SecurityHeadersOptions) http.Handler {
+	hsts := ""
+	if opts.HSTSMaxAge > 0 {
+		hsts = "max-age=" + strconv.Itoa(int(opts.HSTSMaxAge/time.Second))
+		if opts.HSTSIncludeSubdomains {
+			hsts += "; includeSubDomains"
+		}
+		if opts.HSTSPreload {
+			hsts += "; preload"
+		}
+	}
+	cspHeader := "Content-Security-Policy"
+	if opts.CSPReportOnly {
+		cspHeader = "Content-Security-Policy-Report-Only"
+	}
+	useNonce := strings.Contains(opts.ContentSecurityPolicy, cspNoncePlaceholder)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		if hsts != "" {
+			h.Set("Strict-Transport-Security", hsts)
+		}
+		if opts.ContentSecurityPolicy != "" {
+			csp := opts.ContentSecurityPolicy
+			if useNonce {
+				nonce, err := randomFrom(base62, 22)
+				if err != nil {
+					t.ErrorJSON(w, err, http.StatusInternalServerError)
+					return
+				}
+				csp = strings.ReplaceAll(csp, cspNoncePlaceholder, nonce)
+				r = r.WithContext(context.WithValue(r.Context(), cspNonceKey{}, nonce))
+			}
+			h.Set(cspHeader, csp)
+		}
+		if opts.NoSniff {
+			h.Set("X-Content-Type-Options", "nosniff")
+		}
+		if opts.ReferrerPolicy != "" {
+			h.Set("Referrer-Policy", opts.ReferrerPolicy)
+		}
+		if opts.PermissionsPolicy != "" {
+			h.Set("Permissions-Policy", opts.PermissionsPolicy)
+		}
+		if opts.FrameOptions != "" {
+			h.Set("X-Frame-Options", opts.FrameOptions)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// CSPNonceFromContext returns the Content-Security-Policy nonce of the
+// request put in ctx by SecurityHeaders, for the nonce attributes of
+// scripts and styles.
+func (t *Tools) CSPNonceFromContext(ctx context.Context) (string, bool) {
+	nonce, ok := ctx.Value(cspNonceKey{}).(string)
+	return nonce, ok
+}
@@ -0,0 +1,100 @@
+package webmod
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTools_SecurityHeaders(t *testing.T) {
+	var testTool Tools
+
+	tests := []struct {
+		name     string
+		opts     SecurityHeadersOptions
+		expected map[string]string
+		nonce    bool
+	}{
+		{name: "API", opts: APISecurityHeaders(), expected: map[string]string{
+			"Strict-Transport-Security": "max-age=63072000; includeSubDomains",
+			"Content-Security-Policy":   "default-src 'none'; frame-ancestors 'none'",
+			"X-Content-Type-Options":    "nosniff",
+			"Referrer-Policy":           "no-referrer",
+			"Permissions-Policy":        "",
+			"X-Frame-Options":           "DENY",
+		}},
+		{name: "HTML", opts: HTMLSecurityHeaders(), expected: map[string]string{
+			"Strict-Transport-Security": "max-age=63072000; includeSubDomains",
+			"X-Content-Type-Options":    "nosniff",
+			"Referrer-Policy":           "strict-origin-when-cross-origin",
+			"Permissions-Policy":        "camera=(), microphone=(), geolocation=(), payment=(), usb=()",
+			"X-Frame-Options":           "SAMEORIGIN",
+		}, nonce: true},
+		{name: "Report only", opts: SecurityHeadersOptions{ContentSecurityPolicy: "default-src 'self'", CSPReportOnly: true, HSTSMaxAge: time.Hour, HSTSPreload: true}, expected: map[string]string{
+			"Content-Security-Policy":             "",
+			"Content-Security-Policy-Report-Only": "default-src 'self'",
+			"Strict-Transport-Security":           "max-age=3600; preload",
+		}},
+		{name: "Zero value", expected: map[string]string{
+			"Strict-Transport-Security": "",
+			"Content-Security-Policy":   "",
+			"X-Content-Type-Options":    "",
+			"X-Frame-Options":           "",
+		}},
+	}
+
+	for _, e := range tests {
+		var nonces []string
+		handler := testTool.SecurityHeaders(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			nonce, ok := testTool.CSPNonceFromContext(r.Context())
+			if ok {
+				nonces = append(nonces, nonce)
+			}
+		}), e.opts)
+
+		var w *httptest.ResponseRecorder
+		for i := 0; i < 2; i++ {
+			w = httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		}
+
+		for k, v := range e.expected {
+			if w.Header().Get(k) != v {
+				printErr(t, e.name, "Wrong "+k, fmt.Sprintf("Expected: %q", v), fmt.Sprintf("Received: %q", w.Header().Get(k)))
+			}
+		}
+
+		if !e.nonce {
+			if len(nonces) != 0 {
+				printErr(t, e.name, "Unexpected nonce", fmt.Sprintf("Received: %v", nonces))
+			}
+			continue
+		}
+		// every request gets its own nonce, used in the policy
+		if len(nonces) != 2 || len(nonces[1]) != 22 || nonces[0] == nonces[1] {
+			printErr(t, e.name, "Wrong nonces", fmt.Sprintf("Received: %v", nonces))
+			continue
+		}
+		csp := w.Header().Get("Content-Security-Policy")
+		if strings.Contains(csp, "{nonce}") || strings.Count(csp, "'nonce-"+nonces[1]+"'") != 2 {
+			printErr(t, e.name, "Nonce not in policy", fmt.Sprintf("Received: %s", csp))
+		}
+	}
+}
+
+func TestTools_SecurityHeadersOverride(t *testing.T) {
+	var testTool Tools
+	// a handler serving a page meant to be framed changes the frame options
+	handler := testTool.SecurityHeaders(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Del("X-Frame-Options")
+	}), APISecurityHeaders())
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Header().Get("X-Frame-Options") != "" || w.Header().Get("X-Content-Type-Options") != "nosniff" {
+		printErr(t, "Override", "Wrong headers", fmt.Sprintf("Received: %v", w.Header()))
+	}
+}
@@ -0,0 +1,366 @@
+package webmod
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Errors returned by sessions
+var (
+	ErrNoSessionStore   = errors.New("No session store")
+	ErrSessionDestroyed = errors.New("Session has been destroyed")
+)
+
+// the length of session IDs, 43 base62 characters hold more than 256 bits
+const sessionIDLen = 43
+
+// SessionStore stores the data of sessions by ID, until they expire
+type SessionStore interface {
+	// Load returns the data of a session, and false
+	// if there is no such session or it has expired
+	Load(ctx context.Context, id string) ([]byte, bool, error)
+	// Save stores the data of a session until expires
+	Save(ctx context.Context, id string, data []byte, expires time.Time) error
+	// Delete removes a session
+	Delete(ctx context.Context, id string) error
+}
+
+// SessionOptions configures LoadSession. The session ID is kept in the
+// CookieName cookie (default "session"), set with SetSecureCookie and the
+// Cookie options, so CookieKeys must be set. Sessions expire after
+// IdleTimeout without requests (default 30 minutes), and AbsoluteTimeout
+// after they were created (default 24 hours).
+type SessionOptions struct {
+	Store           SessionStore
+	CookieName      string
+	IdleTimeout     time.Duration
+	AbsoluteTimeout time.Duration
+	Cookie          CookieOptions
+}
+
+// withDefaults returns the options with the defaults set for zero values
+func (o SessionOptions) withDefaults() SessionOptions {
+	if o.CookieName == "" {
+		o.CookieName = "session"
+	}
+	if o.IdleTimeout == 0 {
+		o.IdleTimeout = 30 * time.Minute
+	}
+	if o.AbsoluteTimeout == 0 {
+		o.AbsoluteTimeout = 24 * time.Hour
+	}
+	return o
+}
+
+// Session is the session of a request, with values stored as JSON.
+// It is safe for concurrent use.
+type Session struct {
+	mu        sync.Mutex
+	id        string
+	oldID     string
+	values    map[string]json.RawMessage
+	created   time.Time
+	lastSeen  time.Time
+	modified  bool
+	destroyed bool
+}
+
+// sessionRecord is the stored form of a session
+type sessionRecord struct {
+	Values   map[string]json.RawMessage `json:"values"`
+	Created  time.Time                  `json:"created"`
+	LastSeen time.Time                  `json:"last_seen"`
+}
+
+// ID returns the ID of the session, which is empty for
+// a new session until it is saved
+func (s *Session) ID() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.id
+}
+
+// Get decodes the value of key into value,
+// and reports whether the session holds key.
+func (s *Session) Get(key string, value interface{}) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, ok := s.values[key]
+	if !ok {
+		return false, nil
+	}
+	return true, json.Unmarshal(data, value)
+}
+
+// Set sets key to value, encoded as JSON
+func (s *Session) Set(key string, value interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.destroyed {
+		return ErrSessionDestroyed
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	s.values[key] = data
+	s.modified = true
+	return nil
+}
+
+// Delete removes key from the session
+func (s *Session) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.values[key]; ok {
+		delete(s.values, key)
+		s.modified = true
+	}
+}
+
+// Renew gives the session a new ID, keeping its values. It should be called
+// whenever the privileges of the user change, e.g. when they log in, so that
+// an ID planted by an attacker before that is of no use (session fixation).
+func (s *Session) Renew() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.destroyed {
+		return ErrSessionDestroyed
+	}
+	id, err := randomFrom(base62, sessionIDLen)
+	if err != nil {
+		return err
+	}
+	if s.oldID == "" {
+		s.oldID = s.id
+	}
+	s.id = id
+	s.modified = true
+	return nil
+}
+
+// Destroy removes the session and all its values, e.g. when the user logs out
+func (s *Session) Destroy() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.values = map[string]json.RawMessage{}
+	s.destroyed = true
+}
+
+// sessionKey is the context key of the session of a request
+type sessionKey struct{}
+
+// LoadSession is middleware that loads the session of a request from the
+// Sessions store, and saves it once the handler starts writing its response.
+// The session is put in the request context, see SessionFromContext. New
+// sessions are only stored, and given a cookie, once a value is set.
+// Requests are answered with a 500 error when the session can not be loaded
+// or saved, the error itself is logged, see ErrorLogger.
+func (t *Tools) LoadSession(next http.Handler) http.Handler {
+	opts := t.Sessions.withDefaults()
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if opts.Store == nil {
+			t.internalError(w, r, ErrNoSessionStore)
+			return
+		}
+		if len(t.CookieKeys) == 0 {
+			t.internalError(w, r, ErrNoCookieKey)
+			return
+		}
+		if err := t.CookieKeys[0].check(ErrNoCookieKey); err != nil {
+			t.internalError(w, r, err)
+			return
+		}
+
+		s, err := t.loadSession(r, opts)
+		if err != nil {
+			t.internalError(w, r, err)
+			return
+		}
+
+		sw := &sessionWriter{wrappedWriter: wrappedWriter{w}, tools: t, r: r}
+		sw.commit = func() error {
+			return t.saveSession(w, r, opts, s)
+		}
+		next.ServeHTTP(exposeWriter(sw), r.WithContext(context.WithValue(r.Context(), sessionKey{}, s)))
+		sw.WriteHeader(http.StatusOK)
+	})
+}
+
+// SessionFromContext returns the session put in ctx by LoadSession
+func (t *Tools) SessionFromContext(ctx context.Context) (*Session, bool) {
+	s, ok := ctx.Value(sessionKey{}).(*Session)
+	return s, ok
+}
+
+// loadSession returns the session of r, or a new session if it
+// has none or it has expired
+func (t *Tools) loadSession(r *http.Request, opts SessionOptions) (*Session, error) {
+	now := time.Now()
+	fresh := &Session{values: map[string]json.RawMessage{}, created: now, lastSeen: now}
+
+	var id string
+	err := t.GetSecureCookie(r, opts.CookieName, &id)
+	if err != nil {
+		return fresh, nil
+	}
+	data, ok, err := opts.Store.Load(r.Context(), id)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return fresh, nil
+	}
+
+	var rec sessionRecord
+	err = json.Unmarshal(data, &rec)
+	if err != nil {
+		return fresh, nil
+	}
+	if now.Sub(rec.LastSeen) > opts.IdleTimeout || now.Sub(rec.Created) > opts.AbsoluteTimeout {
+		err = opts.Store.Delete(r.Context(), id)
+		if err != nil {
+			return nil, err
+		}
+		return fresh, nil
+	}
+
+	if rec.Values == nil {
+		rec.Values = map[string]json.RawMessage{}
+	}
+	return &Session{id: id, values: rec.Values, created: rec.Created, lastSeen: now}, nil
+}
+
+// saveSession stores s, and sets or removes its cookie
+func (t *Tools) saveSession(w http.ResponseWriter, r *http.Request, opts SessionOptions, s *Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ctx := r.Context()
+
+	if s.oldID != "" {
+		err := opts.Store.Delete(ctx, s.oldID)
+		if err != nil {
+			return err
+		}
+		s.oldID = ""
+	}
+
+	if s.destroyed {
+		if s.id != "" {
+			err := opts.Store.Delete(ctx, s.id)
+			if err != nil {
+				return err
+			}
+			t.DeleteCookie(w, opts.CookieName, opts.Cookie)
+		}
+		return nil
+	}
+
+	// new sessions are only stored once they hold something
+	isNew := s.id == ""
+	if isNew && !s.modified {
+		return nil
+	}
+	if isNew {
+		id, err := randomFrom(base62, sessionIDLen)
+		if err != nil {
+			return err
+		}
+		s.id = id
+	}
+
+	data, err := json.Marshal(sessionRecord{Values: s.values, Created: s.created, LastSeen: s.lastSeen})
+	if err != nil {
+		return err
+	}
+	expires := s.lastSeen.Add(opts.IdleTimeout)
+	if absolute := s.created.Add(opts.AbsoluteTimeout); absolute.Before(expires) {
+		expires = absolute
+	}
+	err = opts.Store.Save(ctx, s.id, data, expires)
+	if err != nil {
+		return err
+	}
+
+	if isNew || s.modified {
+		cookie := opts.Cookie
+		cookie.MaxAge = time.Until(s.created.Add(opts.AbsoluteTimeout))
+		return t.SetSecureCookie(w, opts.CookieName, s.id, cookie)
+	}
+	return nil
+}
+
+// sessionWriter is a ResponseWriter saving the session of a request
+// before the response headers are written
+type sessionWriter struct {
+	wrappedWriter
+	tools   *Tools
+	r       *http.Request
+	commit  func() error
+	saved   bool
+	written bool
+	failed  bool
+}
+
+// save saves the session once, and reports whether it was saved
+func (sw *sessionWriter) save() bool {
+	if !sw.saved {
+		sw.saved = true
+		err := sw.commit()
+		if err != nil {
+			sw.failed = true
+			// the response is replaced by the error
+			sw.written = true
+			sw.tools.internalError(sw.ResponseWriter, sw.r, err)
+		}
+	}
+	return !sw.failed
+}
+
+func (sw *sessionWriter) WriteHeader(status int) {
+	if sw.save() && !sw.written {
+		sw.written = true
+		sw.ResponseWriter.WriteHeader(status)
+	}
+}
+
+func (sw *sessionWriter) Write(p []byte) (int, error) {
+	sw.WriteHeader(http.StatusOK)
+	if sw.failed {
+		return len(p), nil
+	}
+	return sw.ResponseWriter.Write(p)
+}
+
+// Flush sends any buffered data to the client, if the original
+// ResponseWriter supports it
+func (sw *sessionWriter) Flush() {
+	sw.WriteHeader(http.StatusOK)
+	if !sw.failed {
+		sw.flush(nil)
+	}
+}
+
+// Hijack lets the caller take over the connection,
+// if the original ResponseWriter supports it
+func (sw *sessionWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return sw.hijack(func() error {
+		if !sw.save() {
+			return errors.New("Unable to save the session")
+		}
+		sw.written = true
+		return nil
+	})
+}
@@ -0,0 +1,185 @@
+package webmod
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sessionClient sends requests to a handler, keeping its cookies
+type sessionClient struct {
+	handler http.Handler
+	cookies map[string]*http.Cookie
+}
+
+func (c *sessionClient) get(path string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", path, nil)
+	for _, cookie := range c.cookies {
+		r.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	c.handler.ServeHTTP(w, r)
+
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.MaxAge < 0 {
+			delete(c.cookies, cookie.Name)
+		} else {
+			c.cookies[cookie.Name] = cookie
+		}
+	}
+	return w
+}
+
+func TestTools_LoadSession(t *testing.T) {
+	store := &MemorySessionStore{}
+	testTool := Tools{
+		CookieKeys: []SigningKey{{ID: "k1", Secret: []byte("test signing secret")}},
+		Sessions:   SessionOptions{Store: store},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+		s, _ := testTool.SessionFromContext(r.Context())
+		s.Renew()
+		s.Set("user", "alice")
+		w.Write([]byte("logged in"))
+	})
+	mux.HandleFunc("/whoami", func(w http.ResponseWriter, r *http.Request) {
+		s, _ := testTool.SessionFromContext(r.Context())
+		var user string
+		s.Get("user", &user)
+		w.Write([]byte(user))
+	})
+	mux.HandleFunc("/logout", func(w http.ResponseWriter, r *http.Request) {
+		s, _ := testTool.SessionFromContext(r.Context())
+		s.Destroy()
+	})
+	client := &sessionClient{handler: testTool.LoadSession(mux), cookies: map[string]*http.Cookie{}}
+
+	// anonymous requests get no session
+	client.get("/whoami")
+	if len(client.cookies) != 0 || len(store.sessions) != 0 {
+		printErr(t, "Anonymous", "No session should be stored")
+	}
+
+	// an attacker plants a session ID, which is replaced on login
+	attacker := &sessionClient{handler: client.handler, cookies: map[string]*http.Cookie{}}
+	attacker.get("/login")
+	planted := attacker.cookies["session"]
+	client.cookies["session"] = planted
+
+	client.get("/login")
+	if client.cookies["session"].Value == planted.Value {
+		printErr(t, "Login", "Session ID not renewed")
+	}
+	if w := client.get("/whoami"); w.Body.String() != "alice" {
+		printErr(t, "Logged in", "Wrong user", fmt.Sprintf("Received: %s", w.Body.String()))
+	}
+	if len(store.sessions) != 1 {
+		printErr(t, "Login", "Old session not removed", fmt.Sprintf("Sessions: %d", len(store.sessions)))
+	}
+
+	client.get("/logout")
+	if _, ok := client.cookies["session"]; ok || len(store.sessions) != 0 {
+		printErr(t, "Logout", "Session not destroyed")
+	}
+	if w := client.get("/whoami"); w.Body.String() != "" {
+		printErr(t, "Logged out", "Session still loaded", fmt.Sprintf("Received: %s", w.Body.String()))
+	}
+}
+
+func TestTools_LoadSessionTimeouts(t *testing.T) {
+	handler := func(testTool *Tools) http.Handler {
+		return testTool.LoadSession(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			s, _ := testTool.SessionFromContext(r.Context())
+			var n int
+			s.Get("n", &n)
+			s.Set("n", n+1)
+			fmt.Fprint(w, n+1)
+		}))
+	}
+	keys := []SigningKey{{ID: "k1", Secret: []byte("test signing secret")}}
+
+	tests := []struct {
+		name     string
+		options  SessionOptions
+		wait     time.Duration
+		expected string
+	}{
+		{name: "Within timeouts", options: SessionOptions{IdleTimeout: time.Minute, AbsoluteTimeout: time.Hour}, expected: "3"},
+		{name: "Idle timeout", options: SessionOptions{IdleTimeout: 100 * time.Millisecond, AbsoluteTimeout: time.Hour}, wait: 300 * time.Millisecond, expected: "1"},
+		{name: "Absolute timeout", options: SessionOptions{IdleTimeout: time.Hour, AbsoluteTimeout: 300 * time.Millisecond}, wait: 200 * time.Millisecond, expected: "1"},
+	}
+
+	for _, e := range tests {
+		e.options.Store = &MemorySessionStore{}
+		client := &sessionClient{handler: handler(&Tools{CookieKeys: keys, Sessions: e.options}), cookies: map[string]*http.Cookie{}}
+
+		var w *httptest.ResponseRecorder
+		for i := 0; i < 3; i++ {
+			w = client.get("/")
+			time.Sleep(e.wait)
+		}
+		if w.Body.String() != e.expected {
+			printErr(t, e.name, "Wrong count", fmt.Sprintf("Expected: %s", e.expected), fmt.Sprintf("Received: %s", w.Body.String()))
+		}
+	}
+}
+
+func TestTools_LoadSessionErrors(t *testing.T) {
+	handler := func(testTool *Tools) http.Handler {
+		return testTool.LoadSession(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			s, _ := testTool.SessionFromContext(r.Context())
+			s.Set("user", "alice")
+			w.Write([]byte("handled"))
+		}))
+	}
+
+	key := []SigningKey{{ID: "k1", Secret: []byte("test signing secret")}}
+	tests := []struct {
+		name        string
+		tool        *Tools
+		expectedLog error
+	}{
+		{name: "No store", tool: &Tools{CookieKeys: key}, expectedLog: ErrNoSessionStore},
+		{name: "No cookie key", tool: &Tools{Sessions: SessionOptions{Store: &MemorySessionStore{}}}, expectedLog: ErrNoCookieKey},
+		{name: "Weak cookie key", tool: &Tools{CookieKeys: []SigningKey{{ID: "k1", Secret: []byte("short")}}, Sessions: SessionOptions{Store: &MemorySessionStore{}}}, expectedLog: ErrNoCookieKey},
+		{name: "Store down", tool: &Tools{CookieKeys: key, Sessions: SessionOptions{Store: &RedisSessionStore{Address: "127.0.0.1:1"}}}},
+	}
+
+	for _, e := range tests {
+		var logged error
+		e.tool.ErrorLogger = func(r *http.Request, err error) { logged = err }
+		w := httptest.NewRecorder()
+		handler(e.tool).ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		if w.Code != http.StatusInternalServerError || !strings.Contains(w.Body.String(), ErrInternal.Error()) || strings.Contains(w.Body.String(), "handled") {
+			printErr(t, e.name, "Expected an error response", fmt.Sprintf("Received: %d %s", w.Code, w.Body.String()))
+		}
+
+		// the error is logged, not revealed
+		if logged == nil || e.expectedLog != nil && !errors.Is(logged, e.expectedLog) {
+			printErr(t, e.name, "Wrong logged error", fmt.Sprintf("Expected: %v", e.expectedLog), fmt.Sprintf("Received: %v", logged))
+		}
+		if strings.Contains(w.Body.String(), "127.0.0.1") || strings.Contains(w.Body.String(), "key") {
+			printErr(t, e.name, "Error revealed", fmt.Sprintf("Received: %s", w.Body.String()))
+		}
+	}
+}
+
+func TestTools_LoadSessionWriterInterfaces(t *testing.T) {
+	testTool := Tools{CookieKeys: []SigningKey{{ID: "k1", Secret: []byte("test signing secret")}}, Sessions: SessionOptions{Store: &MemorySessionStore{}}}
+
+	// handlers only see the interfaces of the original ResponseWriter
+	for name, w := range map[string]http.ResponseWriter{"Flusher": httptest.NewRecorder(), "Plain": plainWriter{httptest.NewRecorder()}} {
+		_, expected := w.(http.Flusher)
+		testTool.LoadSession(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if flusher, hijacker := writerInterfaces(w); flusher != expected || hijacker {
+				printErr(t, name, "Wrong interfaces", fmt.Sprintf("Received: %v %v", flusher, hijacker))
+			}
+		})).ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	}
+}
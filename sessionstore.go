@@ -0,0 +1,180 @@
+package webmod
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemorySessionStore keeps sessions in memory, for a single server.
+// The zero value is ready to use.
+type MemorySessionStore struct {
+	mu       sync.Mutex
+	sessions map[string]memorySession
+	swept    time.Time
+}
+
+// memorySession is a session kept by a MemorySessionStore
+type memorySession struct {
+	data    []byte
+	expires time.Time
+}
+
+// Load returns the data of a session
+func (m *MemorySessionStore) Load(ctx context.Context, id string) ([]byte, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.sessions[id]
+	if !ok || !time.Now().Before(s.expires) {
+		return nil, false, nil
+	}
+	return s.data, true, nil
+}
+
+// Save stores the data of a session until expires. Expired
+// sessions are removed at most once a minute.
+func (m *MemorySessionStore) Save(ctx context.Context, id string, data []byte, expires time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	if m.sessions == nil {
+		m.sessions = map[string]memorySession{}
+	}
+	if now.Sub(m.swept) > time.Minute {
+		for k, s := range m.sessions {
+			if !now.Before(s.expires) {
+				delete(m.sessions, k)
+			}
+		}
+		m.swept = now
+	}
+
+	m.sessions[id] = memorySession{data: append([]byte(nil), data...), expires: expires}
+	return nil
+}
+
+// Delete removes a session
+func (m *MemorySessionStore) Delete(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.sessions, id)
+	return nil
+}
+
+// FileSessionStore keeps sessions in files of a directory, one per session.
+// Files are named after a hash of the session ID, so that IDs can not be
+// read from the directory.
+type FileSessionStore struct {
+	dir string
+}
+
+// the extension of session files
+const sessionFileExt = ".session"
+
+// NewFileSessionStore returns a store keeping sessions in dir, which is
+// created if it does not exist.
+func (t *Tools) NewFileSessionStore(dir string) (*FileSessionStore, error) {
+	err := t.CreateDirIfNotExists(dir)
+	if err != nil {
+		return nil, err
+	}
+	return &FileSessionStore{dir: dir}, nil
+}
+
+// path returns the path of the file of a session
+func (f *FileSessionStore) path(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return filepath.Join(f.dir, hex.EncodeToString(sum[:])+sessionFileExt)
+}
+
+// Load returns the data of a session
+func (f *FileSessionStore) Load(ctx context.Context, id string) ([]byte, bool, error) {
+	data, err := os.ReadFile(f.path(id))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	// the file holds the expiry followed by the data
+	if len(data) < 8 || !time.Now().Before(time.Unix(int64(binary.BigEndian.Uint64(data)), 0)) {
+		return nil, false, nil
+	}
+	return data[8:], true, nil
+}
+
+// Save stores the data of a session until expires
+func (f *FileSessionStore) Save(ctx context.Context, id string, data []byte, expires time.Time) error {
+	file, err := createAtomicFile(f.path(id), false)
+	if err != nil {
+		return err
+	}
+	defer file.Abort()
+
+	var header [8]byte
+	binary.BigEndian.PutUint64(header[:], uint64(expires.Unix()))
+	_, err = file.Write(header[:])
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	if err != nil {
+		return err
+	}
+	return file.Commit()
+}
+
+// Delete removes a session
+func (f *FileSessionStore) Delete(ctx context.Context, id string) error {
+	err := os.Remove(f.path(id))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// DeleteExpired removes the files of expired sessions,
+// it should be called regularly.
+func (f *FileSessionStore) DeleteExpired() error {
+	entries, err := os.ReadDir(f.dir)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), sessionFileExt) {
+			continue
+		}
+		path := filepath.Join(f.dir, e.Name())
+
+		file, err := os.Open(path)
+		if err != nil {
+			continue
+		}
+		var header [8]byte
+		_, err = io.ReadFull(file, header[:])
+		file.Close()
+		if err == nil && now.Before(time.Unix(int64(binary.BigEndian.Uint64(header[:])), 0)) {
+			continue
+		}
+		err = os.Remove(path)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
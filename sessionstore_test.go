@@ -0,0 +1,79 @@
+package webmod
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSessionStores(t *testing.T) {
+	var testTool Tools
+	fileStore, err := testTool.NewFileSessionStore(filepath.Join(t.TempDir(), "sessions"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stores := []struct {
+		name  string
+		store SessionStore
+	}{
+		{name: "Memory", store: &MemorySessionStore{}},
+		{name: "File", store: fileStore},
+		{name: "Redis", store: &RedisSessionStore{Address: startFakeRedis(t, "")}},
+	}
+
+	ctx := context.Background()
+	for _, s := range stores {
+		err := s.store.Save(ctx, "a", []byte("data a"), time.Now().Add(time.Minute))
+		if err != nil {
+			t.Fatal(s.name, err)
+		}
+		err = s.store.Save(ctx, "b", []byte("data b"), time.Now().Add(time.Second))
+		if err != nil {
+			t.Fatal(s.name, err)
+		}
+
+		data, ok, err := s.store.Load(ctx, "a")
+		if err != nil || !ok || string(data) != "data a" {
+			printErr(t, s.name, "Session not loaded", fmt.Sprintf("Received: %q %t %v", data, ok, err))
+		}
+		_, ok, err = s.store.Load(ctx, "missing")
+		if err != nil || ok {
+			printErr(t, s.name, "Missing session loaded", fmt.Sprintf("Received: %t %v", ok, err))
+		}
+
+		err = s.store.Delete(ctx, "a")
+		if err != nil {
+			t.Fatal(s.name, err)
+		}
+		_, ok, _ = s.store.Load(ctx, "a")
+		if ok {
+			printErr(t, s.name, "Deleted session loaded")
+		}
+		err = s.store.Delete(ctx, "a")
+		if err != nil {
+			printErr(t, s.name, "Deleting a missing session failed", fmt.Sprintf("Received: %v", err))
+		}
+	}
+
+	// expiry is in whole seconds for files
+	time.Sleep(2 * time.Second)
+	for _, s := range stores {
+		_, ok, err := s.store.Load(ctx, "b")
+		if err != nil || ok {
+			printErr(t, s.name, "Expired session loaded", fmt.Sprintf("Received: %t %v", ok, err))
+		}
+	}
+
+	err = fileStore.DeleteExpired()
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, _ := os.ReadDir(fileStore.dir)
+	if len(entries) != 0 {
+		printErr(t, "File", "Expired session files not removed", fmt.Sprintf("Found: %d", len(entries)))
+	}
+}
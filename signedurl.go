@@ -0,0 +1,278 @@
+package webmod
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Errors returned when signing or verifying URLs
+var (
+	ErrNoSigningKey      = errors.New("No URL signing key")
+	ErrUnknownSigningKey = errors.New("Unknown URL signing key")
+	ErrURLNotSigned      = errors.New("URL is not signed")
+	ErrURLSignatureBad   = errors.New("URL signature is invalid")
+	ErrURLExpired        = errors.New("URL signature has expired")
+)
+
+// query parameters added to signed URLs
+const (
+	signedURLExpires   = "expires"
+	signedURLKeyID     = "kid"
+	signedURLBind      = "bind"
+	signedURLSignature = "signature"
+)
+
+// SigningKey is a secret used to sign URLs or cookies, identified by its ID
+// so that keys can be rotated. Secrets must be random and at least 16 bytes
+// long, keys with shorter secrets are refused.
+type SigningKey struct {
+	ID     string
+	Secret []byte
+}
+
+// minSigningKeyLength is the length of the shortest secret of a SigningKey
+const minSigningKeyLength = 16
+
+// check returns errNoKey if the secret of the key is too short to be safe
+func (k SigningKey) check(errNoKey error) error {
+	if len(k.Secret) < minSigningKeyLength {
+		return fmt.Errorf("%w: the secret of key %q is shorter than %d bytes", errNoKey, k.ID, minSigningKeyLength)
+	}
+	return nil
+}
+
+// SignedURLOptions restricts what a signed URL can be used for. Method
+// defaults to GET. If IP is set, the URL can only be used from that client
+// IP, if User is set, only by requests for which SignedURLUser returns User.
+type SignedURLOptions struct {
+	Method string
+	IP     string
+	User   string
+}
+
+// SignURL signs rawURL so that it can be used until ttl has passed, with the
+// first of URLSigningKeys. The expiry, key ID and signature are added to the
+// query of the URL, and the path and the whole query are covered by the signature.
+func (t *Tools) SignURL(rawURL string, ttl time.Duration, opts ...SignedURLOptions) (string, error) {
+	if len(t.URLSigningKeys) == 0 {
+		return "", ErrNoSigningKey
+	}
+	key := t.URLSigningKeys[0]
+	if err := key.check(ErrNoSigningKey); err != nil {
+		return "", err
+	}
+
+	var opt SignedURLOptions
+	if len(opts) > 0 {
+		opt = opts[0]
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	for _, p := range []string{signedURLExpires, signedURLKeyID, signedURLBind, signedURLSignature} {
+		q.Del(p)
+	}
+	q.Set(signedURLExpires, strconv.FormatInt(time.Now().Add(ttl).Unix(), 10))
+	q.Set(signedURLKeyID, key.ID)
+
+	var bind []string
+	if opt.IP != "" {
+		bind = append(bind, "ip")
+	}
+	if opt.User != "" {
+		bind = append(bind, "user")
+	}
+	if len(bind) > 0 {
+		q.Set(signedURLBind, strings.Join(bind, ","))
+	}
+
+	q.Set(signedURLSignature, signURL(key.Secret, opt.Method, u.Path, q, opt.IP, opt.User))
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// VerifySignedURL checks that the URL of r has been signed by SignURL with
+// one of URLSigningKeys, has not expired, and is used with the method, from
+// the IP and by the user it was signed for. Keys whose secret is too short
+// are refused.
+func (t *Tools) VerifySignedURL(r *http.Request) error {
+	q := r.URL.Query()
+	sig := q.Get(signedURLSignature)
+	if sig == "" {
+		return ErrURLNotSigned
+	}
+
+	var key *SigningKey
+	for i := range t.URLSigningKeys {
+		if t.URLSigningKeys[i].ID == q.Get(signedURLKeyID) {
+			key = &t.URLSigningKeys[i]
+			break
+		}
+	}
+	if key == nil {
+		return ErrUnknownSigningKey
+	}
+	if err := key.check(ErrNoSigningKey); err != nil {
+		return err
+	}
+
+	// the values the URL is bound to come from the request
+	var ip, user string
+	for _, b := range strings.Split(q.Get(signedURLBind), ",") {
+		switch b {
+		case "ip":
+			ip = clientIP(r)
+		case "user":
+			if t.SignedURLUser != nil {
+				user = t.SignedURLUser(r)
+			}
+			if user == "" {
+				return ErrURLSignatureBad
+			}
+		}
+	}
+
+	method := r.Method
+	if method == http.MethodHead {
+		method = http.MethodGet
+	}
+	expected := signURL(key.Secret, method, r.URL.Path, q, ip, user)
+	if !hmac.Equal([]byte(sig), []byte(expected)) {
+		return ErrURLSignatureBad
+	}
+
+	expires, err := strconv.ParseInt(q.Get(signedURLExpires), 10, 64)
+	if err != nil {
+		return ErrURLSignatureBad
+	}
+	if time.Now().Unix() > expires {
+		return ErrURLExpired
+	}
+
+	return nil
+}
+
+// signURL computes the signature of a URL, its query must not
+// contain the signature itself.
+func signURL(secret []byte, method, urlPath string, q url.Values, ip, user string) string {
+	if method == "" {
+		method = http.MethodGet
+	}
+
+	signed := url.Values{}
+	for k, v := range q {
+		if k != signedURLSignature {
+			signed[k] = v
+		}
+	}
+
+	mac := hmac.New(sha256.New, secret)
+	for _, s := range []string{strings.ToUpper(method), urlPath, signed.Encode(), ip, user} {
+		mac.Write([]byte(s))
+		mac.Write([]byte{0})
+	}
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// clientIP returns the IP of the client making the request
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// RequireSignedURL is middleware that only lets requests with a valid
+// signed URL through, and answers any other request with a 403 error.
+// Misconfigured keys are answered with a 500 error, and logged.
+func (t *Tools) RequireSignedURL(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err := t.VerifySignedURL(r)
+		if errors.Is(err, ErrNoSigningKey) {
+			t.internalError(w, r, err)
+			return
+		}
+		if err != nil {
+			t.ErrorJSON(w, err, http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// SignedDownloadURL returns a signed URL for downloading file, with the given
+// display name, from a SignedDownloadHandler served at baseURL.
+func (t *Tools) SignedDownloadURL(baseURL, file, displayName string, ttl time.Duration, opts ...SignedURLOptions) (string, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	q.Set("file", file)
+	q.Set("name", displayName)
+	u.RawQuery = q.Encode()
+
+	opt := SignedURLOptions{}
+	if len(opts) > 0 {
+		opt = opts[0]
+	}
+	opt.Method = http.MethodGet
+	return t.SignURL(u.String(), ttl, opt)
+}
+
+// SignedDownloadHandler serves files from dir with DownloadStaticFile, for
+// URLs made by SignedDownloadURL. Expired or tampered links are rejected.
+func (t *Tools) SignedDownloadHandler(dir string, opts ...DownloadOptions) http.Handler {
+	return t.RequireSignedURL(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		file := path.Clean("/" + q.Get("file"))
+		if file == "/" {
+			t.ErrorJSON(w, errors.New("File not found"), http.StatusNotFound)
+			return
+		}
+		displayName := q.Get("name")
+		if displayName == "" {
+			displayName = path.Base(file)
+		}
+		t.DownloadStaticFile(w, r, dir, file, displayName, opts...)
+	}))
+}
+
+// SignedUploadURL returns a signed URL for uploading a file
+// to a SignedUploadHandler served at baseURL.
+func (t *Tools) SignedUploadURL(baseURL string, ttl time.Duration, opts ...SignedURLOptions) (string, error) {
+	opt := SignedURLOptions{}
+	if len(opts) > 0 {
+		opt = opts[0]
+	}
+	opt.Method = http.MethodPost
+	return t.SignURL(baseURL, ttl, opt)
+}
+
+// SignedUploadHandler saves a file to uploadDir with UploadOneFile, for URLs
+// made by SignedUploadURL, and responds with the uploaded file as JSON.
+// Expired or tampered links are rejected.
+func (t *Tools) SignedUploadHandler(uploadDir string) http.Handler {
+	return t.RequireSignedURL(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		uploadedFile, err := t.UploadOneFile(r, uploadDir)
+		if err != nil {
+			t.ErrorJSON(w, err)
+			return
+		}
+		t.WriteJSON(w, http.StatusCreated, JSONResponse{Message: "File uploaded", Data: uploadedFile})
+	}))
+}
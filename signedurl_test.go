@@ -0,0 +1,161 @@
+package webmod
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTools_VerifySignedURL(t *testing.T) {
+	testTool := Tools{
+		URLSigningKeys: []SigningKey{{ID: "k2", Secret: []byte("new signing secret")}, {ID: "k1", Secret: []byte("old signing secret")}},
+		SignedURLUser:  func(r *http.Request) string { return r.Header.Get("X-User") },
+	}
+	oldTool := Tools{URLSigningKeys: []SigningKey{{ID: "k1", Secret: []byte("old signing secret")}}}
+	otherTool := Tools{URLSigningKeys: []SigningKey{{ID: "k2", Secret: []byte("other signing secret")}}}
+
+	sign := func(tool Tools, rawURL string, ttl time.Duration, opts ...SignedURLOptions) string {
+		signed, err := tool.SignURL(rawURL, ttl, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+	tamper := func(signed string) string {
+		return strings.Replace(signed, "file=a.pdf", "file=b.pdf", 1)
+	}
+
+	tests := []struct {
+		name        string
+		url         string
+		method      string
+		remoteAddr  string
+		user        string
+		expectedErr error
+	}{
+		{name: "Valid", url: sign(testTool, "/dl?file=a.pdf", time.Minute)},
+		{name: "Rotated key", url: sign(oldTool, "/dl?file=a.pdf", time.Minute)},
+		{name: "Unknown key", url: sign(Tools{URLSigningKeys: []SigningKey{{ID: "k3", Secret: []byte("unknown signing secret")}}}, "/dl", time.Minute), expectedErr: ErrUnknownSigningKey},
+		{name: "Wrong secret", url: sign(otherTool, "/dl?file=a.pdf", time.Minute), expectedErr: ErrURLSignatureBad},
+		{name: "Not signed", url: "/dl?file=a.pdf", expectedErr: ErrURLNotSigned},
+		{name: "Tampered", url: tamper(sign(testTool, "/dl?file=a.pdf", time.Minute)), expectedErr: ErrURLSignatureBad},
+		{name: "Expired", url: sign(testTool, "/dl?file=a.pdf", -time.Minute), expectedErr: ErrURLExpired},
+		{name: "Wrong method", url: sign(testTool, "/up", time.Minute, SignedURLOptions{Method: http.MethodPost}), expectedErr: ErrURLSignatureBad},
+		{name: "Bound IP", url: sign(testTool, "/dl", time.Minute, SignedURLOptions{IP: "192.0.2.1"}), remoteAddr: "192.0.2.1:1234"},
+		{name: "Wrong IP", url: sign(testTool, "/dl", time.Minute, SignedURLOptions{IP: "192.0.2.1"}), remoteAddr: "192.0.2.2:1234", expectedErr: ErrURLSignatureBad},
+		{name: "Bound user", url: sign(testTool, "/dl", time.Minute, SignedURLOptions{User: "alice"}), user: "alice"},
+		{name: "Wrong user", url: sign(testTool, "/dl", time.Minute, SignedURLOptions{User: "alice"}), user: "bob", expectedErr: ErrURLSignatureBad},
+		{name: "Missing user", url: sign(testTool, "/dl", time.Minute, SignedURLOptions{User: "alice"}), expectedErr: ErrURLSignatureBad},
+	}
+
+	for _, e := range tests {
+		method := e.method
+		if method == "" {
+			method = http.MethodGet
+		}
+		r := httptest.NewRequest(method, e.url, nil)
+		if e.remoteAddr != "" {
+			r.RemoteAddr = e.remoteAddr
+		}
+		if e.user != "" {
+			r.Header.Set("X-User", e.user)
+		}
+
+		err := testTool.VerifySignedURL(r)
+		if !errors.Is(err, e.expectedErr) {
+			expected := fmt.Sprintf("Expected: %v", e.expectedErr)
+			received := fmt.Sprintf("Received: %v", err)
+			printErr(t, e.name, "Wrong verification result", expected, received)
+		}
+	}
+}
+
+func TestTools_SignedDownloadHandler(t *testing.T) {
+	testTool := Tools{URLSigningKeys: []SigningKey{{ID: "k1", Secret: []byte("test signing secret")}}}
+	handler := testTool.SignedDownloadHandler("./testdata")
+
+	signed, err := testTool.SignedDownloadURL("/download", "red.jpg", "wall.jpg", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, signed, nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Length") != "1107051" {
+		printErr(t, "Signed download", "File not served", fmt.Sprintf("Received: %d", rec.Code))
+	}
+	if cd := rec.Header().Get("Content-Disposition"); cd != `attachment; filename="wall.jpg"` {
+		printErr(t, "Signed download", "Wrong content disposition", fmt.Sprintf("Received: %s", cd))
+	}
+
+	// another file can't be requested with the same signature
+	u, _ := url.Parse(signed)
+	q := u.Query()
+	q.Set("file", "img.png")
+	u.RawQuery = q.Encode()
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, u.String(), nil))
+	if rec.Code != http.StatusForbidden {
+		printErr(t, "Tampered download", "Wrong status code", fmt.Sprintf("Received: %d", rec.Code))
+	}
+}
+
+func TestTools_SignedUploadHandler(t *testing.T) {
+	testTool := Tools{URLSigningKeys: []SigningKey{{ID: "k1", Secret: []byte("test signing secret")}}}
+	handler := testTool.SignedUploadHandler(t.TempDir())
+
+	signed, err := testTool.SignedUploadURL("/upload", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := newUploadRequest(t, testUpload{field: "file", filename: "a.txt", data: []byte("hello")})
+	r.URL, _ = url.Parse(signed)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, r)
+	if rec.Code != http.StatusCreated {
+		printErr(t, "Signed upload", "Wrong status code", fmt.Sprintf("Received: %d", rec.Code), rec.Body.String())
+	}
+
+	// an upload link can't be used for downloads
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, signed, nil))
+	if rec.Code != http.StatusForbidden {
+		printErr(t, "Upload link used with GET", "Wrong status code", fmt.Sprintf("Received: %d", rec.Code))
+	}
+}
+
+func TestTools_SignURLWeakKeys(t *testing.T) {
+	for _, secret := range []string{"", "short secret"} {
+		tname := fmt.Sprintf("Secret %q", secret)
+		testTool := Tools{URLSigningKeys: []SigningKey{{ID: "k1", Secret: []byte(secret)}}}
+
+		_, err := testTool.SignURL("/dl?file=a.pdf", time.Minute)
+		if !errors.Is(err, ErrNoSigningKey) {
+			printErr(t, tname, "Weak key used to sign", fmt.Sprintf("Received: %v", err))
+		}
+
+		// a URL forged with the weak secret is refused
+		q := url.Values{signedURLExpires: {strconv.FormatInt(time.Now().Add(time.Minute).Unix(), 10)}, signedURLKeyID: {"k1"}}
+		q.Set(signedURLSignature, signURL([]byte(secret), http.MethodGet, "/dl", q, "", ""))
+		err = testTool.VerifySignedURL(httptest.NewRequest(http.MethodGet, "/dl?"+q.Encode(), nil))
+		if !errors.Is(err, ErrNoSigningKey) {
+			printErr(t, tname, "Weak key used to verify", fmt.Sprintf("Received: %v", err))
+		}
+
+		// the misconfiguration is logged, and not revealed to clients
+		var logged error
+		testTool.ErrorLogger = func(r *http.Request, err error) { logged = err }
+		w := httptest.NewRecorder()
+		testTool.RequireSignedURL(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/dl?"+q.Encode(), nil))
+		if w.Code != http.StatusInternalServerError || strings.Contains(w.Body.String(), "k1") || !errors.Is(logged, ErrNoSigningKey) {
+			printErr(t, tname, "Misconfiguration revealed", fmt.Sprintf("Received: %d %s", w.Code, w.Body.String()), fmt.Sprintf("Logged: %v", logged))
+		}
+	}
+}
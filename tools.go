@@ -1,11 +1,107 @@
 package webmod
 
+import (
+	"net/http"
+	"time"
+)
+
 // Tools is the type used to instantiate this module.
 // Any variable of this type will have access to all
 // the methods with the receiver *Tools.
 type Tools struct {
-	MaxFileSize        int
-	AllowedFileTypes   []string
+	// upload limits, MaxFileSize is per file, MaxUploadSize is
+	// for the whole request and MaxUploadFiles is the number of files
+	// in a request. Both sizes default to 1 GiB. MaxMemory is the part
+	// of the request kept in memory while parsing, the rest is stored in
+	// temporary files.
+	MaxFileSize      int
+	MaxUploadSize    int
+	MaxUploadFiles   int
+	MaxMemory        int
+	UploadFieldRules map[string]UploadFieldRule
+
+	AllowedFileTypes []string
+	// reject uploads whose file extension does not match the detected content type
+	RejectExtensionMismatch bool
+	// remove all files of a request if any of them fails to upload
+	TransactionalUploads bool
+	// flush uploaded files to disk before they are reported as uploaded
+	SyncUploads bool
+
+	// malware scanning of uploaded files, if the Scanner fails files are
+	// rejected unless ScanFailOpen is set. Infected files are moved to
+	// QuarantineDir if set, and removed otherwise.
+	Scanner       Scanner
+	ScanFailOpen  bool
+	QuarantineDir string
+
+	// upload progress is passed to OnUploadProgress, and kept in
+	// UploadTracker for UploadProgressHandler
+	OnUploadProgress func(UploadProgress)
+	UploadTracker    *UploadProgressTracker
+
+	// limits for ExtractArchive, zero values use the defaults of
+	// 10000 entries, 1GB uncompressed and a compression ratio of 100
+	MaxArchiveEntries   int
+	MaxArchiveSize      int
+	MaxCompressionRatio int
+
+	// keys used to sign URLs, the first one signs new URLs and all of
+	// them are accepted. SignedURLUser returns the user making a request,
+	// for URLs bound to a user.
+	URLSigningKeys []SigningKey
+	SignedURLUser  func(r *http.Request) string
+
+	// download bandwidth limits in bytes per second, DownloadResponseRate
+	// (with bursts of DownloadResponseBurst bytes) applies to every response,
+	// and DownloadBandwidth is shared by all downloads.
+	DownloadResponseRate  int
+	DownloadResponseBurst int
+	DownloadBandwidth     *Bandwidth
+
+	// argon2id parameters used by HashPassword, zero values use the
+	// defaults of 64MB of memory, 3 iterations and 2 threads
+	PasswordHashing Argon2Params
+
+	// keys used to sign and verify JWTs, the first one signs new tokens.
+	// Tokens signed by other keys are verified with JWTKeySet, if set.
+	// JWTIssuer and JWTAudience are required in verified tokens if set,
+	// and JWTLeeway allows for clock skew when checking token times.
+	// Tokens must expire, unless JWTAllowNoExpiry is set.
+	JWTKeys          []JWTKey
+	JWTKeySet        *JWKS
+	JWTIssuer        string
+	JWTAudience      string
+	JWTLeeway        time.Duration
+	JWTAllowNoExpiry bool
+
+	// options of the CSRFProtect middleware
+	CSRF CSRFOptions
+
+	// keys used to sign and encrypt cookies, the first one is used
+	// for new cookies and all of them are accepted
+	CookieKeys []SigningKey
+
+	// options of the LoadSession middleware
+	Sessions SessionOptions
+
+	// errors hidden from clients, e.g. misconfigured keys or the reasons
+	// tokens are rejected, are passed to ErrorLogger, or logged by the
+	// standard logger if it is not set
+	ErrorLogger func(r *http.Request, err error)
+
+	// panics recovered by Recover are passed to PanicLogger with their
+	// stack trace, and their value is shown to clients if ExposePanics
+	// is set, which should only be done in development
+	PanicLogger  func(r *http.Request, v interface{}, stack []byte)
+	ExposePanics bool
+
+	// request IDs are read from and echoed in RequestIDHeader, defaulting
+	// to "X-Request-ID". New request IDs are ULIDs, unless NewRequestID
+	// is set, e.g. to generate UUIDs.
+	RequestIDHeader string
+	NewRequestID    func() (string, error)
+
 	MaxJSONSize        int
 	AllowUnknownFields bool
 }
@@ -1,21 +1,73 @@
 package webmod
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"mime/multipart"
 	"net/http"
 	"os"
 	"path/filepath"
-	"strings"
 )
 
+// Errors returned by UploadFiles when an upload violates one of the limits
+// configured on Tools. They are wrapped in an *UploadError, so use errors.Is
+// to check for them.
+var (
+	ErrFileTooLarge          = errors.New("The uploaded file is too big")
+	ErrRequestTooLarge       = errors.New("The upload request is too big")
+	ErrTooManyFiles          = errors.New("Too many files uploaded")
+	ErrFieldTooManyFiles     = errors.New("Too many files uploaded for the field")
+	ErrFileTypeNotPermitted  = errors.New("The uploaded file type is not permitted")
+	ErrFileExtensionMismatch = errors.New("The uploaded file extension does not match its content")
+	ErrNoFileUploaded        = errors.New("No file uploaded")
+	ErrFileExists            = errors.New("The uploaded file would overwrite an existing file")
+)
+
+// UploadError describes a failed upload, the form field and the file
+// that caused it (if known), and the underlying error.
+type UploadError struct {
+	Field    string
+	FileName string
+	Err      error
+}
+
+func (e *UploadError) Error() string {
+	switch {
+	case e.FileName != "":
+		return fmt.Sprintf("%s (field: %q, file: %q)", e.Err, e.Field, e.FileName)
+	case e.Field != "":
+		return fmt.Sprintf("%s (field: %q)", e.Err, e.Field)
+	default:
+		return e.Err.Error()
+	}
+}
+
+func (e *UploadError) Unwrap() error {
+	return e.Err
+}
+
+// defaultMaxUploadSize is the size limit of files and upload requests
+// when MaxFileSize or MaxUploadSize are not set
+const defaultMaxUploadSize = 1024 * 1024 * 1024
+
+// UploadFieldRule holds the limits for the files uploaded in a single
+// form field. Zero values mean that the global limits on Tools apply.
+type UploadFieldRule struct {
+	MaxFiles         int
+	MaxFileSize      int
+	AllowedFileTypes []string
+}
+
 // UploadedFile is a struct used to save information
 // about an uploaded file
 type UploadedFile struct {
 	FileName         string
 	OriginalFileName string
 	FileSize         int64
+	ContentType      string
 }
 
 // UploadOneFile is just a convenience method that calls UploadFiles, but expects only one file.
@@ -29,6 +81,9 @@ func (t *Tools) UploadOneFile(r *http.Request, uploadDir string, rename ...bool)
 	if err != nil {
 		return nil, err
 	}
+	if len(uploadedFiles) == 0 {
+		return nil, &UploadError{Err: ErrNoFileUploaded}
+	}
 
 	return uploadedFiles[0], nil
 }
@@ -37,9 +92,24 @@ func (t *Tools) UploadOneFile(r *http.Request, uploadDir string, rename ...bool)
 // It returns a slice containing the newly named files, the original file name, the size of the file
 // and potentially an error. If the optional last parameter isn't set to true, then we will not rename
 // the files, but will use the original file names.
-func (t *Tools) UploadFiles(r *http.Request, uploadDir string, rename ...bool) ([]*UploadedFile, error) {
+//
+// The upload is checked against MaxFileSize, MaxUploadSize, MaxUploadFiles and
+// UploadFieldRules before any file is written to uploadDir. Both sizes default
+// to 1 GiB, and requests whose Content-Length exceeds MaxUploadSize are
+// refused before their body is read.
+//
+// The progress of the upload is reported to OnUploadProgress and UploadTracker
+// if they are set, see UploadProgress.
+//
+// Every file is written to a temporary file and renamed once it is complete,
+// so a failed upload never leaves a partial file behind. If TransactionalUploads
+// is set, a failure also removes the files of the request that were already
+// written, and no files are returned. Files are then never overwritten when
+// renaming is disabled, so that the rollback cannot remove existing files:
+// their upload fails with ErrFileExists.
+func (t *Tools) UploadFiles(r *http.Request, uploadDir string, rename ...bool) (uploadedFiles []*UploadedFile, err error) {
 	// create upload dir if not exist
-	err := t.CreateDirIfNotExists(uploadDir)
+	err = t.CreateDirIfNotExists(uploadDir)
 	if err != nil {
 		return nil, err
 	}
@@ -50,92 +120,243 @@ func (t *Tools) UploadFiles(r *http.Request, uploadDir string, rename ...bool) (
 		renameFile = rename[0]
 	}
 
-	var uploadedFiles []*UploadedFile
+	maxMemory := 32 * 1024 * 1024
+	if t.MaxMemory != 0 {
+		maxMemory = t.MaxMemory
+	}
+
+	// limit the size of the whole request body
+	var body *limitedBody
+	if r.Body != nil {
+		body = &limitedBody{ReadCloser: r.Body, remaining: int64(t.maxUploadSize())}
+		r.Body = body
+	}
+
+	// report the progress of the upload (optional)
+	progress := t.trackUploadProgress(r)
+	defer func() {
+		progress.finish(err)
+	}()
 
-	if t.MaxFileSize == 0 {
-		t.MaxFileSize = 1024 * 1024 * 1024
+	// a request announcing a larger body is refused without reading it
+	if r.ContentLength > int64(t.maxUploadSize()) {
+		return nil, &UploadError{Err: ErrRequestTooLarge}
 	}
-	err = r.ParseMultipartForm(int64(t.MaxFileSize))
+
+	err = r.ParseMultipartForm(int64(maxMemory))
+	progress.received()
 	if err != nil {
-		return nil, errors.New("The uploaded file is too big")
+		if body != nil && body.exceeded {
+			return nil, &UploadError{Err: ErrRequestTooLarge}
+		}
+		return nil, err
 	}
 
-	for _, fHeaders := range r.MultipartForm.File {
-		for _, fheader := range fHeaders {
-			uploadedFiles, err = func(uploadedFiles []*UploadedFile) ([]*UploadedFile, error) {
-				var uploadedFile UploadedFile
+	// check all the limits before writing anything to disk
+	err = t.checkUploadLimits(r.MultipartForm)
+	if err != nil {
+		return nil, err
+	}
 
-				infile, err := fheader.Open()
-				if err != nil {
-					return nil, err
+	for field, fHeaders := range r.MultipartForm.File {
+		for _, fheader := range fHeaders {
+			uploadedFile, err := t.uploadFile(r.Context(), fheader, uploadDir, renameFile, t.allowedFileTypes(field))
+			if err != nil {
+				var uerr *UploadError
+				if errors.As(err, &uerr) {
+					uerr.Field = field
 				}
-				defer infile.Close()
-
-				// Check if the filetype is permitted,
-				// In order to do that we have to read first 512 bytes of this file to figure out its mimetype
-				// and then subsequently check if the filetype is permitted
-				buf := make([]byte, 512)
-				_, err = infile.Read(buf)
-				if err != nil {
+				// remove the files written so far (optional)
+				if t.TransactionalUploads {
+					removeUploadedFiles(uploadDir, uploadedFiles)
 					return nil, err
 				}
-				// check to see if the file type is permitted
-				allowed := false
-				filetype := http.DetectContentType(buf)
-				if len(t.AllowedFileTypes) > 0 {
-					for _, aType := range t.AllowedFileTypes {
-						if strings.EqualFold(filetype, aType) {
-							allowed = true
-						}
-					}
-				} else {
-					allowed = true
-				}
-				if !allowed {
-					return nil, errors.New("The uploaded file type is not permitted")
-				}
+				return uploadedFiles, err
+			}
 
-				// If filetype is allowed, go back to beginning of the file
-				// because now we need to WRITE it
-				// since we moved ahead by 512 bytes to read filetype
-				_, err = infile.Seek(0, 0)
-				if err != nil {
-					return nil, err
-				}
-				// rename file if opted for
-				// here we generate a random string of 25 chars
-				// with same extension as the original one.
-				if renameFile {
-					uploadedFile.FileName = fmt.Sprintf("%s%s", t.RandomString(25), filepath.Ext(fheader.Filename))
-				} else {
-					uploadedFile.FileName = fheader.Filename
-				}
-				uploadedFile.OriginalFileName = fheader.Filename
-				// write file
-				var outfile *os.File
-				defer outfile.Close()
-				if outfile, err := os.Create(filepath.Join(uploadDir, uploadedFile.FileName)); err != nil {
-					return nil, err
-				} else {
-					filesize, err := io.Copy(outfile, infile)
-					if err != nil {
-						return nil, err
-					}
-					uploadedFile.FileSize = filesize
-				}
+			// Append to the list of uploaded files
+			uploadedFiles = append(uploadedFiles, uploadedFile)
+			progress.stored(field, fheader.Filename)
+		}
+	}
+
+	// fmt.Printf("%d files uploaded\n", len(uploadedFiles))
+	return uploadedFiles, nil
+}
 
-				// Append to the list of uploaded files
-				uploadedFiles = append(uploadedFiles, &uploadedFile)
+// removeUploadedFiles deletes already uploaded files from uploadDir
+func removeUploadedFiles(uploadDir string, uploadedFiles []*UploadedFile) {
+	for _, f := range uploadedFiles {
+		os.Remove(filepath.Join(uploadDir, f.FileName))
+	}
+}
 
-				return uploadedFiles, nil
-			}(uploadedFiles)
+// checkUploadLimits validates the number and sizes of the files in a parsed
+// multipart form against the global and per-field limits.
+func (t *Tools) checkUploadLimits(form *multipart.Form) error {
+	var total int
+	for field, fHeaders := range form.File {
+		total += len(fHeaders)
 
-			if err != nil {
-				return uploadedFiles, err
+		rule := t.UploadFieldRules[field]
+		if rule.MaxFiles > 0 && len(fHeaders) > rule.MaxFiles {
+			return &UploadError{Field: field, Err: ErrFieldTooManyFiles}
+		}
+
+		maxFileSize := t.maxFileSize()
+		if rule.MaxFileSize > 0 {
+			maxFileSize = rule.MaxFileSize
+		}
+		for _, fheader := range fHeaders {
+			if fheader.Size > int64(maxFileSize) {
+				return &UploadError{Field: field, FileName: fheader.Filename, Err: ErrFileTooLarge}
 			}
 		}
 	}
 
-	// fmt.Printf("%d files uploaded\n", len(uploadedFiles))
-	return uploadedFiles, nil
+	if t.MaxUploadFiles > 0 && total > t.MaxUploadFiles {
+		return &UploadError{Err: ErrTooManyFiles}
+	}
+
+	return nil
+}
+
+// maxFileSize returns MaxFileSize, or its default
+func (t *Tools) maxFileSize() int {
+	if t.MaxFileSize > 0 {
+		return t.MaxFileSize
+	}
+	return defaultMaxUploadSize
+}
+
+// maxUploadSize returns MaxUploadSize, or its default
+func (t *Tools) maxUploadSize() int {
+	if t.MaxUploadSize > 0 {
+		return t.MaxUploadSize
+	}
+	return defaultMaxUploadSize
+}
+
+// allowedFileTypes returns the file types permitted for a form field,
+// falling back to AllowedFileTypes if the field has no rule of its own.
+func (t *Tools) allowedFileTypes(field string) []string {
+	if rule, ok := t.UploadFieldRules[field]; ok && len(rule.AllowedFileTypes) > 0 {
+		return rule.AllowedFileTypes
+	}
+	return t.AllowedFileTypes
+}
+
+// uploadFile checks the type of a single uploaded file, writes it to uploadDir
+// and scans it if a Scanner is set.
+func (t *Tools) uploadFile(ctx context.Context, fheader *multipart.FileHeader, uploadDir string, renameFile bool, allowedTypes []string) (*UploadedFile, error) {
+	var uploadedFile UploadedFile
+
+	infile, err := fheader.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer infile.Close()
+
+	// Check if the filetype is permitted,
+	// In order to do that we have to read the first bytes of this file to figure out its mimetype
+	// and then subsequently check if the filetype is permitted
+	buf := make([]byte, sniffLen)
+	n, err := io.ReadFull(infile, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	// check to see if the file type is permitted
+	filetype := t.DetectContentType(buf[:n])
+	if !fileTypeAllowed(filetype, allowedTypes) {
+		return nil, &UploadError{FileName: fheader.Filename, Err: ErrFileTypeNotPermitted}
+	}
+	// check that the extension of the file matches its content (optional)
+	if t.RejectExtensionMismatch && !t.ExtensionMatchesType(fheader.Filename, filetype) {
+		return nil, &UploadError{FileName: fheader.Filename, Err: ErrFileExtensionMismatch}
+	}
+
+	// If filetype is allowed, go back to beginning of the file
+	// because now we need to WRITE it
+	// since we moved ahead to read filetype
+	_, err = infile.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+	// rename file if opted for
+	// here we generate a random string of 25 chars
+	// with the extension of the detected file type,
+	// so that the name can not lie about the content.
+	if renameFile {
+		uploadedFile.FileName = fmt.Sprintf("%s%s", t.RandomString(25), t.ExtensionForType(filetype))
+	} else {
+		uploadedFile.FileName = fheader.Filename
+	}
+	uploadedFile.OriginalFileName = fheader.Filename
+	uploadedFile.ContentType = filetype
+
+	// write file to a temporary file first, it's only moved
+	// to its final name once it has been written completely
+	outfile, err := createAtomicFile(filepath.Join(uploadDir, uploadedFile.FileName), t.SyncUploads)
+	if err != nil {
+		return nil, err
+	}
+	defer outfile.Abort()
+
+	filesize, err := io.Copy(outfile, infile)
+	if err != nil {
+		return nil, err
+	}
+	uploadedFile.FileSize = filesize
+
+	// scan the file before accepting it (optional)
+	if t.Scanner != nil {
+		err = t.scanUploadedFile(ctx, outfile, fheader.Filename)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// transactional uploads only commit files that they can remove again
+	// without losing the file they would replace
+	if t.TransactionalUploads && !renameFile {
+		if _, err := os.Lstat(filepath.Join(uploadDir, uploadedFile.FileName)); err == nil {
+			return nil, &UploadError{FileName: fheader.Filename, Err: ErrFileExists}
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	}
+	err = outfile.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return &uploadedFile, nil
+}
+
+// limitedBody is a request body that fails once more than
+// remaining bytes have been read from it.
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+	exceeded  bool
+}
+
+func (l *limitedBody) Read(p []byte) (int, error) {
+	if l.remaining <= 0 {
+		// the limit has been reached, the body is only too large
+		// if there is still something left to read
+		var b [1]byte
+		n, err := l.ReadCloser.Read(b[:])
+		if n == 0 && err != nil {
+			return 0, err
+		}
+		l.exceeded = true
+		return 0, ErrRequestTooLarge
+	}
+	if int64(len(p)) > l.remaining {
+		p = p[:l.remaining]
+	}
+	n, err := l.ReadCloser.Read(p)
+	l.remaining -= int64(n)
+	return n, err
 }
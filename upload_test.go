@@ -1,6 +1,8 @@
 package webmod
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"image"
 	"image/png"
@@ -9,6 +11,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"sync"
 	"testing"
 )
@@ -185,3 +188,241 @@ func TestTools_UploadOneFile(t *testing.T) {
 	// clean up
 	os.Remove(uploadedFilePath)
 }
+
+// testUpload is a file to be sent in a multipart test request
+type testUpload struct {
+	field    string
+	filename string
+	data     []byte
+}
+
+// newUploadRequest builds a multipart/form-data request containing the given files
+func newUploadRequest(t *testing.T, files ...testUpload) *http.Request {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	for _, f := range files {
+		part, err := writer.CreateFormFile(f.field, f.filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := part.Write(f.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writer.Close()
+
+	r := httptest.NewRequest(http.MethodPost, "/", &body)
+	r.Header.Add("Content-Type", writer.FormDataContentType())
+	return r
+}
+
+var uploadLimitTests = []struct {
+	name        string
+	tools       Tools
+	files       []testUpload
+	expectedErr error
+}{
+	{
+		name:  "Within limits",
+		tools: Tools{MaxFileSize: 1024, MaxUploadSize: 4096, MaxUploadFiles: 2},
+		files: []testUpload{
+			{field: "file", filename: "a.txt", data: []byte("hello")},
+			{field: "file", filename: "b.txt", data: []byte("world")},
+		},
+	},
+	{
+		name:        "File too large",
+		tools:       Tools{MaxFileSize: 4},
+		files:       []testUpload{{field: "file", filename: "a.txt", data: []byte("hello")}},
+		expectedErr: ErrFileTooLarge,
+	},
+	{
+		name:        "Request too large",
+		tools:       Tools{MaxUploadSize: 128},
+		files:       []testUpload{{field: "file", filename: "a.txt", data: bytes.Repeat([]byte("a"), 256)}},
+		expectedErr: ErrRequestTooLarge,
+	},
+	{
+		name:  "Too many files",
+		tools: Tools{MaxUploadFiles: 1},
+		files: []testUpload{
+			{field: "file", filename: "a.txt", data: []byte("hello")},
+			{field: "other", filename: "b.txt", data: []byte("world")},
+		},
+		expectedErr: ErrTooManyFiles,
+	},
+	{
+		name:  "Field too many files",
+		tools: Tools{UploadFieldRules: map[string]UploadFieldRule{"avatar": {MaxFiles: 1}}},
+		files: []testUpload{
+			{field: "avatar", filename: "a.txt", data: []byte("hello")},
+			{field: "avatar", filename: "b.txt", data: []byte("world")},
+		},
+		expectedErr: ErrFieldTooManyFiles,
+	},
+	{
+		name:        "Field file too large",
+		tools:       Tools{UploadFieldRules: map[string]UploadFieldRule{"avatar": {MaxFileSize: 4}}},
+		files:       []testUpload{{field: "avatar", filename: "a.txt", data: []byte("hello")}},
+		expectedErr: ErrFileTooLarge,
+	},
+	{
+		name:        "Field type not permitted",
+		tools:       Tools{UploadFieldRules: map[string]UploadFieldRule{"avatar": {AllowedFileTypes: []string{"image/png"}}}},
+		files:       []testUpload{{field: "avatar", filename: "a.txt", data: []byte("hello")}},
+		expectedErr: ErrFileTypeNotPermitted,
+	},
+}
+
+func TestTools_UploadFilesLimits(t *testing.T) {
+	uploadDir := t.TempDir()
+
+	for _, e := range uploadLimitTests {
+		r := newUploadRequest(t, e.files...)
+		_, err := e.tools.UploadFiles(r, uploadDir)
+
+		if e.expectedErr == nil && err != nil {
+			printErr(t, e.name, "Error not expected, but one received", err.Error())
+		}
+
+		if e.expectedErr != nil && !errors.Is(err, e.expectedErr) {
+			expected := fmt.Sprintf("Expected: %v", e.expectedErr)
+			received := fmt.Sprintf("Received: %v", err)
+			printErr(t, e.name, "Wrong error returned", expected, received)
+		}
+	}
+}
+
+func TestTools_UploadFilesDefaultLimits(t *testing.T) {
+	var testTool Tools
+	uploadDir := t.TempDir()
+
+	// a request announcing more than the default limit is refused unread
+	r := newUploadRequest(t, testUpload{field: "file", filename: "a.txt", data: []byte("hello")})
+	r.ContentLength = 2 * defaultMaxUploadSize
+	_, err := testTool.UploadFiles(r, uploadDir)
+	if !errors.Is(err, ErrRequestTooLarge) {
+		printErr(t, "Content-Length", "Wrong error returned", fmt.Sprintf("Received: %v", err))
+	}
+
+	// the defaults do not change the shared Tools
+	_, err = testTool.UploadFiles(newUploadRequest(t, testUpload{field: "file", filename: "a.txt", data: []byte("hello")}), uploadDir)
+	if err != nil || testTool.MaxFileSize != 0 || testTool.MaxUploadSize != 0 {
+		printErr(t, "Defaults", "Tools changed", fmt.Sprintf("Received: %v %d %d", err, testTool.MaxFileSize, testTool.MaxUploadSize))
+	}
+}
+
+func TestTools_UploadFilesExtension(t *testing.T) {
+	png, err := os.ReadFile("./testdata/img.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	uploadDir := t.TempDir()
+
+	// a renamed file gets the extension of its detected type
+	var testTools Tools
+	r := newUploadRequest(t, testUpload{field: "file", filename: "evil.html", data: png})
+	uploadedFile, err := testTools.UploadOneFile(r, uploadDir)
+	if err != nil {
+		printErr(t, "Rename with detected extension", err.Error())
+	} else if filepath.Ext(uploadedFile.FileName) != ".png" {
+		printErr(t, "Rename with detected extension", "Wrong extension", "Expected: .png", fmt.Sprintf("Received: %s", uploadedFile.FileName))
+	}
+
+	// a mismatching extension is rejected if opted for
+	testTools.RejectExtensionMismatch = true
+	r = newUploadRequest(t, testUpload{field: "file", filename: "img.exe", data: png})
+	_, err = testTools.UploadOneFile(r, uploadDir)
+	if !errors.Is(err, ErrFileExtensionMismatch) {
+		printErr(t, "Reject mismatch", "Expected extension mismatch error", fmt.Sprintf("Received: %v", err))
+	}
+}
+
+func TestTools_UploadFilesTransactionalExisting(t *testing.T) {
+	for _, transactional := range []bool{false, true} {
+		tname := fmt.Sprintf("Transactional upload: %t", transactional)
+		uploadDir := t.TempDir()
+		err := os.WriteFile(filepath.Join(uploadDir, "a.txt"), []byte("original"), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		testTools := Tools{TransactionalUploads: transactional}
+		r := newUploadRequest(t,
+			testUpload{field: "files", filename: "b.txt", data: []byte("new")},
+			testUpload{field: "files", filename: "a.txt", data: []byte("replaced")},
+		)
+		_, err = testTools.UploadFiles(r, uploadDir, false)
+
+		// transactional uploads refuse to overwrite, and roll back the rest
+		expectedErr, expected := error(nil), "replaced"
+		if transactional {
+			expectedErr, expected = ErrFileExists, "original"
+		}
+		if !errors.Is(err, expectedErr) {
+			printErr(t, tname, "Wrong error returned", fmt.Sprintf("Expected: %v", expectedErr), fmt.Sprintf("Received: %v", err))
+		}
+		data, err := os.ReadFile(filepath.Join(uploadDir, "a.txt"))
+		if err != nil || string(data) != expected {
+			printErr(t, tname, "Wrong existing file", fmt.Sprintf("Expected: %s", expected), fmt.Sprintf("Received: %s %v", data, err))
+		}
+		if _, err := os.Stat(filepath.Join(uploadDir, "b.txt")); transactional != os.IsNotExist(err) {
+			printErr(t, tname, "Wrong new file", fmt.Sprintf("Received: %v", err))
+		}
+	}
+}
+
+func TestTools_UploadFilesTransactional(t *testing.T) {
+	png, err := os.ReadFile("./testdata/img.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, transactional := range []bool{false, true} {
+		tname := fmt.Sprintf("Transactional upload: %t", transactional)
+		uploadDir := t.TempDir()
+
+		testTools := Tools{
+			TransactionalUploads: transactional,
+			SyncUploads:          true,
+			AllowedFileTypes:     []string{"image/png"},
+		}
+		// files of a field are uploaded in order, so the image is always
+		// written before the text file is rejected
+		r := newUploadRequest(t,
+			testUpload{field: "files", filename: "img.png", data: png},
+			testUpload{field: "files", filename: "notes.txt", data: []byte("hello world")},
+		)
+		uploadedFiles, err := testTools.UploadFiles(r, uploadDir)
+		if !errors.Is(err, ErrFileTypeNotPermitted) {
+			printErr(t, tname, "Expected file type error", fmt.Sprintf("Received: %v", err))
+		}
+
+		entries, err := os.ReadDir(uploadDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// no temporary files are left behind, and only the
+		// returned files remain
+		if len(entries) != len(uploadedFiles) {
+			msg := "Wrong number of files left in the upload dir"
+			expected := fmt.Sprintf("Expected: %d", len(uploadedFiles))
+			received := fmt.Sprintf("Received: %d", len(entries))
+			printErr(t, tname, msg, expected, received)
+		}
+		// the image is kept, unless the upload is rolled back
+		expectedFiles := 1
+		if transactional {
+			expectedFiles = 0
+		}
+		if len(entries) != expectedFiles {
+			msg := "Wrong files left in the upload dir"
+			expected := fmt.Sprintf("Expected: %d", expectedFiles)
+			received := fmt.Sprintf("Received: %d", len(entries))
+			printErr(t, tname, msg, expected, received)
+		}
+		if transactional && len(uploadedFiles) != 0 {
+			printErr(t, tname, "Expected no uploaded files to be returned")
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package webmod
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+)
+
+// errHijackNotSupported is returned when hijacking a ResponseWriter that
+// does not support it
+var errHijackNotSupported = errors.New("Hijacking not supported")
+
+// wrappedWriter is embedded in the ResponseWriters of middleware, passing
+// flushes and hijacks on to the original ResponseWriter
+type wrappedWriter struct {
+	http.ResponseWriter
+}
+
+// Unwrap returns the original ResponseWriter
+func (ww wrappedWriter) Unwrap() http.ResponseWriter {
+	return ww.ResponseWriter
+}
+
+// flush sends any buffered data to the client, calling before first, if
+// the original ResponseWriter supports it
+func (ww wrappedWriter) flush(before func()) {
+	f, ok := ww.ResponseWriter.(http.Flusher)
+	if !ok {
+		return
+	}
+	if before != nil {
+		before()
+	}
+	f.Flush()
+}
+
+// hijack lets the caller take over the connection, calling before first,
+// if the original ResponseWriter supports it. An error from before stops
+// the hijack.
+func (ww wrappedWriter) hijack(before func() error) (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := ww.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errHijackNotSupported
+	}
+	if before != nil {
+		err := before()
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return h.Hijack()
+}
+
+// unwrapWriter is a ResponseWriter wrapping another one
+type unwrapWriter interface {
+	http.ResponseWriter
+	Unwrap() http.ResponseWriter
+}
+
+// middlewareWriter is a ResponseWriter of middleware, made with a
+// wrappedWriter
+type middlewareWriter interface {
+	unwrapWriter
+	http.Flusher
+	http.Hijacker
+}
+
+// basicWriter, flushWriter and hijackWriter hide the optional
+// interfaces that the original ResponseWriter does not support
+type basicWriter struct {
+	unwrapWriter
+}
+
+type flushWriter struct {
+	unwrapWriter
+	http.Flusher
+}
+
+type hijackWriter struct {
+	unwrapWriter
+	http.Hijacker
+}
+
+// exposeWriter returns mw, implementing http.Flusher and http.Hijacker only
+// if the ResponseWriter it wraps does, so that handlers checking for them
+// are not misled.
+func exposeWriter(mw middlewareWriter) http.ResponseWriter {
+	_, flusher := mw.Unwrap().(http.Flusher)
+	_, hijacker := mw.Unwrap().(http.Hijacker)
+	switch {
+	case flusher && hijacker:
+		return mw
+	case flusher:
+		return flushWriter{mw, mw}
+	case hijacker:
+		return hijackWriter{mw, mw}
+	}
+	return basicWriter{mw}
+}
@@ -0,0 +1,110 @@
+package webmod
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// plainWriter is a ResponseWriter supporting neither flushes nor hijacks
+type plainWriter struct {
+	http.ResponseWriter
+}
+
+// testWriter is a middleware ResponseWriter
+type testWriter struct {
+	wrappedWriter
+}
+
+func (tw *testWriter) Flush() {
+	tw.flush(nil)
+}
+
+func (tw *testWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return tw.hijack(nil)
+}
+
+func TestWrappedWriter(t *testing.T) {
+	tests := []struct {
+		name    string
+		w       http.ResponseWriter
+		flushed bool
+	}{
+		{name: "Flusher", w: httptest.NewRecorder(), flushed: true},
+		{name: "Plain", w: plainWriter{httptest.NewRecorder()}},
+	}
+
+	for _, e := range tests {
+		ww := wrappedWriter{e.w}
+		if ww.Unwrap() != e.w {
+			printErr(t, e.name, "Wrong unwrapped ResponseWriter")
+		}
+
+		called := false
+		ww.flush(func() { called = true })
+		if called != e.flushed {
+			printErr(t, e.name, "Wrong flush", fmt.Sprintf("Expected: %v", e.flushed), fmt.Sprintf("Received: %v", called))
+		}
+		if rec, ok := e.w.(*httptest.ResponseRecorder); ok && !rec.Flushed {
+			printErr(t, e.name, "Not flushed")
+		}
+
+		// neither supports hijacking, so before is not called
+		called = false
+		_, _, err := ww.hijack(func() error {
+			called = true
+			return nil
+		})
+		if !errors.Is(err, errHijackNotSupported) || called {
+			printErr(t, e.name, "Wrong hijack", fmt.Sprintf("Received: %v %v", err, called))
+		}
+	}
+}
+
+// writerInterfaces reports whether w is an http.Flusher and an http.Hijacker
+func writerInterfaces(w http.ResponseWriter) (bool, bool) {
+	_, flusher := w.(http.Flusher)
+	_, hijacker := w.(http.Hijacker)
+	return flusher, hijacker
+}
+
+func TestExposeWriter(t *testing.T) {
+	tests := []struct {
+		name     string
+		w        http.ResponseWriter
+		flusher  bool
+		hijacker bool
+	}{
+		{name: "Flusher", w: httptest.NewRecorder(), flusher: true},
+		{name: "Plain", w: plainWriter{httptest.NewRecorder()}},
+	}
+
+	for _, e := range tests {
+		w := exposeWriter(&testWriter{wrappedWriter{e.w}})
+		flusher, hijacker := writerInterfaces(w)
+		if flusher != e.flusher || hijacker != e.hijacker {
+			printErr(t, e.name, "Wrong interfaces", fmt.Sprintf("Expected: %v %v", e.flusher, e.hijacker), fmt.Sprintf("Received: %v %v", flusher, hijacker))
+		}
+		if uw, ok := w.(interface{ Unwrap() http.ResponseWriter }); !ok || uw.Unwrap() != e.w {
+			printErr(t, e.name, "Not unwrappable")
+		}
+	}
+
+	// the ResponseWriters of servers support both
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		flusher, hijacker := writerInterfaces(exposeWriter(&testWriter{wrappedWriter{w}}))
+		if !flusher || !hijacker {
+			printErr(t, "Server", "Wrong interfaces", fmt.Sprintf("Received: %v %v", flusher, hijacker))
+		}
+	}))
+	defer server.Close()
+	res, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+}